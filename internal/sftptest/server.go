@@ -0,0 +1,862 @@
+// Package sftptest is an in-memory SFTP version 3 server for testing the
+// sftp client and the transfers built on it. It decodes requests with its own
+// code rather than the client's, and can reorder replies, shorten reads and
+// drop the connection mid-transfer.
+package sftptest
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Packet types
+const (
+	fxpInit          = 1
+	fxpVersion       = 2
+	fxpOpen          = 3
+	fxpClose         = 4
+	fxpRead          = 5
+	fxpWrite         = 6
+	fxpLstat         = 7
+	fxpFstat         = 8
+	fxpSetstat       = 9
+	fxpFsetstat      = 10
+	fxpOpendir       = 11
+	fxpReaddir       = 12
+	fxpRemove        = 13
+	fxpMkdir         = 14
+	fxpRmdir         = 15
+	fxpRealpath      = 16
+	fxpStat          = 17
+	fxpRename        = 18
+	fxpReadlink      = 19
+	fxpSymlink       = 20
+	fxpStatus        = 101
+	fxpHandle        = 102
+	fxpData          = 103
+	fxpName          = 104
+	fxpAttrs         = 105
+	fxpExtended      = 200
+	fxpExtendedReply = 201
+)
+
+// Status codes
+const (
+	statusOK            = 0
+	statusEOF           = 1
+	statusNoSuchFile    = 2
+	statusFailure       = 4
+	statusBadMessage    = 5
+	statusOpUnsupported = 8
+)
+
+const (
+	attrSize        = 0x00000001
+	attrUIDGID      = 0x00000002
+	attrPermissions = 0x00000004
+	attrACModTime   = 0x00000008
+	attrExtended    = 0x80000000
+
+	fxfRead   = 0x01
+	fxfWrite  = 0x02
+	fxfAppend = 0x04
+	fxfCreat  = 0x08
+	fxfTrunc  = 0x10
+	fxfExcl   = 0x20
+
+	modeType    = 0170000
+	modeDir     = 0040000
+	modeRegular = 0100000
+	modeSymlink = 0120000
+)
+
+// ErrCut is returned by Serve when Options.CloseAfter ended the session
+var ErrCut = errors.New("sftptest: connection cut")
+
+// Options changes how a session behaves, mostly to inject faults
+type Options struct {
+	// CheckFile announces and serves the check-file-name extension
+	CheckFile bool
+	// PosixRename announces and serves posix-rename@openssh.com
+	PosixRename bool
+	// Reorder sends the replies to requests that arrive together in reverse order
+	Reorder bool
+	// MaxRead caps the data in each read reply to force short reads
+	MaxRead int
+	// CloseAfter ends the session at the first read or write that takes the
+	// data transferred past this many bytes. That request is not served and
+	// replies still queued are dropped, as on a broken link. 0 never cuts.
+	CloseAfter int64
+}
+
+// Server holds a file tree shared by all its sessions, so a session started
+// after a cut sees what the previous one wrote
+type Server struct {
+	mu      sync.Mutex
+	files   map[string]*node
+	handles int
+	nextID  int
+}
+
+type node struct {
+	mode   uint32
+	data   []byte
+	target string
+	mtime  uint32
+}
+
+type handle struct {
+	path   string
+	node   *node
+	flags  uint32
+	dir    bool
+	listed bool
+}
+
+// NewServer returns a server with an empty root directory
+func NewServer() *Server {
+	return &Server{files: map[string]*node{"/": {mode: modeDir | 0755}}}
+}
+
+// WriteFile creates or replaces a regular file, creating its parents
+func (s *Server) WriteFile(name string, data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	name = clean(name)
+	for dir := path.Dir(name); s.files[dir] == nil; dir = path.Dir(dir) {
+		s.files[dir] = &node{mode: modeDir | 0755}
+	}
+	s.files[name] = &node{mode: modeRegular | 0644, data: append([]byte(nil), data...)}
+}
+
+// ReadFile returns the contents of a regular file
+func (s *Server) ReadFile(name string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := s.files[clean(name)]
+	if n == nil || n.mode&modeType != modeRegular {
+		return nil, false
+	}
+	return append([]byte(nil), n.data...), true
+}
+
+// Mode returns the POSIX mode of a file without following symlinks
+func (s *Server) Mode(name string) (uint32, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := s.files[clean(name)]
+	if n == nil {
+		return 0, false
+	}
+	return n.mode, true
+}
+
+// OpenHandles returns the number of handles not closed yet
+func (s *Server) OpenHandles() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.handles
+}
+
+// SHA256 hashes a regular file from offset on, as sha256sum or tail -c
+// would on a real host
+func (s *Server) SHA256(name string, offset int64) ([]byte, bool) {
+	data, ok := s.ReadFile(name)
+	if !ok {
+		return nil, false
+	}
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	sum := sha256.Sum256(data[offset:])
+	return sum[:], true
+}
+
+// Pipe starts a session in the background and returns the client end of
+// its stream, suitable for sftp.NewClientPipe
+func (s *Server) Pipe(options Options) (io.Reader, io.WriteCloser) {
+	clientR, serverW := io.Pipe()
+	serverR, clientW := io.Pipe()
+	go func() {
+		s.Serve(serverR, serverW, options)
+		serverW.Close()
+		serverR.Close()
+	}()
+	return clientR, clientW
+}
+
+// Serve runs one session until r ends or CloseAfter cuts it. The caller
+// closes the stream afterwards.
+func (s *Server) Serve(r io.Reader, w io.Writer, options Options) error {
+	sess := &session{server: s, options: options, handles: make(map[string]*handle)}
+	defer sess.closeHandles()
+
+	typ, data, err := readPacket(r)
+	if err != nil {
+		return err
+	}
+	if typ != fxpInit {
+		return fmt.Errorf("sftptest: expected init, got packet type %d", typ)
+	}
+	version := []byte{fxpVersion}
+	version = binary.BigEndian.AppendUint32(version, 3)
+	if options.PosixRename {
+		version = appendString(version, "posix-rename@openssh.com")
+		version = appendString(version, "1")
+	}
+	if options.CheckFile {
+		version = appendString(version, "check-file-name")
+		version = appendString(version, "1")
+	}
+	if err := writePacket(w, version); err != nil {
+		return err
+	}
+
+	replies := make(chan []byte, 64)
+	cut := make(chan struct{})
+	written := make(chan error, 1)
+	go func() {
+		written <- sess.writeReplies(w, replies, cut)
+	}()
+
+	for {
+		typ, data, err = readPacket(r)
+		if err != nil {
+			break
+		}
+		var reply []byte
+		reply, err = sess.handle(typ, data)
+		if err != nil {
+			break
+		}
+		replies <- reply
+	}
+
+	if err == ErrCut {
+		close(cut)
+	}
+	close(replies)
+	if werr := <-written; err == io.EOF || err == nil {
+		err = werr
+	}
+	if err == io.EOF || err == io.ErrClosedPipe {
+		err = nil
+	}
+	return err
+}
+
+type session struct {
+	server      *Server
+	options     Options
+	handles     map[string]*handle
+	transferred int64
+}
+
+// writeReplies sends replies as they come, or with Reorder in reverse
+// order of every batch received within a short window
+func (sess *session) writeReplies(w io.Writer, replies <-chan []byte, cut <-chan struct{}) error {
+	var err error
+	for reply := range replies {
+		if err != nil {
+			// Keep draining so the request loop never blocks
+			continue
+		}
+		batch := [][]byte{reply}
+		if sess.options.Reorder {
+			timer := time.NewTimer(2 * time.Millisecond)
+		collect:
+			for {
+				select {
+				case more, ok := <-replies:
+					if !ok {
+						break collect
+					}
+					batch = append(batch, more)
+				case <-timer.C:
+					break collect
+				}
+			}
+			timer.Stop()
+			for i, j := 0, len(batch)-1; i < j; i, j = i+1, j-1 {
+				batch[i], batch[j] = batch[j], batch[i]
+			}
+		}
+		for _, reply := range batch {
+			select {
+			case <-cut:
+				return nil
+			default:
+			}
+			if err = writePacket(w, reply); err != nil {
+				break
+			}
+		}
+	}
+	return err
+}
+
+func (sess *session) closeHandles() {
+	sess.server.mu.Lock()
+	defer sess.server.mu.Unlock()
+	sess.server.handles -= len(sess.handles)
+}
+
+// handle serves one request and returns the reply packet
+func (sess *session) handle(typ byte, data []byte) ([]byte, error) {
+	r := &reader{data: data}
+	id := r.uint32()
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	s := sess.server
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch typ {
+	case fxpOpen:
+		p := r.string()
+		flags := r.uint32()
+		r.attrs()
+		if r.err != nil {
+			return status(id, statusBadMessage, r.err.Error()), nil
+		}
+		n, code := s.open(p, flags)
+		if code != statusOK {
+			return status(id, code, ""), nil
+		}
+		return sess.newHandle(id, &handle{path: clean(p), node: n, flags: flags}), nil
+
+	case fxpOpendir:
+		p := r.string()
+		n, code := s.follow(p)
+		if code == statusOK && n.mode&modeType != modeDir {
+			code = statusFailure
+		}
+		if code != statusOK {
+			return status(id, code, ""), nil
+		}
+		return sess.newHandle(id, &handle{path: s.resolve(p), node: n, dir: true}), nil
+
+	case fxpClose:
+		name := r.string()
+		if _, ok := sess.handles[name]; !ok {
+			return status(id, statusFailure, "invalid handle"), nil
+		}
+		delete(sess.handles, name)
+		s.handles--
+		return status(id, statusOK, ""), nil
+
+	case fxpRead:
+		h := sess.handles[r.string()]
+		offset := r.uint64()
+		length := int64(r.uint32())
+		if h == nil || h.dir || h.flags&fxfRead == 0 {
+			return status(id, statusFailure, "invalid handle"), nil
+		}
+		if offset >= uint64(len(h.node.data)) {
+			return status(id, statusEOF, ""), nil
+		}
+		if sess.options.MaxRead > 0 && length > int64(sess.options.MaxRead) {
+			length = int64(sess.options.MaxRead)
+		}
+		chunk := h.node.data[offset:]
+		if int64(len(chunk)) > length {
+			chunk = chunk[:length]
+		}
+		if err := sess.count(len(chunk)); err != nil {
+			return nil, err
+		}
+		reply := []byte{fxpData}
+		reply = binary.BigEndian.AppendUint32(reply, id)
+		reply = appendString(reply, string(chunk))
+		return reply, nil
+
+	case fxpWrite:
+		h := sess.handles[r.string()]
+		offset := r.uint64()
+		chunk := r.bytes()
+		if r.err != nil {
+			return status(id, statusBadMessage, r.err.Error()), nil
+		}
+		if h == nil || h.dir || h.flags&fxfWrite == 0 {
+			return status(id, statusFailure, "invalid handle"), nil
+		}
+		if err := sess.count(len(chunk)); err != nil {
+			return nil, err
+		}
+		if h.flags&fxfAppend != 0 {
+			offset = uint64(len(h.node.data))
+		}
+		if end := offset + uint64(len(chunk)); end > uint64(len(h.node.data)) {
+			h.node.data = append(h.node.data, make([]byte, end-uint64(len(h.node.data)))...)
+		}
+		copy(h.node.data[offset:], chunk)
+		return status(id, statusOK, ""), nil
+
+	case fxpStat, fxpLstat:
+		p := r.string()
+		var n *node
+		code := uint32(statusOK)
+		if typ == fxpStat {
+			n, code = s.follow(p)
+		} else if n = s.files[s.resolve(p)]; n == nil {
+			code = statusNoSuchFile
+		}
+		if code != statusOK {
+			return status(id, code, ""), nil
+		}
+		return attrsReply(id, n), nil
+
+	case fxpFstat:
+		h := sess.handles[r.string()]
+		if h == nil {
+			return status(id, statusFailure, "invalid handle"), nil
+		}
+		return attrsReply(id, h.node), nil
+
+	case fxpSetstat, fxpFsetstat:
+		var n *node
+		code := uint32(statusOK)
+		if typ == fxpSetstat {
+			n, code = s.follow(r.string())
+		} else if h := sess.handles[r.string()]; h != nil {
+			n = h.node
+		} else {
+			code = statusFailure
+		}
+		a := r.attrs()
+		if r.err != nil {
+			return status(id, statusBadMessage, r.err.Error()), nil
+		}
+		if code != statusOK {
+			return status(id, code, ""), nil
+		}
+		if a.flags&attrSize != 0 {
+			if a.size > uint64(len(n.data)) {
+				n.data = append(n.data, make([]byte, a.size-uint64(len(n.data)))...)
+			}
+			n.data = n.data[:a.size]
+		}
+		if a.flags&attrPermissions != 0 {
+			n.mode = n.mode&modeType | a.permissions&07777
+		}
+		if a.flags&attrACModTime != 0 {
+			n.mtime = a.mtime
+		}
+		return status(id, statusOK, ""), nil
+
+	case fxpReaddir:
+		h := sess.handles[r.string()]
+		if h == nil || !h.dir {
+			return status(id, statusFailure, "invalid handle"), nil
+		}
+		if h.listed {
+			return status(id, statusEOF, ""), nil
+		}
+		h.listed = true
+		names := []string{".", ".."}
+		for p := range s.files {
+			if p != "/" && path.Dir(p) == h.path {
+				names = append(names, path.Base(p))
+			}
+		}
+		sort.Strings(names[2:])
+		reply := []byte{fxpName}
+		reply = binary.BigEndian.AppendUint32(reply, id)
+		reply = binary.BigEndian.AppendUint32(reply, uint32(len(names)))
+		for _, name := range names {
+			n := h.node
+			if name != "." && name != ".." {
+				n = s.files[path.Join(h.path, name)]
+			}
+			reply = appendString(reply, name)
+			reply = appendString(reply, longName(name, n))
+			reply = appendAttrs(reply, n)
+		}
+		return reply, nil
+
+	case fxpRemove:
+		p := s.resolve(r.string())
+		n := s.files[p]
+		switch {
+		case n == nil:
+			return status(id, statusNoSuchFile, ""), nil
+		case n.mode&modeType == modeDir:
+			return status(id, statusFailure, "is a directory"), nil
+		}
+		delete(s.files, p)
+		return status(id, statusOK, ""), nil
+
+	case fxpMkdir:
+		p := s.resolve(r.string())
+		r.attrs()
+		if r.err != nil {
+			return status(id, statusBadMessage, r.err.Error()), nil
+		}
+		if code := s.parentDir(p); code != statusOK {
+			return status(id, code, ""), nil
+		}
+		if s.files[p] != nil {
+			return status(id, statusFailure, "file exists"), nil
+		}
+		s.files[p] = &node{mode: modeDir | 0755}
+		return status(id, statusOK, ""), nil
+
+	case fxpRmdir:
+		p := s.resolve(r.string())
+		n := s.files[p]
+		switch {
+		case n == nil:
+			return status(id, statusNoSuchFile, ""), nil
+		case n.mode&modeType != modeDir:
+			return status(id, statusFailure, "not a directory"), nil
+		}
+		for other := range s.files {
+			if other != "/" && path.Dir(other) == p {
+				return status(id, statusFailure, "directory not empty"), nil
+			}
+		}
+		delete(s.files, p)
+		return status(id, statusOK, ""), nil
+
+	case fxpRealpath:
+		return nameReply(id, s.resolve(r.string())), nil
+
+	case fxpReadlink:
+		n := s.files[s.resolve(r.string())]
+		switch {
+		case n == nil:
+			return status(id, statusNoSuchFile, ""), nil
+		case n.mode&modeType != modeSymlink:
+			return status(id, statusFailure, "not a symlink"), nil
+		}
+		return nameReply(id, n.target), nil
+
+	case fxpSymlink:
+		// OpenSSH order: target first, then the link
+		target := r.string()
+		link := s.resolve(r.string())
+		if code := s.parentDir(link); code != statusOK {
+			return status(id, code, ""), nil
+		}
+		if s.files[link] != nil {
+			return status(id, statusFailure, "file exists"), nil
+		}
+		s.files[link] = &node{mode: modeSymlink | 0777, target: target}
+		return status(id, statusOK, ""), nil
+
+	case fxpRename:
+		return status(id, s.rename(r.string(), r.string(), false), ""), nil
+
+	case fxpExtended:
+		switch name := r.string(); {
+		case name == "posix-rename@openssh.com" && sess.options.PosixRename:
+			return status(id, s.rename(r.string(), r.string(), true), ""), nil
+		case name == "check-file-name" && sess.options.CheckFile:
+			return s.checkFile(id, r), nil
+		}
+		return status(id, statusOpUnsupported, "unsupported extension"), nil
+	}
+	return status(id, statusOpUnsupported, "unsupported request"), nil
+}
+
+// count adds n to the data transferred, failing with ErrCut past CloseAfter
+func (sess *session) count(n int) error {
+	if sess.options.CloseAfter > 0 && sess.transferred+int64(n) > sess.options.CloseAfter {
+		return ErrCut
+	}
+	sess.transferred += int64(n)
+	return nil
+}
+
+func (sess *session) newHandle(id uint32, h *handle) []byte {
+	s := sess.server
+	s.nextID++
+	s.handles++
+	name := "h" + strconv.Itoa(s.nextID)
+	sess.handles[name] = h
+
+	reply := []byte{fxpHandle}
+	reply = binary.BigEndian.AppendUint32(reply, id)
+	return appendString(reply, name)
+}
+
+func (s *Server) open(p string, flags uint32) (*node, uint32) {
+	n, code := s.follow(p)
+	switch {
+	case code == statusNoSuchFile && flags&fxfCreat != 0:
+		name := s.resolve(p)
+		if code := s.parentDir(name); code != statusOK {
+			return nil, code
+		}
+		n = &node{mode: modeRegular | 0644}
+		s.files[name] = n
+		return n, statusOK
+	case code != statusOK:
+		return nil, code
+	case flags&fxfCreat != 0 && flags&fxfExcl != 0:
+		return nil, statusFailure
+	case n.mode&modeType != modeRegular:
+		return nil, statusFailure
+	}
+	if flags&fxfTrunc != 0 {
+		n.data = nil
+	}
+	return n, statusOK
+}
+
+func (s *Server) rename(oldPath, newPath string, replace bool) uint32 {
+	oldPath, newPath = s.resolve(oldPath), s.resolve(newPath)
+	n := s.files[oldPath]
+	if n == nil {
+		return statusNoSuchFile
+	}
+	if s.files[newPath] != nil && !replace {
+		return statusFailure
+	}
+	if code := s.parentDir(newPath); code != statusOK {
+		return code
+	}
+	delete(s.files, oldPath)
+	s.files[newPath] = n
+	return statusOK
+}
+
+// checkFile serves check-file-name with a single sha256 hash of the range
+func (s *Server) checkFile(id uint32, r *reader) []byte {
+	p := r.string()
+	algorithms := r.string()
+	offset := r.uint64()
+	length := r.uint64()
+	blockSize := r.uint32()
+	if r.err != nil {
+		return status(id, statusBadMessage, r.err.Error())
+	}
+	if blockSize != 0 {
+		return status(id, statusOpUnsupported, "block hashes are not supported")
+	}
+	if !contains(strings.Split(algorithms, ","), "sha256") {
+		return status(id, statusFailure, "no supported algorithm")
+	}
+	n, code := s.follow(p)
+	if code != statusOK {
+		return status(id, code, "")
+	}
+	data := n.data
+	if offset > uint64(len(data)) {
+		offset = uint64(len(data))
+	}
+	data = data[offset:]
+	if length > 0 && length < uint64(len(data)) {
+		data = data[:length]
+	}
+	sum := sha256.Sum256(data)
+
+	reply := []byte{fxpExtendedReply}
+	reply = binary.BigEndian.AppendUint32(reply, id)
+	reply = appendString(reply, "check-file")
+	reply = appendString(reply, "sha256")
+	return append(reply, sum[:]...)
+}
+
+// resolve makes p absolute against the root, which is the login directory
+func (s *Server) resolve(p string) string {
+	return clean(p)
+}
+
+// follow looks up p, following symlinks
+func (s *Server) follow(p string) (*node, uint32) {
+	name := s.resolve(p)
+	for i := 0; i < 8; i++ {
+		n := s.files[name]
+		if n == nil {
+			return nil, statusNoSuchFile
+		}
+		if n.mode&modeType != modeSymlink {
+			return n, statusOK
+		}
+		target := n.target
+		if !path.IsAbs(target) {
+			target = path.Join(path.Dir(name), target)
+		}
+		name = clean(target)
+	}
+	return nil, statusFailure
+}
+
+func (s *Server) parentDir(p string) uint32 {
+	parent := s.files[path.Dir(p)]
+	switch {
+	case parent == nil:
+		return statusNoSuchFile
+	case parent.mode&modeType != modeDir:
+		return statusFailure
+	}
+	return statusOK
+}
+
+func clean(p string) string {
+	return path.Join("/", p)
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func longName(name string, n *node) string {
+	kind := "-"
+	switch n.mode & modeType {
+	case modeDir:
+		kind = "d"
+	case modeSymlink:
+		kind = "l"
+	}
+	return fmt.Sprintf("%s%04o 1 user group %d Jan 1 00:00 %s", kind, n.mode&07777, len(n.data), name)
+}
+
+func status(id, code uint32, message string) []byte {
+	reply := []byte{fxpStatus}
+	reply = binary.BigEndian.AppendUint32(reply, id)
+	reply = binary.BigEndian.AppendUint32(reply, code)
+	reply = appendString(reply, message)
+	return appendString(reply, "en")
+}
+
+func attrsReply(id uint32, n *node) []byte {
+	reply := []byte{fxpAttrs}
+	reply = binary.BigEndian.AppendUint32(reply, id)
+	return appendAttrs(reply, n)
+}
+
+func nameReply(id uint32, name string) []byte {
+	reply := []byte{fxpName}
+	reply = binary.BigEndian.AppendUint32(reply, id)
+	reply = binary.BigEndian.AppendUint32(reply, 1)
+	reply = appendString(reply, name)
+	reply = appendString(reply, name)
+	return binary.BigEndian.AppendUint32(reply, 0)
+}
+
+// appendAttrs sends every attribute except extended ones
+func appendAttrs(b []byte, n *node) []byte {
+	b = binary.BigEndian.AppendUint32(b, attrSize|attrUIDGID|attrPermissions|attrACModTime)
+	b = binary.BigEndian.AppendUint64(b, uint64(len(n.data)))
+	b = binary.BigEndian.AppendUint32(b, 1000)
+	b = binary.BigEndian.AppendUint32(b, 1000)
+	b = binary.BigEndian.AppendUint32(b, n.mode)
+	b = binary.BigEndian.AppendUint32(b, n.mtime)
+	return binary.BigEndian.AppendUint32(b, n.mtime)
+}
+
+func appendString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint32(b, uint32(len(s)))
+	return append(b, s...)
+}
+
+func readPacket(r io.Reader) (byte, []byte, error) {
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, nil, err
+	}
+	length := binary.BigEndian.Uint32(header)
+	if length < 1 || length > 1<<20 {
+		return 0, nil, fmt.Errorf("sftptest: invalid packet length %d", length)
+	}
+	data := make([]byte, length-1)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return 0, nil, err
+	}
+	return header[4], data, nil
+}
+
+func writePacket(w io.Writer, payload []byte) error {
+	packet := binary.BigEndian.AppendUint32(nil, uint32(len(payload)))
+	_, err := w.Write(append(packet, payload...))
+	return err
+}
+
+// attrs are the decoded attributes of a request
+type attrs struct {
+	flags       uint32
+	size        uint64
+	permissions uint32
+	mtime       uint32
+}
+
+type reader struct {
+	data []byte
+	err  error
+}
+
+func (r *reader) uint32() uint32 {
+	if r.err != nil || len(r.data) < 4 {
+		r.err = errors.New("sftptest: short packet")
+		return 0
+	}
+	v := binary.BigEndian.Uint32(r.data)
+	r.data = r.data[4:]
+	return v
+}
+
+func (r *reader) uint64() uint64 {
+	hi := r.uint32()
+	return uint64(hi)<<32 | uint64(r.uint32())
+}
+
+func (r *reader) bytes() []byte {
+	n := r.uint32()
+	if r.err != nil || uint32(len(r.data)) < n {
+		r.err = errors.New("sftptest: short packet")
+		return nil
+	}
+	v := r.data[:n]
+	r.data = r.data[n:]
+	return v
+}
+
+func (r *reader) string() string {
+	return string(r.bytes())
+}
+
+func (r *reader) attrs() attrs {
+	a := attrs{flags: r.uint32()}
+	if a.flags&attrSize != 0 {
+		a.size = r.uint64()
+	}
+	if a.flags&attrUIDGID != 0 {
+		r.uint32()
+		r.uint32()
+	}
+	if a.flags&attrPermissions != 0 {
+		a.permissions = r.uint32()
+	}
+	if a.flags&attrACModTime != 0 {
+		r.uint32()
+		a.mtime = r.uint32()
+	}
+	if a.flags&attrExtended != 0 {
+		for count := r.uint32(); count > 0 && r.err == nil; count-- {
+			r.string()
+			r.string()
+		}
+	}
+	return a
+}
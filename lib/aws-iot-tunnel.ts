@@ -1,4 +1,8 @@
-import { Transport } from "./transport";
+import {
+  Transport,
+  DEFAULT_MAX_BUFFERED_AMOUNT,
+  waitForBufferedAmount,
+} from "./transport";
 import * as protobuf from "./aws-iot-tunnel/protobuf-messages";
 
 // Get the message classes from the protobuf namespace
@@ -207,6 +211,7 @@ export class SecureTunnelTransport implements Transport {
     }
 
     this.sendDataFrame(data);
+    await waitForBufferedAmount(this.ws, DEFAULT_MAX_BUFFERED_AMOUNT);
   }
 
   /**
@@ -10,13 +10,163 @@ export { WebSocketTransport, CustomTransport } from "./transport";
 export { SecureTunnelTransport, TunnelMessageType } from "./aws-iot-tunnel";
 export type { SecureTunnelConfig, TunnelMessage } from "./aws-iot-tunnel";
 
+export type HostKeyDecision = "accept" | "accept-once" | "reject";
+
+export interface HostKeyInfo {
+  hostname: string;
+  remoteAddr: string;
+  keyType: string;
+  fingerprint: string;
+  key: Uint8Array;
+}
+
+export interface KnownHostEntry {
+  marker: "" | "cert-authority" | "revoked";
+  hosts: string[];
+  keyType: string;
+  fingerprint: string;
+  comment: string;
+  line: string;
+}
+
+export interface KnownHostsOptions {
+  trustOnFirstUse?: boolean;
+  hashHostnames?: boolean;
+  onLearn?: (entry: KnownHostEntry) => void;
+}
+
+export interface KnownHostsDatabase {
+  id: string;
+  serialize: () => Promise<string>;
+  load: (data: string) => Promise<void>;
+  entries: () => Promise<KnownHostEntry[]>;
+  add: (
+    host: string,
+    authorizedKey: string,
+    marker?: "cert-authority" | "revoked"
+  ) => Promise<KnownHostEntry>;
+  remove: (host: string) => Promise<number>;
+  close: () => Promise<void>;
+}
+
+export interface HostKeyPolicy {
+  fingerprints?: string[];
+  knownHosts?: string | KnownHostsDatabase;
+  verify?: (
+    info: HostKeyInfo
+  ) => HostKeyDecision | boolean | Promise<HostKeyDecision | boolean>;
+  insecureIgnoreHostKey?: boolean;
+}
+
+export interface PrivateKeyOption {
+  label?: string;
+  key: string;
+  passphrase?: string;
+  certificate?: string;
+}
+
+export interface ExternalKeyOption {
+  label?: string;
+  /** Public key in authorized_keys format */
+  publicKey: string;
+  certificate?: string;
+  /**
+   * Sign data for the given SSH algorithm and return the raw signature:
+   * 64 bytes for Ed25519, r||s or DER for ECDSA P-256, PKCS #1 v1.5 for RSA.
+   */
+  sign: (
+    data: Uint8Array,
+    algorithm: string
+  ) => Uint8Array | ArrayBuffer | Promise<Uint8Array | ArrayBuffer>;
+}
+
+export interface PTYOptions {
+  /** TERM value (default: "xterm-256color") */
+  term?: string;
+  cols?: number;
+  rows?: number;
+  /** Terminal size in pixels */
+  width?: number;
+  height?: number;
+  /** Terminal modes by name ("ECHO", "VERASE", "TTY_OP_ISPEED", ...) or opcode; flags may be booleans */
+  modes?: Record<string, number | boolean>;
+}
+
 export interface ConnectionOptions {
   host: string;
   port: number;
   user: string;
   password?: string;
   privateKey?: string;
+  passphrase?: string;
+  certificate?: string;
+  privateKeys?: (string | PrivateKeyOption)[];
+  externalKeys?: ExternalKeyOption[];
+  forwardAgent?: boolean;
+  /** PTY settings for the shell, or false to run it without a terminal */
+  pty?: PTYOptions | false;
+  /** Seconds allowed for the handshake and authentication; connect rejects with a TimeoutError after that */
   timeout?: number;
+  hostKey?: HostKeyPolicy;
+  /** Probes the server like ServerAliveInterval and ServerAliveCountMax */
+  keepalive?: KeepaliveOptions;
+  /** Reconnects with backoff when the connection is lost; needs the requestTransport callback unless connecting with connectWebSocket */
+  reconnect?: ReconnectOptions;
+  /** Bastions connected through in order, like ProxyJump */
+  jumpHosts?: JumpHostOptions[];
+}
+
+export interface WebSocketConnectionOptions extends ConnectionOptions {
+  /** Subprotocols offered in the WebSocket handshake */
+  protocols?: string | string[];
+  /** Bytes the browser may buffer for sending before writes wait, 1 MiB by default */
+  maxBufferedAmount?: number;
+}
+
+export interface KeepaliveOptions {
+  /** Seconds between keepalive@openssh.com probes; 0 disables them */
+  interval: number;
+  /** Unanswered probes before the connection is considered dead, 3 by default */
+  countMax?: number;
+}
+
+export interface ReconnectOptions {
+  /** Attempts before giving up; 0 disables reconnecting */
+  maxAttempts: number;
+  /** Milliseconds before the first attempt, 1000 by default */
+  initialDelay?: number;
+  /** Upper bound on the wait between attempts in milliseconds, 30000 by default */
+  maxDelay?: number;
+  /** Growth of the wait after each attempt, 2 by default */
+  multiplier?: number;
+  /** Fraction of each wait to randomly add or remove, 0.2 by default */
+  jitter?: number;
+}
+
+/** A jump host with its own user, credentials and host key policy */
+export interface JumpHostOptions {
+  host: string;
+  /** 22 by default */
+  port?: number;
+  user: string;
+  password?: string;
+  privateKey?: string;
+  passphrase?: string;
+  certificate?: string;
+  privateKeys?: (string | PrivateKeyOption)[];
+  externalKeys?: ExternalKeyOption[];
+  hostKey?: HostKeyPolicy;
+}
+
+export interface StateDetail {
+  /** Position in the jump chain starting at 1, 0 for the target server */
+  hop: number;
+  host: string;
+  port: number;
+  /** Why the connection failed after it was established, or why it is reconnecting */
+  reason?: string;
+  /** Reconnection attempt starting at 1 */
+  attempt?: number;
 }
 
 export interface PacketMetadata {
@@ -24,12 +174,35 @@ export interface PacketMetadata {
   direction: "send" | "receive";
   size: number;
   type?: string;
+  /** ID of the session channel the data belongs to */
+  channelId?: string;
+  /** Output stream of received session data */
+  stream?: "stdout" | "stderr";
+}
+
+export interface AuthPromptQuestion {
+  prompt: string;
+  echo: boolean;
+}
+
+export interface AuthPrompt {
+  user: string;
+  name: string;
+  instruction: string;
+  questions: AuthPromptQuestion[];
 }
 
 export interface SSHClientCallbacks {
   onPacketSend?: (data: Uint8Array, metadata: PacketMetadata) => void;
   onPacketReceive?: (data: Uint8Array, metadata: PacketMetadata) => void;
-  onStateChange?: (state: SSHConnectionState) => void;
+  /** Receives the shell's stderr, which otherwise goes to onPacketReceive */
+  onStderr?: (data: Uint8Array, metadata: PacketMetadata) => void;
+  onStateChange?: (state: SSHConnectionState, detail: StateDetail) => void;
+  onAuthPrompt?: (prompt: AuthPrompt) => string[] | Promise<string[]>;
+  onPassphraseNeeded?: (label: string) => string | Promise<string>;
+  onAgentConfirm?: (identity: AgentIdentity) => boolean | Promise<boolean>;
+  /** Supplies a new, unconnected transport for each reconnection attempt */
+  requestTransport?: (attempt: number) => Transport | Promise<Transport>;
 }
 
 export interface InitializationOptions {
@@ -43,16 +216,353 @@ export interface InitializationOptions {
 
 export type SSHConnectionState =
   | "connecting"
+  | "jump-connecting"
+  | "jump-connected"
   | "connected"
+  | "reconnecting"
+  | "reconnected"
   | "disconnecting"
   | "disconnected"
   | "error";
 
+export interface AgentIdentity {
+  keyType: string;
+  fingerprint: string;
+  publicKey: string;
+  comment: string;
+  confirm: boolean;
+  /** Unix time in seconds when the key is removed, 0 if it has no lifetime */
+  expiresAt: number;
+}
+
+export interface AgentKeyOptions {
+  key: string;
+  passphrase?: string;
+  certificate?: string;
+  comment?: string;
+  /** Lifetime in seconds */
+  lifetime?: number;
+  /** Ask onAgentConfirm before each signature */
+  confirm?: boolean;
+}
+
+/**
+ * In-memory agent of a session. Keys stay until their lifetime runs out or
+ * they are removed; disconnecting does not wipe them.
+ */
+export interface SSHAgent {
+  add: (options: AgentKeyOptions) => Promise<AgentIdentity>;
+  remove: (publicKey: string) => Promise<void>;
+  removeAll: () => Promise<void>;
+  list: () => Promise<AgentIdentity[]>;
+  lock: (passphrase: string) => Promise<void>;
+  unlock: (passphrase: string) => Promise<void>;
+}
+
+export interface ExecOptions {
+  /** Sent to the command's stdin, followed by EOF */
+  stdin?: Uint8Array | string;
+  /** Streams stdout; without it stdout is returned in the result */
+  onStdout?: (data: Uint8Array) => void;
+  /** Streams stderr; without it stderr is returned in the result */
+  onStderr?: (data: Uint8Array) => void;
+}
+
+export interface ExitStatus {
+  exitCode: number;
+  /** Signal name without the SIG prefix, empty unless the command was killed */
+  exitSignal: string;
+  errorMessage: string;
+  /** The server closed the channel without reporting an exit status */
+  exitMissing: boolean;
+}
+
+export interface ExecResult extends ExitStatus {
+  stdout?: Uint8Array;
+  stderr?: Uint8Array;
+}
+
+export interface ChannelOptions {
+  /** PTY settings; shells get a default PTY unless this is false, commands only when it is set */
+  pty?: PTYOptions | boolean;
+  forwardAgent?: boolean;
+  /** Receives the channel's output, with metadata.stream set to stdout or stderr */
+  onData?: (data: Uint8Array, metadata: PacketMetadata) => void;
+  /** Receives stderr instead of onData */
+  onStderr?: (data: Uint8Array, metadata: PacketMetadata) => void;
+  /** Called once the channel has ended, with an error message if it failed */
+  onExit?: (result: ExitStatus | null, error?: string) => void;
+}
+
+/**
+ * Bounds a send or flush. On a timeout it rejects with a TimeoutError, and on
+ * an abort with an AbortError; data not yet being written is dropped.
+ */
+export interface SendOptions {
+  /** Milliseconds to wait */
+  timeout?: number;
+  signal?: AbortSignal;
+}
+
+export interface SSHChannel {
+  id: string;
+  /** Resolves once the channel has accepted the data, waiting while 1 MiB is already buffered */
+  send: (data: Uint8Array | string, options?: SendOptions) => Promise<void>;
+  /** Bytes sent but not yet accepted by the channel */
+  buffered: () => number;
+  /** Resolves once everything sent has been accepted */
+  flush: (options?: SendOptions) => Promise<void>;
+  resizeTerminal: (
+    cols: number,
+    rows: number,
+    width?: number,
+    height?: number
+  ) => Promise<void>;
+  close: () => Promise<void>;
+  /** Resolves with the exit status once the channel has ended */
+  wait: () => Promise<ExitStatus>;
+}
+
+export interface ForwardedChannel {
+  id: string;
+  /** Writes to the remote end, resolving once the data fits the channel window */
+  send: (data: Uint8Array | string) => Promise<void>;
+  /** Sets the data callback; nothing is read from the channel until it is set */
+  onData: (callback: (data: Uint8Array, metadata: PacketMetadata) => void) => void;
+  /** Called once the channel has closed, with an error message if it failed */
+  onClose: (callback: (error?: string) => void) => void;
+  close: () => Promise<void>;
+}
+
+export interface RemoteListener {
+  id: string;
+  /** Address and port the server listens on, useful when port 0 was requested */
+  address: string;
+  port: number;
+  /** Sets the connection callback; connections wait on the server until it is set */
+  onConnection: (
+    callback: (
+      channel: ForwardedChannel & { origin: { address: string; port: number } }
+    ) => void
+  ) => void;
+  /** Cancels the forward; accepted channels stay open */
+  close: () => Promise<void>;
+}
+
+export interface SocksOptions {
+  /** Requires username/password authentication from the SOCKS client when set */
+  username?: string;
+  password?: string;
+}
+
+export interface SFTPFileInfo {
+  name: string;
+  /** ls -l style line from the server, empty for stat results */
+  longname: string;
+  size: number;
+  /** POSIX mode including the file type bits */
+  mode: number;
+  isDirectory: boolean;
+  isFile: boolean;
+  isSymlink: boolean;
+  uid: number;
+  gid: number;
+  /** Unix timestamps in seconds */
+  atime: number;
+  mtime: number;
+}
+
+export interface SFTPReadOptions {
+  /** Streams the file in chunks; without it the file is returned whole */
+  onData?: (chunk: Uint8Array, offset: number) => void;
+  /** Total is -1 when the size is unknown */
+  onProgress?: (transferred: number, total: number) => void;
+}
+
+export interface SFTPWriteOptions {
+  onProgress?: (transferred: number, total: number) => void;
+}
+
+export interface SFTPSession {
+  list: (path: string) => Promise<SFTPFileInfo[]>;
+  stat: (path: string) => Promise<SFTPFileInfo>;
+  lstat: (path: string) => Promise<SFTPFileInfo>;
+  /** Canonical path; "." is the login directory */
+  realpath: (path: string) => Promise<string>;
+  readlink: (path: string) => Promise<string>;
+  mkdir: (path: string) => Promise<void>;
+  /** Removes a file, or an empty directory */
+  remove: (path: string) => Promise<void>;
+  rename: (oldPath: string, newPath: string) => Promise<void>;
+  symlink: (target: string, linkPath: string) => Promise<void>;
+  chmod: (path: string, mode: number) => Promise<void>;
+  read: (path: string, options?: SFTPReadOptions) => Promise<Uint8Array>;
+  /** Creates or truncates the file; resolves with the bytes written */
+  write: (
+    path: string,
+    data: Uint8Array | string,
+    options?: SFTPWriteOptions
+  ) => Promise<number>;
+  close: () => Promise<void>;
+}
+
+export interface TransferCheckpoint {
+  path: string;
+  /** Everything before offset has been acknowledged */
+  offset: number;
+  /** -1 while the size of a download is unknown */
+  size: number;
+}
+
+export interface TransferOptions {
+  /** Bytes per request, 32 KiB by default */
+  chunkSize?: number;
+  /** Requests kept in flight, 16 by default */
+  maxOutstanding?: number;
+  /** Resumes from a stored checkpoint offset */
+  offset?: number;
+  /** Continues an upload from the size of the remote file */
+  resume?: boolean;
+  /** Called after every acknowledged chunk; store it to resume later */
+  onCheckpoint?: (checkpoint: TransferCheckpoint) => void;
+  /** Compares a remotely computed SHA-256; true is the same as "auto" */
+  verify?: boolean | "auto" | "check-file" | "exec";
+  /** Expected SHA-256 as hex; required to verify a resumed download */
+  sha256?: string;
+}
+
+export interface DownloadOptions extends TransferOptions {
+  /** Receives the file in order; may return a Promise to apply backpressure */
+  onData: (chunk: Uint8Array, offset: number) => void | Promise<void>;
+}
+
+/** Upload data, read chunk by chunk */
+export type TransferSource =
+  | Uint8Array
+  | ArrayBuffer
+  | Blob
+  | {
+      size: number;
+      read: (
+        offset: number,
+        length: number
+      ) => Uint8Array | ArrayBuffer | Promise<Uint8Array | ArrayBuffer>;
+    };
+
+export interface TransferResult {
+  size: number;
+  resumedFrom: number;
+  /** Remote SHA-256 as hex, empty when not verified */
+  sha256: string;
+  verifiedBy: "" | "check-file" | "exec";
+  /** Offset verification started at; only the data from here on was checked */
+  verifiedFrom: number;
+}
+
+export interface TransferJob {
+  /** Transfers the rest of the file; call again after a failure to resume */
+  run: () => Promise<TransferResult>;
+  /** Stops after the requests in flight complete */
+  cancel: () => void;
+  checkpoint: () => TransferCheckpoint;
+}
+
+export interface SCPFile {
+  /** Slash separated, relative to the target; parents are created as needed */
+  path: string;
+  /** File contents; omitted for directories */
+  data?: TransferSource;
+  directory?: boolean;
+  /** Permission bits, 0644 for files and 0755 for directories by default */
+  mode?: number;
+  /** Unix timestamps in seconds, sent when preserve is set */
+  mtime?: number;
+  atime?: number;
+}
+
+export interface SCPOptions {
+  /** Copies directories (scp -r); uploads turn it on when needed */
+  recursive?: boolean;
+  /** Keeps mode bits and times (scp -p) */
+  preserve?: boolean;
+  onProgress?: (path: string, transferred: number, total: number) => void;
+}
+
+export interface SCPDownloadOptions extends SCPOptions {
+  /** Streams file contents; without it they are returned with each entry */
+  onData?: (
+    chunk: Uint8Array,
+    offset: number,
+    path: string
+  ) => void | Promise<void>;
+}
+
+export interface SCPEntry {
+  path: string;
+  mode: number;
+  size: number;
+  isDirectory: boolean;
+  /** Present when the server sent times */
+  mtime?: number;
+  atime?: number;
+  data?: Uint8Array;
+}
+
 export interface SSHSession {
   sessionId: string;
-  send: (data: Uint8Array) => Promise<void>;
+  /** Label of the key the server accepted, kept current across reconnects */
+  readonly authenticatedKey: string;
+  /** Subprotocol the server selected, for sessions from connectWebSocket */
+  protocol?: string;
+  /** Writes to the shell, resolving once the channel has accepted the data */
+  send: (data: Uint8Array, options?: SendOptions) => Promise<void>;
+  /** Bytes sent to the shell but not yet accepted by the channel */
+  buffered: () => number;
+  /** Resolves once everything sent to the shell has been accepted */
+  flush: (options?: SendOptions) => Promise<void>;
   disconnect: () => Promise<void>;
-  resizeTerminal: (cols: number, rows: number) => Promise<void>;
+  resizeTerminal: (
+    cols: number,
+    rows: number,
+    width?: number,
+    height?: number
+  ) => Promise<void>;
+  exec: (command: string, options?: ExecOptions) => Promise<ExecResult>;
+  /** Opens an additional shell on its own channel */
+  openShell: (options?: ChannelOptions) => Promise<SSHChannel>;
+  /** Starts a command on its own channel, keeping stdin open */
+  openExec: (command: string, options?: ChannelOptions) => Promise<SSHChannel>;
+  /** Opens a direct-tcpip channel to host:port as seen from the server */
+  forwardLocal: (host: string, port: number) => Promise<ForwardedChannel>;
+  /** Asks the server to listen on bindAddr:port and forward connections back */
+  listenRemote: (bindAddr: string, port: number) => Promise<RemoteListener>;
+  /**
+   * Starts a SOCKS5 server for one client connection. Write the SOCKS client's
+   * bytes with send() and relay onData back to it.
+   */
+  openSocks: (options?: SocksOptions) => Promise<ForwardedChannel>;
+  /** Opens the sftp subsystem, reusing it on later calls */
+  sftp: () => Promise<SFTPSession>;
+  /** Prepares a resumable, pipelined SFTP upload */
+  upload: (
+    path: string,
+    source: TransferSource,
+    options?: TransferOptions
+  ) => Promise<TransferJob>;
+  /** Prepares a resumable, pipelined SFTP download */
+  download: (path: string, options: DownloadOptions) => Promise<TransferJob>;
+  /** Uploads with a remote "scp -t", for servers without sftp */
+  scpUpload: (
+    target: string,
+    files: SCPFile[],
+    options?: SCPOptions
+  ) => Promise<void>;
+  /** Downloads with a remote "scp -f", for servers without sftp */
+  scpDownload: (
+    source: string,
+    options?: SCPDownloadOptions
+  ) => Promise<SCPEntry[]>;
+  agent: SSHAgent;
 }
 
 // Asset path detection utilities
@@ -275,23 +785,11 @@ export class SSHClient {
     // Connect the transport
     await transport.connect();
 
-    const jsCallbacks = callbacks
-      ? {
-          onPacketSend: (data: any, metadata: any) => {
-            if (callbacks.onPacketSend) {
-              // Data is already a Uint8Array from WASM
-              callbacks.onPacketSend(data, metadata);
-            }
-          },
-          onPacketReceive: (data: any, metadata: any) => {
-            if (callbacks.onPacketReceive) {
-              // Data is already a Uint8Array from WASM
-              callbacks.onPacketReceive(data, metadata);
-            }
-          },
-          onStateChange: callbacks.onStateChange,
-        }
-      : undefined;
+    // Reconnecting replaces the transport the session runs over
+    let currentTransport = transport;
+    const jsCallbacks = this.wrapCallbacks(callbacks, (next) => {
+      currentTransport = next;
+    });
 
     // Pass transport ID to WASM
     const session = await this.wasmInstance.connect(
@@ -300,21 +798,152 @@ export class SSHClient {
       jsCallbacks
     );
 
+    return this.wrapSession(session, async () => {
+      await this.transportManager.closeTransport(currentTransport.id);
+    });
+  }
+
+  /**
+   * Connects over a WebSocket that the WASM module opens and drives itself,
+   * with no Transport to set up. Reconnecting dials the same URL again unless
+   * a requestTransport callback is given.
+   */
+  static async connectWebSocket(
+    url: string,
+    options: WebSocketConnectionOptions,
+    callbacks?: SSHClientCallbacks
+  ): Promise<SSHSession> {
+    if (!this.initialized) {
+      throw new Error("SSHClient not initialized. Call initialize() first.");
+    }
+
+    let currentTransport: Transport | undefined;
+    const jsCallbacks = this.wrapCallbacks(callbacks, (next) => {
+      currentTransport = next;
+    });
+
+    const session = await this.wasmInstance.connectWebSocket(
+      url,
+      options,
+      jsCallbacks
+    );
+
+    return this.wrapSession(session, async () => {
+      if (currentTransport) {
+        await this.transportManager.closeTransport(currentTransport.id);
+      }
+    });
+  }
+
+  /** Adapts the callbacks for WASM, reporting transports set up for reconnects */
+  private static wrapCallbacks(
+    callbacks: SSHClientCallbacks | undefined,
+    onTransport: (transport: Transport) => void
+  ): any {
+    if (!callbacks) {
+      return undefined;
+    }
+
+    const requestTransport = callbacks.requestTransport;
+    return {
+      onPacketSend: (data: any, metadata: any) => {
+        if (callbacks.onPacketSend) {
+          // Data is already a Uint8Array from WASM
+          callbacks.onPacketSend(data, metadata);
+        }
+      },
+      onPacketReceive: (data: any, metadata: any) => {
+        if (callbacks.onPacketReceive) {
+          // Data is already a Uint8Array from WASM
+          callbacks.onPacketReceive(data, metadata);
+        }
+      },
+      onStderr: callbacks.onStderr,
+      onStateChange: callbacks.onStateChange,
+      onAuthPrompt: callbacks.onAuthPrompt,
+      onPassphraseNeeded: callbacks.onPassphraseNeeded,
+      onAgentConfirm: callbacks.onAgentConfirm,
+      requestTransport: requestTransport
+        ? async (attempt: number) => {
+            const next = await requestTransport(attempt);
+            await this.transportManager.createTransport(next);
+            await next.connect();
+            onTransport(next);
+            return next.id;
+          }
+        : undefined,
+    };
+  }
+
+  /** Builds the SSHSession for a connected WASM session */
+  private static wrapSession(
+    session: any,
+    closeTransport: () => Promise<void>
+  ): SSHSession {
     return {
       sessionId: session.sessionId,
-      send: async (data: Uint8Array) => {
-        await session.send(data);
+      get authenticatedKey(): string {
+        return session.authenticatedKey();
       },
+      protocol: session.protocol,
+      send: async (data: Uint8Array, options?: SendOptions) => {
+        await session.send(data, options);
+      },
+      buffered: () => session.buffered(),
+      flush: (options?: SendOptions) => session.flush(options),
       disconnect: async () => {
         await session.disconnect();
-        await this.transportManager.closeTransport(transport.id);
+        await closeTransport();
       },
-      resizeTerminal: async (cols: number, rows: number) => {
-        await session.resizeTerminal(cols, rows);
+      resizeTerminal: async (
+        cols: number,
+        rows: number,
+        width?: number,
+        height?: number
+      ) => {
+        if (width !== undefined && height !== undefined) {
+          await session.resizeTerminal(cols, rows, width, height);
+        } else {
+          await session.resizeTerminal(cols, rows);
+        }
       },
+      exec: (command: string, options?: ExecOptions) =>
+        session.exec(command, options),
+      openShell: (options?: ChannelOptions) => session.openShell(options),
+      openExec: (command: string, options?: ChannelOptions) =>
+        session.openExec(command, options),
+      forwardLocal: (host: string, port: number) =>
+        session.forwardLocal(host, port),
+      listenRemote: (bindAddr: string, port: number) =>
+        session.listenRemote(bindAddr, port),
+      openSocks: (options?: SocksOptions) => session.openSocks(options),
+      sftp: () => session.sftp(),
+      upload: (
+        path: string,
+        source: TransferSource,
+        options?: TransferOptions
+      ) => session.upload(path, source, options),
+      download: (path: string, options: DownloadOptions) =>
+        session.download(path, options),
+      scpUpload: (target: string, files: SCPFile[], options?: SCPOptions) =>
+        session.scpUpload(target, files, options),
+      scpDownload: (source: string, options?: SCPDownloadOptions) =>
+        session.scpDownload(source, options),
+      agent: session.agent,
     };
   }
 
+  static async createKnownHosts(
+    data = "",
+    options?: KnownHostsOptions
+  ): Promise<KnownHostsDatabase> {
+    if (!this.initialized) {
+      throw new Error("SSHClient not initialized");
+    }
+
+    return this.wasmInstance.createKnownHosts(data, options);
+  }
+
   static async disconnect(sessionId: string): Promise<void> {
     if (!this.initialized) {
       throw new Error("SSHClient not initialized");
@@ -323,12 +952,16 @@ export class SSHClient {
     await this.wasmInstance.disconnect(sessionId);
   }
 
-  static async send(sessionId: string, data: Uint8Array): Promise<void> {
+  static async send(
+    sessionId: string,
+    data: Uint8Array,
+    options?: SendOptions
+  ): Promise<void> {
     if (!this.initialized) {
       throw new Error("SSHClient not initialized");
     }
 
-    await this.wasmInstance.send(sessionId, data);
+    await this.wasmInstance.send(sessionId, data, options);
   }
 
   static getVersion(): string {
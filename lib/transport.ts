@@ -3,13 +3,33 @@ export interface Transport {
   connect(): Promise<void>;
   disconnect(): Promise<void>;
   send(data: Uint8Array): Promise<void>;
-  onData?: (data: Uint8Array) => void;
+  /** Resolves late when the SSH client is behind; wait for it before delivering more */
+  onData?: (data: Uint8Array) => void | Promise<void>;
   onError?: (error: Error) => void;
   onClose?: () => void;
+  /** Received bytes buffered in WASM before onData holds back, 1 MiB by default */
+  highWaterMark?: number;
+}
+
+/** Default limit on a WebSocket's bufferedAmount before send() waits */
+export const DEFAULT_MAX_BUFFERED_AMOUNT = 1024 * 1024;
+
+/**
+ * Resolves once the socket has flushed its send buffer down to limit or closed.
+ * WebSocket has no drain event, so this polls bufferedAmount.
+ */
+export async function waitForBufferedAmount(
+  ws: WebSocket,
+  limit: number
+): Promise<void> {
+  while (ws.readyState === WebSocket.OPEN && ws.bufferedAmount > limit) {
+    await new Promise((resolve) => setTimeout(resolve, 10));
+  }
 }
 
 export interface TransportCallbacks {
-  onWrite?: (data: Uint8Array) => void;
+  /** Return a Promise to hold further writes until the data has been taken */
+  onWrite?: (data: Uint8Array) => void | Promise<void>;
   onClose?: () => void;
 }
 
@@ -23,8 +43,11 @@ export class WebSocketTransport implements Transport {
   private protocols?: string | string[];
   private callbacks: TransportCallbacks = {};
 
+  /** send() waits while more than this many bytes are queued on the socket */
+  public maxBufferedAmount = DEFAULT_MAX_BUFFERED_AMOUNT;
+
   // Callbacks for transport events
-  public onData?: (data: Uint8Array) => void;
+  public onData?: (data: Uint8Array) => void | Promise<void>;
   public onError?: (error: Error) => void;
   public onClose?: () => void;
 
@@ -84,6 +107,7 @@ export class WebSocketTransport implements Transport {
       throw new Error("WebSocket is not connected");
     }
     this.ws.send(data.buffer);
+    await waitForBufferedAmount(this.ws, this.maxBufferedAmount);
   }
 
   setCallbacks(callbacks: TransportCallbacks): void {
@@ -99,7 +123,7 @@ export class CustomTransport implements Transport {
   private isConnected = false;
 
   // Callbacks for transport events
-  public onData?: (data: Uint8Array) => void;
+  public onData?: (data: Uint8Array) => void | Promise<void>;
   public onError?: (error: Error) => void;
   public onClose?: () => void;
 
@@ -146,10 +170,10 @@ export class CustomTransport implements Transport {
     }
   }
 
-  // Method to inject received data
-  injectData(data: Uint8Array): void {
+  // Method to inject received data; resolves when the SSH client has room for more
+  async injectData(data: Uint8Array): Promise<void> {
     if (this.onData) {
-      this.onData(data);
+      await this.onData(data);
     }
   }
 }
@@ -189,17 +213,19 @@ export class TransportManager {
 
     // Register the transport with WASM
     const callbacks = {
-      onWrite: (data: Uint8Array) => {
-        // Data from WASM to be sent over transport
+      onWrite: (data: Uint8Array) =>
+        // Data from WASM to be sent over transport. WASM holds further writes
+        // until the send has drained, and fails the connection if it rejects.
         transport.send(data).catch((error) => {
           if (transport.onError) {
             transport.onError(error);
           }
-        });
-      },
+          throw error;
+        }),
       onClose: () => {
         transport.disconnect().catch(console.error);
       },
+      highWaterMark: transport.highWaterMark,
     };
 
     try {
@@ -211,9 +237,17 @@ export class TransportManager {
     }
 
     // Set up data reception callback
-    transport.onData = (data: Uint8Array) => {
+    transport.onData = (data: Uint8Array) =>
       // Data received from transport, inject into WASM
       this.wasmInstance.injectTransportData(transport.id, data);
+
+    // Let the SSH connection see the transport drop so it can reconnect
+    const onClose = transport.onClose;
+    transport.onClose = () => {
+      if (this.transports.delete(transport.id)) {
+        this.wasmInstance.closeTransport(transport.id);
+      }
+      onClose?.();
     };
 
     this.transports.set(transport.id, transport);
@@ -222,11 +256,12 @@ export class TransportManager {
   async closeTransport(id: string): Promise<void> {
     const transport = this.transports.get(id);
     if (transport) {
+      // Deleting first keeps onClose from closing it in WASM a second time
+      this.transports.delete(id);
       await transport.disconnect();
       if (this.wasmInstance) {
         this.wasmInstance.closeTransport(id);
       }
-      this.transports.delete(id);
     }
   }
 
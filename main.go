@@ -3,10 +3,23 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"strconv"
 	"syscall/js"
+	"time"
 
+	"github.com/andrew/sshclient-wasm/pkg/scp"
+	"github.com/andrew/sshclient-wasm/pkg/sftp"
 	"github.com/andrew/sshclient-wasm/pkg/sshclient"
+	"golang.org/x/crypto/ssh"
 )
 
 func main() {
@@ -14,12 +27,14 @@ func main() {
 
 	js.Global().Set("SSHClient", js.ValueOf(map[string]interface{}{
 		"connect":             js.FuncOf(connect),
+		"connectWebSocket":    js.FuncOf(connectWebSocket),
 		"disconnect":          js.FuncOf(disconnect),
 		"send":                js.FuncOf(send),
 		"version":             js.FuncOf(version),
 		"createTransport":     js.FuncOf(createTransport),
 		"closeTransport":      js.FuncOf(closeTransport),
 		"injectTransportData": js.FuncOf(injectTransportData),
+		"createKnownHosts":    js.FuncOf(createKnownHosts),
 	}))
 
 	select {}
@@ -30,6 +45,49 @@ func connect(this js.Value, args []js.Value) interface{} {
 		return promiseReject("missing connection options or transport ID")
 	}
 
+	transportID := args[1].String()
+	return startClient(args[0], optionalArg(args, 2), func(options sshclient.ConnectionOptions) (sshclient.Transport, error) {
+		// Get the transport
+		transport, ok := sshclient.GetTransport(transportID)
+		if !ok {
+			return nil, errors.New("transport not found")
+		}
+		return transport, nil
+	}, false)
+}
+
+// connectWebSocket dials a WebSocket from Go and connects over it, so no
+// transport has to be wired up in JavaScript
+func connectWebSocket(this js.Value, args []js.Value) interface{} {
+	if len(args) < 2 {
+		return promiseReject("missing WebSocket URL or connection options")
+	}
+
+	url := args[0].String()
+	wsOptions := parseWebSocketOptions(args[1])
+	return startClient(args[1], optionalArg(args, 2), func(options sshclient.ConnectionOptions) (sshclient.Transport, error) {
+		ctx := context.Background()
+		if options.Timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, time.Duration(options.Timeout)*time.Second)
+			defer cancel()
+		}
+		return sshclient.DialWebSocket(ctx, url, wsOptions)
+	}, true)
+}
+
+// optionalArg returns args[i], or undefined when it was not passed
+func optionalArg(args []js.Value, i int) js.Value {
+	if len(args) > i {
+		return args[i]
+	}
+	return js.Undefined()
+}
+
+// startClient connects a client over the transport open returns and resolves
+// with the session object. With redial, reconnection attempts call open again
+// unless a requestTransport callback supplies transports instead.
+func startClient(optionsValue, callbacks js.Value, open func(sshclient.ConnectionOptions) (sshclient.Transport, error), redial bool) js.Value {
 	// Create a Promise and immediately start the async work
 	promiseConstructor := js.Global().Get("Promise")
 
@@ -47,22 +105,28 @@ func connect(this js.Value, args []js.Value) interface{} {
 		resolve := handlers.resolve
 		reject := handlers.reject
 
-		options := parseConnectionOptions(args[0])
-		transportID := args[1].String()
+		options, err := parseConnectionOptions(optionsValue)
+		if err != nil {
+			reject.Invoke(js.ValueOf(err.Error()))
+			return
+		}
 
-		// Get the transport
-		transport, ok := sshclient.GetTransport(transportID)
-		if !ok {
-			reject.Invoke(js.ValueOf("transport not found"))
+		transport, err := open(options)
+		if err != nil {
+			reject.Invoke(errorValue(err))
 			return
 		}
 
 		client := sshclient.New(options)
 		client.SetTransport(transport)
 
-		if len(args) > 2 && args[2].Type() == js.TypeObject {
-			callbacks := args[2]
+		if redial {
+			client.OnRequestTransport(func(attempt int) (sshclient.Transport, error) {
+				return open(options)
+			})
+		}
 
+		if callbacks.Type() == js.TypeObject {
 			if onPacketReceive := callbacks.Get("onPacketReceive"); onPacketReceive.Type() == js.TypeFunction {
 				client.OnPacketReceive(func(data []byte, metadata map[string]interface{}) {
 					// Convert byte slice to Uint8Array for JavaScript
@@ -83,81 +147,369 @@ func connect(this js.Value, args []js.Value) interface{} {
 				})
 			}
 
+			if onStderr := callbacks.Get("onStderr"); onStderr.Type() == js.TypeFunction {
+				client.OnStderr(func(data []byte, metadata map[string]interface{}) {
+					onStderr.Invoke(bytesToJS(data), js.ValueOf(metadata))
+				})
+			}
+
 			if onStateChange := callbacks.Get("onStateChange"); onStateChange.Type() == js.TypeFunction {
-				client.OnStateChange(func(state string) {
-					onStateChange.Invoke(js.ValueOf(state))
+				client.OnStateChange(func(state string, detail sshclient.StateDetail) {
+					jsDetail := map[string]interface{}{
+						"hop":  detail.Hop,
+						"host": detail.Host,
+						"port": detail.Port,
+					}
+					if detail.Reason != "" {
+						jsDetail["reason"] = detail.Reason
+					}
+					if detail.Attempt > 0 {
+						jsDetail["attempt"] = detail.Attempt
+					}
+					onStateChange.Invoke(js.ValueOf(state), js.ValueOf(jsDetail))
+				})
+			}
+
+			if onAuthPrompt := callbacks.Get("onAuthPrompt"); onAuthPrompt.Type() == js.TypeFunction {
+				client.OnAuthPrompt(func(prompt sshclient.AuthPrompt) ([]string, error) {
+					questions := make([]interface{}, len(prompt.Questions))
+					for i, question := range prompt.Questions {
+						questions[i] = map[string]interface{}{
+							"prompt": question,
+							"echo":   prompt.Echos[i],
+						}
+					}
+
+					result, err := awaitPromise(onAuthPrompt.Invoke(js.ValueOf(map[string]interface{}{
+						"user":        prompt.User,
+						"name":        prompt.Name,
+						"instruction": prompt.Instruction,
+						"questions":   questions,
+					})))
+					if err != nil {
+						return nil, err
+					}
+					if result.Type() != js.TypeObject {
+						return nil, errors.New("onAuthPrompt must return an array of answers")
+					}
+
+					answers := make([]string, result.Length())
+					for i := range answers {
+						answers[i] = result.Index(i).String()
+					}
+					return answers, nil
+				})
+			}
+
+			if onPassphraseNeeded := callbacks.Get("onPassphraseNeeded"); onPassphraseNeeded.Type() == js.TypeFunction {
+				client.OnPassphraseNeeded(func(label string) (string, error) {
+					result, err := awaitPromise(onPassphraseNeeded.Invoke(js.ValueOf(label)))
+					if err != nil {
+						return "", err
+					}
+					if result.Type() != js.TypeString {
+						return "", fmt.Errorf("no passphrase provided for private key %s", label)
+					}
+					return result.String(), nil
+				})
+			}
+
+			if requestTransport := callbacks.Get("requestTransport"); requestTransport.Type() == js.TypeFunction {
+				client.OnRequestTransport(func(attempt int) (sshclient.Transport, error) {
+					result, err := awaitPromise(requestTransport.Invoke(attempt))
+					if err != nil {
+						return nil, err
+					}
+					// Accept a transport object as well as its ID
+					if result.Type() == js.TypeObject {
+						result = result.Get("id")
+					}
+					if result.Type() != js.TypeString {
+						return nil, errors.New("requestTransport must return a transport ID")
+					}
+					transport, ok := sshclient.GetTransport(result.String())
+					if !ok {
+						return nil, fmt.Errorf("transport not found: %s", result.String())
+					}
+					return transport, nil
+				})
+			}
+
+			if onAgentConfirm := callbacks.Get("onAgentConfirm"); onAgentConfirm.Type() == js.TypeFunction {
+				client.Agent().OnConfirm(func(identity sshclient.AgentIdentity) (bool, error) {
+					result, err := awaitPromise(onAgentConfirm.Invoke(js.ValueOf(agentIdentityToJS(identity))))
+					if err != nil {
+						return false, err
+					}
+					return result.Truthy(), nil
 				})
 			}
 		}
 
 		sessionID, err := client.Connect()
 		if err != nil {
-			reject.Invoke(js.ValueOf(err.Error()))
+			reject.Invoke(errorValue(err))
 			return
 		}
 
+		// Sends resolve once the shell has accepted their data, in call order
+		var shellSends sendQueue
+
 		result := map[string]interface{}{
 			"sessionId": sessionID,
+			"authenticatedKey": js.FuncOf(func(this js.Value, _ []js.Value) interface{} {
+				return client.AuthenticatedKey()
+			}),
 			"send": js.FuncOf(func(this js.Value, sendArgs []js.Value) interface{} {
-				// Create a Promise for async send operation
+				if len(sendArgs) < 1 {
+					return promiseReject("no data provided")
+				}
+				data := make([]byte, sendArgs[0].Length())
+				js.CopyBytesToGo(data, sendArgs[0])
+				return shellSends.send(optionalArg(sendArgs, 1), func(ctx context.Context) (<-chan error, error) {
+					return client.QueueContext(ctx, data)
+				})
+			}),
+			"buffered": js.FuncOf(func(this js.Value, _ []js.Value) interface{} {
+				return client.Buffered()
+			}),
+			"flush": js.FuncOf(func(this js.Value, flushArgs []js.Value) interface{} {
+				ctx, cancel := sendContext(optionalArg(flushArgs, 0))
+				return goPromise(func() (interface{}, error) {
+					defer cancel()
+					return nil, client.Flush(ctx)
+				})
+			}),
+			"disconnect": js.FuncOf(func(this js.Value, _ []js.Value) interface{} {
+				// Create a Promise for async disconnect operation
 				promiseConstructor := js.Global().Get("Promise")
 
 				// Create handler function for the Promise
-				var sendHandler js.Func
-				sendHandler = js.FuncOf(func(this js.Value, promiseArgs []js.Value) interface{} {
-					defer sendHandler.Release()
+				var disconnectHandler js.Func
+				disconnectHandler = js.FuncOf(func(this js.Value, promiseArgs []js.Value) interface{} {
+					defer disconnectHandler.Release()
 
 					resolve := promiseArgs[0]
 					reject := promiseArgs[1]
 
-					// Run send in a goroutine to avoid blocking
+					// Run disconnect in a goroutine to avoid blocking
 					go func() {
-						if len(sendArgs) > 0 {
-							data := make([]byte, sendArgs[0].Length())
-							js.CopyBytesToGo(data, sendArgs[0])
-							err := client.Send(data)
-							if err != nil {
-								reject.Invoke(js.ValueOf(err.Error()))
-								return
-							}
-							resolve.Invoke(js.Null())
-						} else {
-							reject.Invoke(js.ValueOf("no data provided"))
+						err := client.Disconnect()
+						if err != nil {
+							reject.Invoke(js.ValueOf(err.Error()))
+							return
 						}
+						resolve.Invoke(js.Null())
 					}()
 
 					return nil
 				})
 
-				return promiseConstructor.New(sendHandler)
+				return promiseConstructor.New(disconnectHandler)
 			}),
-			"disconnect": js.FuncOf(func(this js.Value, _ []js.Value) interface{} {
-				// Create a Promise for async disconnect operation
+			"exec": js.FuncOf(func(this js.Value, execArgs []js.Value) interface{} {
+				// Create a Promise for async exec operation
 				promiseConstructor := js.Global().Get("Promise")
 
 				// Create handler function for the Promise
-				var disconnectHandler js.Func
-				disconnectHandler = js.FuncOf(func(this js.Value, promiseArgs []js.Value) interface{} {
-					defer disconnectHandler.Release()
+				var execHandler js.Func
+				execHandler = js.FuncOf(func(this js.Value, promiseArgs []js.Value) interface{} {
+					defer execHandler.Release()
 
 					resolve := promiseArgs[0]
 					reject := promiseArgs[1]
 
-					// Run disconnect in a goroutine to avoid blocking
+					if len(execArgs) < 1 {
+						reject.Invoke(js.ValueOf("missing command"))
+						return nil
+					}
+					command := execArgs[0].String()
+					options := parseExecOptions(execArgs[1:])
+
+					// Run the command in a goroutine to avoid blocking
 					go func() {
-						err := client.Disconnect()
+						result, err := client.Exec(command, options)
 						if err != nil {
-							reject.Invoke(js.ValueOf(err.Error()))
+							reject.Invoke(errorValue(err))
 							return
 						}
-						resolve.Invoke(js.Null())
+						resolve.Invoke(js.ValueOf(execResultToJS(result, options)))
 					}()
 
 					return nil
 				})
 
-				return promiseConstructor.New(disconnectHandler)
+				return promiseConstructor.New(execHandler)
+			}),
+			"openShell": js.FuncOf(func(this js.Value, openArgs []js.Value) interface{} {
+				options, err := parseSessionOptions(openArgs, true)
+				if err != nil {
+					return promiseReject(err.Error())
+				}
+				return openSession(func() (*sshclient.Session, error) {
+					return client.OpenShell(options)
+				})
+			}),
+			"openExec": js.FuncOf(func(this js.Value, openArgs []js.Value) interface{} {
+				if len(openArgs) < 1 {
+					return promiseReject("missing command")
+				}
+				command := openArgs[0].String()
+				options, err := parseSessionOptions(openArgs[1:], false)
+				if err != nil {
+					return promiseReject(err.Error())
+				}
+				return openSession(func() (*sshclient.Session, error) {
+					return client.OpenExec(command, options)
+				})
+			}),
+			"forwardLocal": js.FuncOf(func(this js.Value, forwardArgs []js.Value) interface{} {
+				if len(forwardArgs) < 2 {
+					return promiseReject("missing host or port")
+				}
+				host := forwardArgs[0].String()
+				port := forwardArgs[1].Int()
+				return goPromise(func() (interface{}, error) {
+					forward, err := client.ForwardLocal(host, port)
+					if err != nil {
+						return nil, err
+					}
+					return newForwardObject(forward), nil
+				})
+			}),
+			"listenRemote": js.FuncOf(func(this js.Value, listenArgs []js.Value) interface{} {
+				if len(listenArgs) < 2 {
+					return promiseReject("missing bind address or port")
+				}
+				bindAddr := listenArgs[0].String()
+				port := listenArgs[1].Int()
+				return goPromise(func() (interface{}, error) {
+					listener, err := client.ListenRemote(bindAddr, port)
+					if err != nil {
+						return nil, err
+					}
+					return newListenerObject(listener), nil
+				})
+			}),
+			"openSocks": js.FuncOf(func(this js.Value, socksArgs []js.Value) interface{} {
+				options := sshclient.SOCKSOptions{}
+				if len(socksArgs) > 0 && socksArgs[0].Type() == js.TypeObject {
+					if username := socksArgs[0].Get("username"); username.Type() == js.TypeString {
+						options.Username = username.String()
+					}
+					if password := socksArgs[0].Get("password"); password.Type() == js.TypeString {
+						options.Password = password.String()
+					}
+				}
+				return goPromise(func() (interface{}, error) {
+					stream, err := client.OpenSOCKS(options)
+					if err != nil {
+						return nil, err
+					}
+					return newForwardObject(stream), nil
+				})
+			}),
+			"sftp": js.FuncOf(func(this js.Value, _ []js.Value) interface{} {
+				return goPromise(func() (interface{}, error) {
+					sftpClient, err := client.SFTP()
+					if err != nil {
+						return nil, err
+					}
+					return newSFTPObject(sftpClient), nil
+				})
+			}),
+			"upload": js.FuncOf(func(this js.Value, uploadArgs []js.Value) interface{} {
+				if len(uploadArgs) < 2 || uploadArgs[0].Type() != js.TypeString {
+					return promiseReject("missing path or source")
+				}
+				options, err := parseTransferOptions(uploadArgs[2:])
+				if err != nil {
+					return promiseReject(err.Error())
+				}
+				src, size, err := parseTransferSource(uploadArgs[1])
+				if err != nil {
+					return promiseReject(err.Error())
+				}
+				return promiseResolve(newTransferJobObject(client.NewUploadJob(uploadArgs[0].String(), src, size, options)))
+			}),
+			"download": js.FuncOf(func(this js.Value, downloadArgs []js.Value) interface{} {
+				if len(downloadArgs) < 1 || downloadArgs[0].Type() != js.TypeString {
+					return promiseReject("missing path")
+				}
+				options, err := parseTransferOptions(downloadArgs[1:])
+				if err != nil {
+					return promiseReject(err.Error())
+				}
+				onData := js.Undefined()
+				if len(downloadArgs) > 1 && downloadArgs[1].Type() == js.TypeObject {
+					onData = downloadArgs[1].Get("onData")
+				}
+				if onData.Type() != js.TypeFunction {
+					return promiseReject("missing onData callback")
+				}
+				dst := writerAtFunc(func(data []byte, offset int64) error {
+					_, err := awaitPromise(onData.Invoke(bytesToJS(data), js.ValueOf(offset)))
+					return err
+				})
+				return promiseResolve(newTransferJobObject(client.NewDownloadJob(downloadArgs[0].String(), dst, options)))
+			}),
+			"scpUpload": js.FuncOf(func(this js.Value, scpArgs []js.Value) interface{} {
+				if len(scpArgs) < 2 || scpArgs[0].Type() != js.TypeString || scpArgs[1].Type() != js.TypeObject {
+					return promiseReject("missing target or files")
+				}
+				entries, err := parseSCPEntries(scpArgs[1])
+				if err != nil {
+					return promiseReject(err.Error())
+				}
+				options := parseSCPOptions(scpArgs[2:])
+				target := scpArgs[0].String()
+				return goPromise(func() (interface{}, error) {
+					return nil, client.SCPUpload(target, entries, options)
+				})
+			}),
+			"scpDownload": js.FuncOf(func(this js.Value, scpArgs []js.Value) interface{} {
+				if len(scpArgs) < 1 || scpArgs[0].Type() != js.TypeString {
+					return promiseReject("missing source")
+				}
+				options := parseSCPOptions(scpArgs[1:])
+				onData := js.Undefined()
+				if len(scpArgs) > 1 && scpArgs[1].Type() == js.TypeObject {
+					onData = scpArgs[1].Get("onData")
+				}
+				source := scpArgs[0].String()
+				return goPromise(func() (interface{}, error) {
+					entries := []interface{}{}
+					err := client.SCPDownload(source, func(entry scp.Entry) (io.Writer, error) {
+						jsEntry := scpEntryToJS(entry)
+						entries = append(entries, jsEntry)
+						if entry.IsDir() {
+							return nil, nil
+						}
+
+						// Without onData the contents are returned with the entry
+						if onData.Type() != js.TypeFunction {
+							buf := &bytes.Buffer{}
+							jsEntry["data"] = buf
+							return buf, nil
+						}
+						return io.NewOffsetWriter(writerAtFunc(func(data []byte, offset int64) error {
+							_, err := awaitPromise(onData.Invoke(bytesToJS(data), js.ValueOf(offset), js.ValueOf(entry.Path)))
+							return err
+						}), 0), nil
+					}, options)
+					if err != nil {
+						return nil, err
+					}
+					for _, jsEntry := range entries {
+						entry := jsEntry.(map[string]interface{})
+						if buf, ok := entry["data"].(*bytes.Buffer); ok {
+							entry["data"] = bytesToJS(buf.Bytes())
+						}
+					}
+					return entries, nil
+				})
 			}),
+			"agent": newAgentObject(client.Agent()),
 			"resizeTerminal": js.FuncOf(func(this js.Value, resizeArgs []js.Value) interface{} {
 				// Create a Promise for async resize operation
 				promiseConstructor := js.Global().Get("Promise")
@@ -175,7 +527,12 @@ func connect(this js.Value, args []js.Value) interface{} {
 						if len(resizeArgs) >= 2 {
 							cols := resizeArgs[0].Int()
 							rows := resizeArgs[1].Int()
-							err := client.ResizeTerminal(cols, rows)
+							width, height := 0, 0
+							if len(resizeArgs) >= 4 {
+								width = resizeArgs[2].Int()
+								height = resizeArgs[3].Int()
+							}
+							err := client.ResizeTerminalPixels(cols, rows, width, height)
 							if err != nil {
 								reject.Invoke(js.ValueOf(err.Error()))
 								return
@@ -193,6 +550,10 @@ func connect(this js.Value, args []js.Value) interface{} {
 			}),
 		}
 
+		if ws, ok := transport.(*sshclient.WebSocketTransport); ok {
+			result["protocol"] = ws.Protocol()
+		}
+
 		resolve.Invoke(js.ValueOf(result))
 	}()
 
@@ -236,19 +597,102 @@ func send(this js.Value, args []js.Value) interface{} {
 	data := make([]byte, args[1].Length())
 	js.CopyBytesToGo(data, args[1])
 
-	err := sshclient.SendToSession(sessionID, data)
-	if err != nil {
-		return promiseReject(err.Error())
+	return sessionSends.send(optionalArg(args, 2), func(ctx context.Context) (<-chan error, error) {
+		return sshclient.QueueToSession(ctx, sessionID, data)
+	})
+}
+
+// sessionSends orders the data of SSHClient.send calls
+var sessionSends sendQueue
+
+// sendQueue keeps successive sends in call order while each one waits for
+// its data to be accepted in its own goroutine. It must only be used from
+// the JavaScript event loop.
+type sendQueue struct {
+	last chan struct{}
+}
+
+// send queues data once the previous send has queued its own, and resolves
+// when the channel has accepted it. The wait is bounded by the timeout and
+// AbortSignal in options; data not yet being written by then is dropped.
+func (q *sendQueue) send(options js.Value, queue func(context.Context) (<-chan error, error)) js.Value {
+	prev := q.last
+	queued := make(chan struct{})
+	q.last = queued
+	ctx, cancel := sendContext(options)
+
+	return goPromise(func() (interface{}, error) {
+		defer cancel()
+		if prev != nil {
+			select {
+			case <-prev:
+			case <-ctx.Done():
+				// Later sends still wait their turn behind prev
+				go func() {
+					<-prev
+					close(queued)
+				}()
+				return nil, ctx.Err()
+			}
+		}
+		done, err := queue(ctx)
+		close(queued)
+		if err != nil {
+			return nil, err
+		}
+		select {
+		case err := <-done:
+			return nil, err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	})
+}
+
+// sendContext returns a context bounded by the timeout in milliseconds and
+// the AbortSignal of send and flush options. It must be called on the
+// JavaScript event loop.
+func sendContext(options js.Value) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	if options.Type() != js.TypeObject {
+		return ctx, cancel
 	}
 
-	return promiseResolve(nil)
+	if timeout := options.Get("timeout"); timeout.Type() == js.TypeNumber && timeout.Int() > 0 {
+		var cancelTimeout context.CancelFunc
+		ctx, cancelTimeout = context.WithTimeout(ctx, time.Duration(timeout.Int())*time.Millisecond)
+		cancelParent := cancel
+		cancel = func() {
+			cancelTimeout()
+			cancelParent()
+		}
+	}
+
+	if signal := options.Get("signal"); signal.Type() == js.TypeObject {
+		if signal.Get("aborted").Bool() {
+			cancel()
+			return ctx, cancel
+		}
+		cancelCtx := cancel
+		onAbort := js.FuncOf(func(this js.Value, _ []js.Value) interface{} {
+			cancelCtx()
+			return nil
+		})
+		signal.Call("addEventListener", "abort", onAbort)
+		cancel = func() {
+			cancelCtx()
+			signal.Call("removeEventListener", "abort", onAbort)
+			onAbort.Release()
+		}
+	}
+	return ctx, cancel
 }
 
 func version(this js.Value, args []js.Value) interface{} {
 	return js.ValueOf("1.0.4")
 }
 
-func parseConnectionOptions(jsObj js.Value) sshclient.ConnectionOptions {
+func parseConnectionOptions(jsObj js.Value) (sshclient.ConnectionOptions, error) {
 	options := sshclient.ConnectionOptions{}
 
 	if host := jsObj.Get("host"); host.Type() != js.TypeUndefined {
@@ -271,111 +715,1409 @@ func parseConnectionOptions(jsObj js.Value) sshclient.ConnectionOptions {
 		options.PrivateKey = privateKey.String()
 	}
 
+	if passphrase := jsObj.Get("passphrase"); passphrase.Type() != js.TypeUndefined {
+		options.Passphrase = passphrase.String()
+	}
+
+	if certificate := jsObj.Get("certificate"); certificate.Type() != js.TypeUndefined {
+		options.Certificate = certificate.String()
+	}
+
+	if privateKeys := jsObj.Get("privateKeys"); privateKeys.Type() == js.TypeObject {
+		for i := 0; i < privateKeys.Length(); i++ {
+			entry := privateKeys.Index(i)
+			if entry.Type() == js.TypeString {
+				options.PrivateKeys = append(options.PrivateKeys, sshclient.PrivateKey{Key: entry.String()})
+				continue
+			}
+
+			key := sshclient.PrivateKey{}
+			if label := entry.Get("label"); label.Type() != js.TypeUndefined {
+				key.Label = label.String()
+			}
+			if privateKey := entry.Get("key"); privateKey.Type() != js.TypeUndefined {
+				key.Key = privateKey.String()
+			}
+			if passphrase := entry.Get("passphrase"); passphrase.Type() != js.TypeUndefined {
+				key.Passphrase = passphrase.String()
+			}
+			if certificate := entry.Get("certificate"); certificate.Type() != js.TypeUndefined {
+				key.Certificate = certificate.String()
+			}
+			options.PrivateKeys = append(options.PrivateKeys, key)
+		}
+	}
+
+	if externalKeys := jsObj.Get("externalKeys"); externalKeys.Type() == js.TypeObject {
+		for i := 0; i < externalKeys.Length(); i++ {
+			options.ExternalKeys = append(options.ExternalKeys, parseExternalKey(externalKeys.Index(i)))
+		}
+	}
+
+	if forwardAgent := jsObj.Get("forwardAgent"); forwardAgent.Type() == js.TypeBoolean {
+		options.ForwardAgent = forwardAgent.Bool()
+	}
+
+	// pty is either PTY settings or false to run the shell without a terminal
+	if pty := jsObj.Get("pty"); pty.Type() == js.TypeBoolean {
+		options.PTY.Disabled = !pty.Bool()
+	} else if pty.Type() == js.TypeObject {
+		ptyOptions, err := parsePTYOptions(pty)
+		if err != nil {
+			return options, err
+		}
+		options.PTY = ptyOptions
+	}
+
 	if timeout := jsObj.Get("timeout"); timeout.Type() != js.TypeUndefined {
 		options.Timeout = timeout.Int()
 	}
 
-	return options
-}
+	if hostKey := jsObj.Get("hostKey"); hostKey.Type() == js.TypeObject {
+		policy, err := parseHostKeyPolicy(hostKey)
+		if err != nil {
+			return options, err
+		}
+		options.HostKey = policy
+	}
 
-func promiseResolve(value interface{}) js.Value {
-	promiseConstructor := js.Global().Get("Promise")
-	return promiseConstructor.Call("resolve", js.ValueOf(value))
+	if keepalive := jsObj.Get("keepalive"); keepalive.Type() == js.TypeObject {
+		if interval := keepalive.Get("interval"); interval.Type() == js.TypeNumber {
+			options.Keepalive.Interval = interval.Int()
+		}
+		if countMax := keepalive.Get("countMax"); countMax.Type() == js.TypeNumber {
+			options.Keepalive.CountMax = countMax.Int()
+		}
+	}
+
+	if reconnect := jsObj.Get("reconnect"); reconnect.Type() == js.TypeObject {
+		if maxAttempts := reconnect.Get("maxAttempts"); maxAttempts.Type() == js.TypeNumber {
+			options.Reconnect.MaxAttempts = maxAttempts.Int()
+		}
+		if initialDelay := reconnect.Get("initialDelay"); initialDelay.Type() == js.TypeNumber {
+			options.Reconnect.InitialDelay = initialDelay.Int()
+		}
+		if maxDelay := reconnect.Get("maxDelay"); maxDelay.Type() == js.TypeNumber {
+			options.Reconnect.MaxDelay = maxDelay.Int()
+		}
+		if multiplier := reconnect.Get("multiplier"); multiplier.Type() == js.TypeNumber {
+			options.Reconnect.Multiplier = multiplier.Float()
+		}
+		if jitter := reconnect.Get("jitter"); jitter.Type() == js.TypeNumber {
+			options.Reconnect.Jitter = jitter.Float()
+			if options.Reconnect.Jitter == 0 {
+				// Zero means the default in Go, but no jitter to JavaScript callers
+				options.Reconnect.Jitter = -1
+			}
+		}
+	}
+
+	// Each jump host takes the same options as the target, with its own
+	// user, credentials and host key policy
+	if jumpHosts := jsObj.Get("jumpHosts"); jumpHosts.Type() == js.TypeObject {
+		for i := 0; i < jumpHosts.Length(); i++ {
+			jump, err := parseConnectionOptions(jumpHosts.Index(i))
+			if err != nil {
+				return options, fmt.Errorf("jumpHosts[%d]: %v", i, err)
+			}
+			if jump.Port == 0 {
+				jump.Port = 22
+			}
+			options.JumpHosts = append(options.JumpHosts, jump)
+		}
+	}
+
+	return options, nil
 }
 
-func promiseReject(reason string) js.Value {
-	promiseConstructor := js.Global().Get("Promise")
-	return promiseConstructor.Call("reject", js.ValueOf(reason))
+// parseWebSocketOptions reads the WebSocket settings from connectWebSocket's
+// connection options
+func parseWebSocketOptions(jsObj js.Value) sshclient.WebSocketOptions {
+	var options sshclient.WebSocketOptions
+	if jsObj.Type() != js.TypeObject {
+		return options
+	}
+
+	switch protocols := jsObj.Get("protocols"); protocols.Type() {
+	case js.TypeString:
+		options.Protocols = []string{protocols.String()}
+	case js.TypeObject:
+		for i := 0; i < protocols.Length(); i++ {
+			options.Protocols = append(options.Protocols, protocols.Index(i).String())
+		}
+	}
+
+	if maxBufferedAmount := jsObj.Get("maxBufferedAmount"); maxBufferedAmount.Type() == js.TypeNumber {
+		options.MaxBufferedAmount = maxBufferedAmount.Int()
+	}
+	return options
 }
 
-// createTransport creates a new transport bridge to JavaScript
-func createTransport(this js.Value, args []js.Value) interface{} {
-	if len(args) < 1 {
-		return promiseReject("missing transport ID")
+func parsePTYOptions(jsObj js.Value) (sshclient.PTYOptions, error) {
+	options := sshclient.PTYOptions{}
+
+	if term := jsObj.Get("term"); term.Type() == js.TypeString {
+		options.Term = term.String()
 	}
 
-	transportID := args[0].String()
+	if cols := jsObj.Get("cols"); cols.Type() == js.TypeNumber {
+		options.Cols = cols.Int()
+	}
 
-	var onWrite js.Value
-	var onClose js.Value
+	if rows := jsObj.Get("rows"); rows.Type() == js.TypeNumber {
+		options.Rows = rows.Int()
+	}
 
-	if len(args) > 1 && args[1].Type() == js.TypeObject {
-		callbacks := args[1]
-		onWrite = callbacks.Get("onWrite")
-		onClose = callbacks.Get("onClose")
+	if width := jsObj.Get("width"); width.Type() == js.TypeNumber {
+		options.Width = width.Int()
 	}
 
-	// Create write callback
-	writeFunc := func(data []byte) error {
-		if onWrite.Type() == js.TypeFunction {
-			// Convert byte array to Uint8Array for JavaScript
-			arrayConstructor := js.Global().Get("Uint8Array")
-			dst := arrayConstructor.New(len(data))
-			js.CopyBytesToJS(dst, data)
-			onWrite.Invoke(dst)
-		}
-		return nil
+	if height := jsObj.Get("height"); height.Type() == js.TypeNumber {
+		options.Height = height.Int()
 	}
 
-	// Create close callback
-	closeFunc := func() error {
-		if onClose.Type() == js.TypeFunction {
-			onClose.Invoke()
+	if modes := jsObj.Get("modes"); modes.Type() == js.TypeObject {
+		options.Modes = ssh.TerminalModes{}
+		names := js.Global().Get("Object").Call("keys", modes)
+		for i := 0; i < names.Length(); i++ {
+			name := names.Index(i).String()
+			opcode, ok := sshclient.ParseTerminalMode(name)
+			if !ok {
+				return options, fmt.Errorf("unknown terminal mode: %s", name)
+			}
+			// Flags read naturally as booleans, speeds and characters as numbers
+			switch value := modes.Get(name); value.Type() {
+			case js.TypeBoolean:
+				if value.Bool() {
+					options.Modes[opcode] = 1
+				} else {
+					options.Modes[opcode] = 0
+				}
+			case js.TypeNumber:
+				options.Modes[opcode] = uint32(value.Int())
+			default:
+				return options, fmt.Errorf("terminal mode %s must be a number or boolean", name)
+			}
 		}
-		return nil
 	}
 
-	transport := sshclient.NewJSTransport(transportID, writeFunc, closeFunc)
-	sshclient.RegisterTransport(transportID, transport)
-
-	return js.ValueOf(map[string]interface{}{
-		"id":     transportID,
-		"status": "created",
-	})
+	return options, nil
 }
 
-// closeTransport closes a transport
-func closeTransport(this js.Value, args []js.Value) interface{} {
-	if len(args) < 1 {
-		return promiseReject("missing transport ID")
+// parseExternalKey wraps a key whose sign function runs in JavaScript, for
+// example over a non-extractable WebCrypto key
+func parseExternalKey(jsObj js.Value) sshclient.ExternalKey {
+	key := sshclient.ExternalKey{}
+
+	if label := jsObj.Get("label"); label.Type() != js.TypeUndefined {
+		key.Label = label.String()
 	}
 
-	transportID := args[0].String()
-	transport, ok := sshclient.GetTransport(transportID)
-	if !ok {
-		return promiseReject("transport not found")
+	if publicKey := jsObj.Get("publicKey"); publicKey.Type() != js.TypeUndefined {
+		key.PublicKey = publicKey.String()
 	}
 
-	err := transport.Close()
-	sshclient.RemoveTransport(transportID)
+	if certificate := jsObj.Get("certificate"); certificate.Type() != js.TypeUndefined {
+		key.Certificate = certificate.String()
+	}
 
-	if err != nil {
-		return promiseReject(err.Error())
+	if sign := jsObj.Get("sign"); sign.Type() == js.TypeFunction {
+		key.Sign = func(data []byte, algorithm string) ([]byte, error) {
+			result, err := awaitPromise(sign.Invoke(bytesToJS(data), js.ValueOf(algorithm)))
+			if err != nil {
+				return nil, err
+			}
+			return bytesFromJS(result)
+		}
 	}
 
-	return promiseResolve(nil)
+	return key
 }
 
-// injectTransportData injects data into a transport from JavaScript
-func injectTransportData(this js.Value, args []js.Value) interface{} {
-	if len(args) < 2 {
-		return promiseReject("missing transport ID or data")
+// parseExecOptions reads the optional { stdin, onStdout, onStderr } argument of exec
+func parseExecOptions(args []js.Value) sshclient.ExecOptions {
+	options := sshclient.ExecOptions{}
+	if len(args) < 1 || args[0].Type() != js.TypeObject {
+		return options
 	}
+	jsOptions := args[0]
 
-	transportID := args[0].String()
-	transport, ok := sshclient.GetTransport(transportID)
-	if !ok {
-		return promiseReject("transport not found")
+	if stdin := jsOptions.Get("stdin"); stdin.Type() == js.TypeString {
+		options.Stdin = []byte(stdin.String())
+	} else if stdin.Type() == js.TypeObject {
+		if data, err := bytesFromJS(stdin); err == nil {
+			options.Stdin = data
+		}
 	}
 
-	// Convert JavaScript Uint8Array to Go []byte
-	data := make([]byte, args[1].Length())
-	js.CopyBytesToGo(data, args[1])
+	if onStdout := jsOptions.Get("onStdout"); onStdout.Type() == js.TypeFunction {
+		options.OnStdout = func(data []byte) {
+			onStdout.Invoke(bytesToJS(data))
+		}
+	}
 
-	err := transport.InjectData(data)
-	if err != nil {
-		return promiseReject(err.Error())
+	if onStderr := jsOptions.Get("onStderr"); onStderr.Type() == js.TypeFunction {
+		options.OnStderr = func(data []byte) {
+			onStderr.Invoke(bytesToJS(data))
+		}
 	}
 
-	return promiseResolve(nil)
+	return options
+}
+
+func execResultToJS(result sshclient.ExecResult, options sshclient.ExecOptions) map[string]interface{} {
+	jsResult := exitStatusToJS(result)
+
+	// Output is only collected for streams without a callback
+	if options.OnStdout == nil {
+		jsResult["stdout"] = bytesToJS(result.Stdout)
+	}
+	if options.OnStderr == nil {
+		jsResult["stderr"] = bytesToJS(result.Stderr)
+	}
+
+	return jsResult
+}
+
+func exitStatusToJS(result sshclient.ExecResult) map[string]interface{} {
+	return map[string]interface{}{
+		"exitCode":     result.ExitCode,
+		"exitSignal":   result.ExitSignal,
+		"errorMessage": result.ErrorMessage,
+		"exitMissing":  result.ExitMissing,
+	}
+}
+
+// parseSessionOptions reads the optional { pty, forwardAgent, onData, onStderr, onExit }
+// argument of openShell and openExec. Shells get a PTY unless pty is false,
+// commands only when pty is given.
+func parseSessionOptions(args []js.Value, defaultPTY bool) (sshclient.SessionOptions, error) {
+	options := sshclient.SessionOptions{}
+	if defaultPTY {
+		options.PTY = &sshclient.PTYOptions{}
+	}
+	if len(args) < 1 || args[0].Type() != js.TypeObject {
+		return options, nil
+	}
+	jsOptions := args[0]
+
+	if pty := jsOptions.Get("pty"); pty.Type() == js.TypeBoolean {
+		if !pty.Bool() {
+			options.PTY = nil
+		} else if options.PTY == nil {
+			options.PTY = &sshclient.PTYOptions{}
+		}
+	} else if pty.Type() == js.TypeObject {
+		ptyOptions, err := parsePTYOptions(pty)
+		if err != nil {
+			return options, err
+		}
+		options.PTY = &ptyOptions
+	}
+
+	if forwardAgent := jsOptions.Get("forwardAgent"); forwardAgent.Type() == js.TypeBoolean {
+		options.ForwardAgent = forwardAgent.Bool()
+	}
+
+	if onData := jsOptions.Get("onData"); onData.Type() == js.TypeFunction {
+		options.OnData = func(data []byte, metadata map[string]interface{}) {
+			onData.Invoke(bytesToJS(data), js.ValueOf(metadata))
+		}
+	}
+
+	if onStderr := jsOptions.Get("onStderr"); onStderr.Type() == js.TypeFunction {
+		options.OnStderr = func(data []byte, metadata map[string]interface{}) {
+			onStderr.Invoke(bytesToJS(data), js.ValueOf(metadata))
+		}
+	}
+
+	if onExit := jsOptions.Get("onExit"); onExit.Type() == js.TypeFunction {
+		options.OnExit = func(result sshclient.ExecResult, err error) {
+			if err != nil {
+				onExit.Invoke(js.Null(), js.ValueOf(err.Error()))
+				return
+			}
+			onExit.Invoke(js.ValueOf(exitStatusToJS(result)))
+		}
+	}
+
+	return options, nil
+}
+
+// goPromise runs fn in a goroutine, since it may wait on the transport, and
+// settles the returned Promise with its result
+func goPromise(fn func() (interface{}, error)) js.Value {
+	promiseConstructor := js.Global().Get("Promise")
+
+	var handler js.Func
+	handler = js.FuncOf(func(this js.Value, promiseArgs []js.Value) interface{} {
+		defer handler.Release()
+
+		resolve := promiseArgs[0]
+		reject := promiseArgs[1]
+
+		go func() {
+			value, err := fn()
+			if err != nil {
+				reject.Invoke(errorValue(err))
+				return
+			}
+			resolve.Invoke(js.ValueOf(value))
+		}()
+
+		return nil
+	})
+
+	return promiseConstructor.New(handler)
+}
+
+// openSession opens a channel and resolves with its handle
+func openSession(open func() (*sshclient.Session, error)) js.Value {
+	return goPromise(func() (interface{}, error) {
+		session, err := open()
+		if err != nil {
+			return nil, err
+		}
+		return newSessionObject(session), nil
+	})
+}
+
+// newSessionObject exposes one session channel to JavaScript
+func newSessionObject(session *sshclient.Session) map[string]interface{} {
+	var sends sendQueue
+	return map[string]interface{}{
+		"id": session.ID(),
+		"send": js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+			if len(args) < 1 {
+				return promiseReject("no data provided")
+			}
+			var data []byte
+			if args[0].Type() == js.TypeString {
+				data = []byte(args[0].String())
+			} else {
+				var err error
+				if data, err = bytesFromJS(args[0]); err != nil {
+					return promiseReject(err.Error())
+				}
+			}
+			return sends.send(optionalArg(args, 1), func(ctx context.Context) (<-chan error, error) {
+				return session.QueueContext(ctx, data)
+			})
+		}),
+		"buffered": js.FuncOf(func(this js.Value, _ []js.Value) interface{} {
+			return session.Buffered()
+		}),
+		"flush": js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+			ctx, cancel := sendContext(optionalArg(args, 0))
+			return goPromise(func() (interface{}, error) {
+				defer cancel()
+				return nil, session.Flush(ctx)
+			})
+		}),
+		"resizeTerminal": js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+			if len(args) < 2 {
+				return promiseReject("missing cols or rows parameters")
+			}
+			cols, rows := args[0].Int(), args[1].Int()
+			width, height := 0, 0
+			if len(args) >= 4 && args[2].Type() == js.TypeNumber && args[3].Type() == js.TypeNumber {
+				width = args[2].Int()
+				height = args[3].Int()
+			}
+			return goPromise(func() (interface{}, error) {
+				return nil, session.Resize(cols, rows, width, height)
+			})
+		}),
+		"close": js.FuncOf(func(this js.Value, _ []js.Value) interface{} {
+			return goPromise(func() (interface{}, error) {
+				return nil, session.Close()
+			})
+		}),
+		"wait": js.FuncOf(func(this js.Value, _ []js.Value) interface{} {
+			return goPromise(func() (interface{}, error) {
+				result, err := session.Wait()
+				if err != nil {
+					return nil, err
+				}
+				return exitStatusToJS(result), nil
+			})
+		}),
+	}
+}
+
+// newForwardObject exposes a forwarded channel to JavaScript as a duplex stream
+func newForwardObject(forward *sshclient.ForwardedChannel) map[string]interface{} {
+	return map[string]interface{}{
+		"id": forward.ID(),
+		"send": js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+			if len(args) < 1 {
+				return promiseReject("no data provided")
+			}
+			var data []byte
+			if args[0].Type() == js.TypeString {
+				data = []byte(args[0].String())
+			} else {
+				var err error
+				if data, err = bytesFromJS(args[0]); err != nil {
+					return promiseReject(err.Error())
+				}
+			}
+			return goPromise(func() (interface{}, error) {
+				return nil, forward.Send(data)
+			})
+		}),
+		"onData": js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+			if len(args) < 1 || args[0].Type() != js.TypeFunction {
+				return nil
+			}
+			callback := args[0]
+			forward.OnData(func(data []byte, metadata map[string]interface{}) {
+				callback.Invoke(bytesToJS(data), js.ValueOf(metadata))
+			})
+			return nil
+		}),
+		"onClose": js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+			if len(args) < 1 || args[0].Type() != js.TypeFunction {
+				return nil
+			}
+			callback := args[0]
+			forward.OnClose(func(err error) {
+				if err != nil {
+					callback.Invoke(js.ValueOf(err.Error()))
+					return
+				}
+				callback.Invoke()
+			})
+			return nil
+		}),
+		"close": js.FuncOf(func(this js.Value, _ []js.Value) interface{} {
+			return goPromise(func() (interface{}, error) {
+				return nil, forward.Close()
+			})
+		}),
+	}
+}
+
+// newListenerObject exposes a remote listener to JavaScript. Accepted
+// connections are forwarded channel handles with the originator's address.
+func newListenerObject(listener *sshclient.RemoteListener) map[string]interface{} {
+	address, port := splitAddr(listener.Addr())
+	return map[string]interface{}{
+		"id":      listener.ID(),
+		"address": address,
+		"port":    port,
+		"onConnection": js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+			if len(args) < 1 || args[0].Type() != js.TypeFunction {
+				return nil
+			}
+			callback := args[0]
+			listener.OnConnection(func(channel *sshclient.ForwardedChannel) {
+				handle := newForwardObject(channel)
+				originAddress, originPort := splitAddr(channel.RemoteAddr())
+				handle["origin"] = map[string]interface{}{
+					"address": originAddress,
+					"port":    originPort,
+				}
+				callback.Invoke(js.ValueOf(handle))
+			})
+			return nil
+		}),
+		"close": js.FuncOf(func(this js.Value, _ []js.Value) interface{} {
+			return goPromise(func() (interface{}, error) {
+				return nil, listener.Close()
+			})
+		}),
+	}
+}
+
+func splitAddr(addr net.Addr) (string, int) {
+	host, portString, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String(), 0
+	}
+	port, _ := strconv.Atoi(portString)
+	return host, port
+}
+
+// newSFTPObject exposes an SFTP session to JavaScript for file browsing and transfers
+func newSFTPObject(client *sftp.Client) map[string]interface{} {
+	// pathCall wraps an operation on the path given as first argument
+	pathCall := func(fn func(path string, args []js.Value) (interface{}, error)) js.Func {
+		return js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+			if len(args) < 1 || args[0].Type() != js.TypeString {
+				return promiseReject("missing path")
+			}
+			path := args[0].String()
+			return goPromise(func() (interface{}, error) {
+				return fn(path, args[1:])
+			})
+		})
+	}
+
+	return map[string]interface{}{
+		"list": pathCall(func(path string, _ []js.Value) (interface{}, error) {
+			entries, err := client.ReadDir(path)
+			if err != nil {
+				return nil, err
+			}
+			result := make([]interface{}, len(entries))
+			for i, entry := range entries {
+				result[i] = fileInfoToJS(entry)
+			}
+			return result, nil
+		}),
+		"stat": pathCall(func(path string, _ []js.Value) (interface{}, error) {
+			info, err := client.Stat(path)
+			if err != nil {
+				return nil, err
+			}
+			return fileInfoToJS(info), nil
+		}),
+		"lstat": pathCall(func(path string, _ []js.Value) (interface{}, error) {
+			info, err := client.Lstat(path)
+			if err != nil {
+				return nil, err
+			}
+			return fileInfoToJS(info), nil
+		}),
+		"realpath": pathCall(func(path string, _ []js.Value) (interface{}, error) {
+			return client.RealPath(path)
+		}),
+		"readlink": pathCall(func(path string, _ []js.Value) (interface{}, error) {
+			return client.ReadLink(path)
+		}),
+		"mkdir": pathCall(func(path string, _ []js.Value) (interface{}, error) {
+			return nil, client.Mkdir(path)
+		}),
+		"remove": pathCall(func(path string, _ []js.Value) (interface{}, error) {
+			// Directories need RMDIR, so look before removing
+			info, err := client.Lstat(path)
+			if err != nil {
+				return nil, err
+			}
+			if info.IsDir() {
+				return nil, client.RemoveDirectory(path)
+			}
+			return nil, client.Remove(path)
+		}),
+		"rename": pathCall(func(path string, args []js.Value) (interface{}, error) {
+			if len(args) < 1 {
+				return nil, errors.New("missing new path")
+			}
+			return nil, client.Rename(path, args[0].String())
+		}),
+		"symlink": pathCall(func(target string, args []js.Value) (interface{}, error) {
+			if len(args) < 1 {
+				return nil, errors.New("missing link path")
+			}
+			return nil, client.Symlink(target, args[0].String())
+		}),
+		"chmod": pathCall(func(path string, args []js.Value) (interface{}, error) {
+			if len(args) < 1 || args[0].Type() != js.TypeNumber {
+				return nil, errors.New("missing mode")
+			}
+			return nil, client.Chmod(path, sftp.FileMode(uint32(args[0].Int())))
+		}),
+		"read": pathCall(func(path string, args []js.Value) (interface{}, error) {
+			onData, onProgress := js.Undefined(), js.Undefined()
+			if len(args) > 0 && args[0].Type() == js.TypeObject {
+				onData = args[0].Get("onData")
+				onProgress = args[0].Get("onProgress")
+			}
+
+			var buf bytes.Buffer
+			var w io.Writer = &buf
+			if onData.Type() == js.TypeFunction {
+				offset := 0
+				w = writerFunc(func(data []byte) {
+					onData.Invoke(bytesToJS(data), js.ValueOf(offset))
+					offset += len(data)
+				})
+			}
+
+			if _, err := client.Download(path, w, progressFunc(onProgress)); err != nil {
+				return nil, err
+			}
+			// Data is only collected when it was not streamed to onData
+			return bytesToJS(buf.Bytes()), nil
+		}),
+		"write": pathCall(func(path string, args []js.Value) (interface{}, error) {
+			if len(args) < 1 {
+				return nil, errors.New("missing data")
+			}
+			var data []byte
+			if args[0].Type() == js.TypeString {
+				data = []byte(args[0].String())
+			} else {
+				var err error
+				if data, err = bytesFromJS(args[0]); err != nil {
+					return nil, err
+				}
+			}
+			onProgress := js.Undefined()
+			if len(args) > 1 && args[1].Type() == js.TypeObject {
+				onProgress = args[1].Get("onProgress")
+			}
+
+			n, err := client.Upload(path, bytes.NewReader(data), int64(len(data)), progressFunc(onProgress))
+			if err != nil {
+				return nil, err
+			}
+			return n, nil
+		}),
+		"close": js.FuncOf(func(this js.Value, _ []js.Value) interface{} {
+			return goPromise(func() (interface{}, error) {
+				return nil, client.Close()
+			})
+		}),
+	}
+}
+
+func fileInfoToJS(info *sftp.FileInfo) map[string]interface{} {
+	attrs := info.Sys().(*sftp.Attributes)
+	return map[string]interface{}{
+		"name":        info.Name(),
+		"longname":    info.LongName(),
+		"size":        info.Size(),
+		"mode":        attrs.Permissions,
+		"isDirectory": info.IsDir(),
+		"isFile":      info.Mode().IsRegular(),
+		"isSymlink":   info.Mode()&fs.ModeSymlink != 0,
+		"uid":         attrs.UID,
+		"gid":         attrs.GID,
+		"atime":       attrs.Atime,
+		"mtime":       attrs.Mtime,
+	}
+}
+
+// progressFunc adapts an optional JS onProgress(transferred, total) callback
+func progressFunc(callback js.Value) sftp.ProgressFunc {
+	if callback.Type() != js.TypeFunction {
+		return nil
+	}
+	return func(transferred, total int64) {
+		callback.Invoke(js.ValueOf(transferred), js.ValueOf(total))
+	}
+}
+
+// writerFunc hands each write to a callback
+type writerFunc func([]byte)
+
+func (w writerFunc) Write(p []byte) (int, error) {
+	w(p)
+	return len(p), nil
+}
+
+// writerAtFunc hands each positioned write to a callback
+type writerAtFunc func(data []byte, offset int64) error
+
+func (w writerAtFunc) WriteAt(p []byte, off int64) (int, error) {
+	if err := w(p, off); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// readerAtFunc reads length bytes at offset through a callback, returning a
+// Uint8Array or ArrayBuffer
+type readerAtFunc func(offset, length int64) (js.Value, error)
+
+func (r readerAtFunc) ReadAt(p []byte, off int64) (int, error) {
+	value, err := r(off, int64(len(p)))
+	if err != nil {
+		return 0, err
+	}
+	data, err := bytesFromJS(value)
+	if err != nil {
+		return 0, err
+	}
+	n := copy(p, data)
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// parseTransferSource reads upload data from a Uint8Array, ArrayBuffer, Blob
+// or an object with a size and a read(offset, length) method that may return
+// a Promise. Data is read chunk by chunk so large files are never copied whole.
+func parseTransferSource(source js.Value) (io.ReaderAt, int64, error) {
+	if source.InstanceOf(js.Global().Get("ArrayBuffer")) {
+		source = js.Global().Get("Uint8Array").New(source)
+	}
+
+	switch {
+	case source.InstanceOf(js.Global().Get("Uint8Array")):
+		size := int64(source.Length())
+		return readerAtFunc(func(offset, length int64) (js.Value, error) {
+			return source.Call("subarray", offset, min(offset+length, size)), nil
+		}), size, nil
+
+	case js.Global().Get("Blob").Truthy() && source.InstanceOf(js.Global().Get("Blob")):
+		return readerAtFunc(func(offset, length int64) (js.Value, error) {
+			return awaitPromise(source.Call("slice", offset, offset+length).Call("arrayBuffer"))
+		}), int64(source.Get("size").Float()), nil
+
+	case source.Type() == js.TypeObject && source.Get("read").Type() == js.TypeFunction:
+		if source.Get("size").Type() != js.TypeNumber {
+			return nil, 0, errors.New("source is missing its size")
+		}
+		return readerAtFunc(func(offset, length int64) (js.Value, error) {
+			return awaitPromise(source.Call("read", offset, length))
+		}), int64(source.Get("size").Float()), nil
+	}
+	return nil, 0, errors.New("source must be a Uint8Array, ArrayBuffer, Blob or {size, read}")
+}
+
+// parseTransferOptions reads the options object of upload and download
+func parseTransferOptions(args []js.Value) (sshclient.TransferOptions, error) {
+	options := sshclient.TransferOptions{}
+	if len(args) < 1 || args[0].Type() != js.TypeObject {
+		return options, nil
+	}
+	jsOptions := args[0]
+
+	if chunkSize := jsOptions.Get("chunkSize"); chunkSize.Type() == js.TypeNumber {
+		options.ChunkSize = chunkSize.Int()
+	}
+	if maxOutstanding := jsOptions.Get("maxOutstanding"); maxOutstanding.Type() == js.TypeNumber {
+		options.MaxOutstanding = maxOutstanding.Int()
+	}
+	if offset := jsOptions.Get("offset"); offset.Type() == js.TypeNumber {
+		options.Offset = int64(offset.Float())
+	}
+	if resume := jsOptions.Get("resume"); resume.Type() == js.TypeBoolean {
+		options.Resume = resume.Bool()
+	}
+
+	switch verify := jsOptions.Get("verify"); verify.Type() {
+	case js.TypeBoolean:
+		if verify.Bool() {
+			options.Verify = sshclient.VerifyAuto
+		}
+	case js.TypeString:
+		switch verify.String() {
+		case sshclient.VerifyAuto, sshclient.VerifyCheckFile, sshclient.VerifyExec:
+			options.Verify = verify.String()
+		default:
+			return options, fmt.Errorf("unknown verify mode: %s", verify.String())
+		}
+	}
+
+	if sum := jsOptions.Get("sha256"); sum.Type() == js.TypeString {
+		digest, err := hex.DecodeString(sum.String())
+		if err != nil || len(digest) != sha256.Size {
+			return options, errors.New("sha256 must be 64 hex characters")
+		}
+		options.SHA256 = digest
+	}
+
+	if onCheckpoint := jsOptions.Get("onCheckpoint"); onCheckpoint.Type() == js.TypeFunction {
+		options.OnCheckpoint = func(checkpoint sshclient.TransferCheckpoint) {
+			onCheckpoint.Invoke(js.ValueOf(checkpointToJS(checkpoint)))
+		}
+	}
+
+	return options, nil
+}
+
+// newTransferJobObject exposes a resumable transfer to JavaScript. run can be
+// called again after a failure to continue from the checkpoint.
+func newTransferJobObject(job *sshclient.TransferJob) map[string]interface{} {
+	return map[string]interface{}{
+		"run": js.FuncOf(func(this js.Value, _ []js.Value) interface{} {
+			return goPromise(func() (interface{}, error) {
+				result, err := job.Run()
+				if err != nil {
+					return nil, err
+				}
+				return map[string]interface{}{
+					"size":         result.Size,
+					"resumedFrom":  result.ResumedFrom,
+					"sha256":       hex.EncodeToString(result.SHA256),
+					"verifiedBy":   result.VerifiedBy,
+					"verifiedFrom": result.VerifiedFrom,
+				}, nil
+			})
+		}),
+		"cancel": js.FuncOf(func(this js.Value, _ []js.Value) interface{} {
+			job.Cancel()
+			return nil
+		}),
+		"checkpoint": js.FuncOf(func(this js.Value, _ []js.Value) interface{} {
+			return js.ValueOf(checkpointToJS(job.Checkpoint()))
+		}),
+	}
+}
+
+func checkpointToJS(checkpoint sshclient.TransferCheckpoint) map[string]interface{} {
+	return map[string]interface{}{
+		"path":   checkpoint.Path,
+		"offset": checkpoint.Offset,
+		"size":   checkpoint.Size,
+	}
+}
+
+// parseSCPEntries reads the files of scpUpload: objects with a path, data
+// accepted by parseTransferSource or directory: true, and an optional mode,
+// mtime and atime in seconds
+func parseSCPEntries(files js.Value) ([]scp.Entry, error) {
+	entries := make([]scp.Entry, files.Length())
+	for i := range entries {
+		file := files.Index(i)
+		if file.Get("path").Type() != js.TypeString {
+			return nil, fmt.Errorf("file %d is missing its path", i)
+		}
+
+		entry := scp.Entry{Path: file.Get("path").String()}
+		if file.Get("directory").Truthy() {
+			entry.Mode = fs.ModeDir | 0755
+		} else {
+			src, size, err := parseTransferSource(file.Get("data"))
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", entry.Path, err)
+			}
+			entry.Mode = 0644
+			entry.Size = size
+			entry.Reader = io.NewSectionReader(src, 0, size)
+		}
+
+		if mode := file.Get("mode"); mode.Type() == js.TypeNumber {
+			entry.Mode = entry.Mode&fs.ModeDir | scp.FileMode(uint32(mode.Int()))
+		}
+		if mtime := file.Get("mtime"); mtime.Type() == js.TypeNumber {
+			entry.ModTime = time.Unix(int64(mtime.Float()), 0)
+		}
+		if atime := file.Get("atime"); atime.Type() == js.TypeNumber {
+			entry.AccessTime = time.Unix(int64(atime.Float()), 0)
+		}
+		entries[i] = entry
+	}
+	return entries, nil
+}
+
+// parseSCPOptions reads the options object of scpUpload and scpDownload
+func parseSCPOptions(args []js.Value) sshclient.SCPOptions {
+	options := sshclient.SCPOptions{}
+	if len(args) < 1 || args[0].Type() != js.TypeObject {
+		return options
+	}
+	jsOptions := args[0]
+
+	if recursive := jsOptions.Get("recursive"); recursive.Type() == js.TypeBoolean {
+		options.Recursive = recursive.Bool()
+	}
+	if preserve := jsOptions.Get("preserve"); preserve.Type() == js.TypeBoolean {
+		options.Preserve = preserve.Bool()
+	}
+	if onProgress := jsOptions.Get("onProgress"); onProgress.Type() == js.TypeFunction {
+		options.OnProgress = func(path string, transferred, total int64) {
+			onProgress.Invoke(js.ValueOf(path), js.ValueOf(transferred), js.ValueOf(total))
+		}
+	}
+	return options
+}
+
+func scpEntryToJS(entry scp.Entry) map[string]interface{} {
+	jsEntry := map[string]interface{}{
+		"path":        entry.Path,
+		"mode":        scp.ModeBits(entry.Mode),
+		"size":        entry.Size,
+		"isDirectory": entry.IsDir(),
+	}
+	if !entry.ModTime.IsZero() {
+		jsEntry["mtime"] = entry.ModTime.Unix()
+		jsEntry["atime"] = entry.AccessTime.Unix()
+	}
+	return jsEntry
+}
+
+// newAgentObject exposes the client's in-memory agent to JavaScript
+func newAgentObject(keyAgent *sshclient.Agent) map[string]interface{} {
+	return map[string]interface{}{
+		"add": js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+			if len(args) < 1 || args[0].Type() != js.TypeObject {
+				return promiseReject("missing key options")
+			}
+			jsOptions := args[0]
+
+			privateKey := jsOptions.Get("key")
+			if privateKey.Type() != js.TypeString {
+				return promiseReject("missing private key")
+			}
+
+			passphrase := ""
+			if p := jsOptions.Get("passphrase"); p.Type() == js.TypeString {
+				passphrase = p.String()
+			}
+
+			options := sshclient.AgentKeyOptions{}
+			if comment := jsOptions.Get("comment"); comment.Type() == js.TypeString {
+				options.Comment = comment.String()
+			}
+			if lifetime := jsOptions.Get("lifetime"); lifetime.Type() == js.TypeNumber {
+				options.Lifetime = time.Duration(lifetime.Int()) * time.Second
+			}
+			if confirm := jsOptions.Get("confirm"); confirm.Type() == js.TypeBoolean {
+				options.ConfirmBeforeUse = confirm.Bool()
+			}
+			if certificate := jsOptions.Get("certificate"); certificate.Type() == js.TypeString {
+				options.Certificate = certificate.String()
+			}
+
+			identity, err := keyAgent.AddPrivateKey(privateKey.String(), passphrase, options)
+			if err != nil {
+				return promiseReject(err.Error())
+			}
+			return promiseResolve(agentIdentityToJS(identity))
+		}),
+		"remove": js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+			if len(args) < 1 {
+				return promiseReject("missing public key")
+			}
+			key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(args[0].String()))
+			if err != nil {
+				return promiseReject(fmt.Sprintf("failed to parse public key: %v", err))
+			}
+			if err := keyAgent.Remove(key); err != nil {
+				return promiseReject(err.Error())
+			}
+			return promiseResolve(nil)
+		}),
+		"removeAll": js.FuncOf(func(this js.Value, _ []js.Value) interface{} {
+			if err := keyAgent.RemoveAll(); err != nil {
+				return promiseReject(err.Error())
+			}
+			return promiseResolve(nil)
+		}),
+		"list": js.FuncOf(func(this js.Value, _ []js.Value) interface{} {
+			identities, err := keyAgent.Identities()
+			if err != nil {
+				return promiseReject(err.Error())
+			}
+			result := []interface{}{}
+			for _, identity := range identities {
+				result = append(result, agentIdentityToJS(identity))
+			}
+			return promiseResolve(result)
+		}),
+		"lock": js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+			if len(args) < 1 {
+				return promiseReject("missing passphrase")
+			}
+			if err := keyAgent.Lock([]byte(args[0].String())); err != nil {
+				return promiseReject(err.Error())
+			}
+			return promiseResolve(nil)
+		}),
+		"unlock": js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+			if len(args) < 1 {
+				return promiseReject("missing passphrase")
+			}
+			if err := keyAgent.Unlock([]byte(args[0].String())); err != nil {
+				return promiseReject(err.Error())
+			}
+			return promiseResolve(nil)
+		}),
+	}
+}
+
+func agentIdentityToJS(identity sshclient.AgentIdentity) map[string]interface{} {
+	expiresAt := int64(0)
+	if !identity.ExpiresAt.IsZero() {
+		expiresAt = identity.ExpiresAt.Unix()
+	}
+
+	return map[string]interface{}{
+		"keyType":     identity.KeyType,
+		"fingerprint": identity.Fingerprint,
+		"publicKey":   identity.PublicKey,
+		"comment":     identity.Comment,
+		"confirm":     identity.ConfirmBeforeUse,
+		"expiresAt":   expiresAt,
+	}
+}
+
+func parseHostKeyPolicy(jsObj js.Value) (sshclient.HostKeyPolicy, error) {
+	policy := sshclient.HostKeyPolicy{}
+
+	if fingerprints := jsObj.Get("fingerprints"); fingerprints.Type() == js.TypeObject {
+		for i := 0; i < fingerprints.Length(); i++ {
+			policy.Fingerprints = append(policy.Fingerprints, fingerprints.Index(i).String())
+		}
+	} else if fingerprints.Type() == js.TypeString {
+		policy.Fingerprints = []string{fingerprints.String()}
+	}
+
+	// knownHosts is either known_hosts text or a handle from createKnownHosts
+	if knownHosts := jsObj.Get("knownHosts"); knownHosts.Type() == js.TypeString {
+		parsed, err := sshclient.ParseKnownHosts(knownHosts.String(), sshclient.KnownHostsOptions{})
+		if err != nil {
+			return policy, err
+		}
+		policy.KnownHosts = parsed
+	} else if knownHosts.Type() == js.TypeObject {
+		db, ok := sshclient.GetKnownHosts(knownHosts.Get("id").String())
+		if !ok {
+			return policy, errors.New("known_hosts database not found")
+		}
+		policy.KnownHosts = db
+	}
+
+	if insecure := jsObj.Get("insecureIgnoreHostKey"); insecure.Type() == js.TypeBoolean {
+		policy.InsecureIgnoreHostKey = insecure.Bool()
+	}
+
+	if verify := jsObj.Get("verify"); verify.Type() == js.TypeFunction {
+		policy.Prompt = func(info sshclient.HostKeyInfo) (sshclient.HostKeyDecision, error) {
+			result, err := awaitPromise(verify.Invoke(js.ValueOf(map[string]interface{}{
+				"hostname":    info.Hostname,
+				"remoteAddr":  info.RemoteAddr,
+				"keyType":     info.KeyType,
+				"fingerprint": info.Fingerprint,
+				"key":         bytesToJS(info.Key),
+			})))
+			if err != nil {
+				return sshclient.HostKeyReject, err
+			}
+
+			// Booleans are accepted as a shorthand for accept-once/reject
+			if result.Type() == js.TypeBoolean {
+				if result.Bool() {
+					return sshclient.HostKeyAcceptOnce, nil
+				}
+				return sshclient.HostKeyReject, nil
+			}
+			if result.Type() != js.TypeString {
+				return sshclient.HostKeyReject, nil
+			}
+			return sshclient.ParseHostKeyDecision(result.String())
+		}
+	}
+
+	return policy, nil
+}
+
+func promiseResolve(value interface{}) js.Value {
+	promiseConstructor := js.Global().Get("Promise")
+	return promiseConstructor.Call("resolve", js.ValueOf(value))
+}
+
+func promiseReject(reason string) js.Value {
+	promiseConstructor := js.Global().Get("Promise")
+	return promiseConstructor.Call("reject", js.ValueOf(reason))
+}
+
+// errorValue converts a Go error into the value a Promise is rejected with.
+// Typed errors become Error objects carrying their details, everything else
+// stays a plain message string.
+func errorValue(err error) js.Value {
+	var hostKeyErr *sshclient.HostKeyError
+	if errors.As(err, &hostKeyErr) {
+		jsErr := js.Global().Get("Error").New(err.Error())
+		jsErr.Set("name", "HostKeyError")
+		jsErr.Set("reason", string(hostKeyErr.Reason))
+		jsErr.Set("hostname", hostKeyErr.Hostname)
+		jsErr.Set("keyType", hostKeyErr.KeyType)
+		jsErr.Set("fingerprint", hostKeyErr.Fingerprint)
+		return jsErr
+	}
+
+	if errors.Is(err, sshclient.ErrHandshakeTimeout) || errors.Is(err, context.DeadlineExceeded) {
+		jsErr := js.Global().Get("Error").New(err.Error())
+		jsErr.Set("name", "TimeoutError")
+		return jsErr
+	}
+
+	if errors.Is(err, context.Canceled) {
+		jsErr := js.Global().Get("Error").New(err.Error())
+		jsErr.Set("name", "AbortError")
+		return jsErr
+	}
+
+	return js.ValueOf(err.Error())
+}
+
+// awaitPromise blocks until value settles when it is a thenable and returns
+// it unchanged otherwise. It must only be called off the JavaScript event loop.
+func awaitPromise(value js.Value) (js.Value, error) {
+	if value.Type() != js.TypeObject || value.Get("then").Type() != js.TypeFunction {
+		return value, nil
+	}
+
+	type settled struct {
+		value js.Value
+		err   error
+	}
+	done := make(chan settled, 1)
+
+	onResolve := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		result := js.Undefined()
+		if len(args) > 0 {
+			result = args[0]
+		}
+		done <- settled{value: result}
+		return nil
+	})
+	onReject := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		reason := "promise rejected"
+		if len(args) > 0 {
+			reason = jsErrorMessage(args[0])
+		}
+		done <- settled{err: errors.New(reason)}
+		return nil
+	})
+	defer onResolve.Release()
+	defer onReject.Release()
+
+	value.Call("then", onResolve, onReject)
+
+	result := <-done
+	return result.value, result.err
+}
+
+// jsErrorMessage extracts a message from a thrown JavaScript value
+func jsErrorMessage(value js.Value) string {
+	if value.Type() == js.TypeObject {
+		if message := value.Get("message"); message.Type() == js.TypeString {
+			return message.String()
+		}
+	}
+	return value.String()
+}
+
+// bytesFromJS copies a Uint8Array or ArrayBuffer into a byte slice
+func bytesFromJS(value js.Value) ([]byte, error) {
+	if value.InstanceOf(js.Global().Get("ArrayBuffer")) {
+		value = js.Global().Get("Uint8Array").New(value)
+	}
+	if !value.InstanceOf(js.Global().Get("Uint8Array")) {
+		return nil, errors.New("expected a Uint8Array or ArrayBuffer")
+	}
+
+	data := make([]byte, value.Length())
+	js.CopyBytesToGo(data, value)
+	return data, nil
+}
+
+// bytesToJS copies a byte slice into a new Uint8Array
+func bytesToJS(data []byte) js.Value {
+	arrayConstructor := js.Global().Get("Uint8Array")
+	dst := arrayConstructor.New(len(data))
+	js.CopyBytesToJS(dst, data)
+	return dst
+}
+
+// createTransport creates a new transport bridge to JavaScript
+func createTransport(this js.Value, args []js.Value) interface{} {
+	if len(args) < 1 {
+		return promiseReject("missing transport ID")
+	}
+
+	transportID := args[0].String()
+
+	var onWrite js.Value
+	var onClose js.Value
+	highWaterMark := 0
+
+	if len(args) > 1 && args[1].Type() == js.TypeObject {
+		callbacks := args[1]
+		onWrite = callbacks.Get("onWrite")
+		onClose = callbacks.Get("onClose")
+		if mark := callbacks.Get("highWaterMark"); mark.Type() == js.TypeNumber {
+			highWaterMark = mark.Int()
+		}
+	}
+
+	// Create write callback. A returned Promise is backpressure: the write is
+	// held until it settles, bounded by the transport's write deadline.
+	writeFunc := func(data []byte) error {
+		if onWrite.Type() == js.TypeFunction {
+			// Convert byte array to Uint8Array for JavaScript
+			arrayConstructor := js.Global().Get("Uint8Array")
+			dst := arrayConstructor.New(len(data))
+			js.CopyBytesToJS(dst, data)
+			if _, err := awaitPromise(onWrite.Invoke(dst)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// Create close callback
+	closeFunc := func() error {
+		if onClose.Type() == js.TypeFunction {
+			onClose.Invoke()
+		}
+		return nil
+	}
+
+	transport := sshclient.NewJSTransport(transportID, writeFunc, closeFunc)
+	transport.SetHighWaterMark(highWaterMark)
+	sshclient.RegisterTransport(transportID, transport)
+
+	return js.ValueOf(map[string]interface{}{
+		"id":     transportID,
+		"status": "created",
+	})
+}
+
+// closeTransport closes a transport
+func closeTransport(this js.Value, args []js.Value) interface{} {
+	if len(args) < 1 {
+		return promiseReject("missing transport ID")
+	}
+
+	transportID := args[0].String()
+	transport, ok := sshclient.GetTransport(transportID)
+	if !ok {
+		return promiseReject("transport not found")
+	}
+
+	err := transport.Close()
+	sshclient.RemoveTransport(transportID)
+
+	if err != nil {
+		return promiseReject(err.Error())
+	}
+
+	return promiseResolve(nil)
+}
+
+// injectTransportData injects data into a transport from JavaScript
+func injectTransportData(this js.Value, args []js.Value) interface{} {
+	if len(args) < 2 {
+		return promiseReject("missing transport ID or data")
+	}
+
+	transportID := args[0].String()
+	transport, ok := sshclient.GetTransport(transportID)
+	if !ok {
+		return promiseReject("transport not found")
+	}
+
+	// Convert JavaScript Uint8Array to Go []byte
+	data := make([]byte, args[1].Length())
+	js.CopyBytesToGo(data, args[1])
+
+	err := transport.InjectData(data)
+	if err != nil {
+		return promiseReject(err.Error())
+	}
+
+	// The data is queued either way; resolving late tells the sender to slow
+	// down until the SSH connection has caught up
+	ready := transport.Ready()
+	select {
+	case <-ready:
+		return promiseResolve(nil)
+	default:
+	}
+	return goPromise(func() (interface{}, error) {
+		<-ready
+		return nil, nil
+	})
+}
+
+// createKnownHosts creates a known_hosts database from text that JavaScript
+// loaded from its own storage, and returns a handle to query and serialize it
+func createKnownHosts(this js.Value, args []js.Value) interface{} {
+	data := ""
+	if len(args) > 0 && args[0].Type() == js.TypeString {
+		data = args[0].String()
+	}
+
+	options := sshclient.KnownHostsOptions{}
+	if len(args) > 1 && args[1].Type() == js.TypeObject {
+		jsOptions := args[1]
+
+		if trustOnFirstUse := jsOptions.Get("trustOnFirstUse"); trustOnFirstUse.Type() == js.TypeBoolean {
+			options.TrustOnFirstUse = trustOnFirstUse.Bool()
+		}
+
+		if hashHostnames := jsOptions.Get("hashHostnames"); hashHostnames.Type() == js.TypeBoolean {
+			options.HashHostnames = hashHostnames.Bool()
+		}
+
+		if onLearn := jsOptions.Get("onLearn"); onLearn.Type() == js.TypeFunction {
+			options.OnLearn = func(entry sshclient.KnownHostEntry) {
+				onLearn.Invoke(js.ValueOf(knownHostEntryToJS(entry)))
+			}
+		}
+	}
+
+	knownHosts, err := sshclient.ParseKnownHosts(data, options)
+	if err != nil {
+		return promiseReject(err.Error())
+	}
+	sshclient.RegisterKnownHosts(knownHosts)
+
+	return promiseResolve(map[string]interface{}{
+		"id": knownHosts.ID(),
+		"serialize": js.FuncOf(func(this js.Value, _ []js.Value) interface{} {
+			return promiseResolve(knownHosts.Serialize())
+		}),
+		"load": js.FuncOf(func(this js.Value, loadArgs []js.Value) interface{} {
+			if len(loadArgs) < 1 {
+				return promiseReject("missing known_hosts data")
+			}
+			if err := knownHosts.Load(loadArgs[0].String()); err != nil {
+				return promiseReject(err.Error())
+			}
+			return promiseResolve(nil)
+		}),
+		"entries": js.FuncOf(func(this js.Value, _ []js.Value) interface{} {
+			entries := []interface{}{}
+			for _, entry := range knownHosts.Entries() {
+				entries = append(entries, knownHostEntryToJS(entry))
+			}
+			return promiseResolve(entries)
+		}),
+		"add": js.FuncOf(func(this js.Value, addArgs []js.Value) interface{} {
+			if len(addArgs) < 2 {
+				return promiseReject("missing host or key")
+			}
+			key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(addArgs[1].String()))
+			if err != nil {
+				return promiseReject(fmt.Sprintf("failed to parse key: %v", err))
+			}
+
+			var entry sshclient.KnownHostEntry
+			if len(addArgs) > 2 && addArgs[2].Type() == js.TypeString {
+				entry, err = knownHosts.AddMarked(addArgs[2].String(), addArgs[0].String(), key)
+			} else {
+				entry, err = knownHosts.Add(addArgs[0].String(), key)
+			}
+			if err != nil {
+				return promiseReject(err.Error())
+			}
+			return promiseResolve(knownHostEntryToJS(entry))
+		}),
+		"remove": js.FuncOf(func(this js.Value, removeArgs []js.Value) interface{} {
+			if len(removeArgs) < 1 {
+				return promiseReject("missing host")
+			}
+			return promiseResolve(knownHosts.Remove(removeArgs[0].String()))
+		}),
+		"close": js.FuncOf(func(this js.Value, _ []js.Value) interface{} {
+			sshclient.RemoveKnownHosts(knownHosts.ID())
+			return promiseResolve(nil)
+		}),
+	})
+}
+
+func knownHostEntryToJS(entry sshclient.KnownHostEntry) map[string]interface{} {
+	hosts := make([]interface{}, len(entry.Hosts))
+	for i, host := range entry.Hosts {
+		hosts[i] = host
+	}
+
+	return map[string]interface{}{
+		"marker":      entry.Marker,
+		"hosts":       hosts,
+		"keyType":     entry.KeyType,
+		"fingerprint": entry.Fingerprint,
+		"comment":     entry.Comment,
+		"line":        entry.Line,
+	}
 }
@@ -0,0 +1,369 @@
+// Package scp implements both ends of the SCP protocol as spoken by a remote
+// "scp -t" (sink, used for uploads) and "scp -f" (source, used for downloads)
+package scp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// chunkSize is the size of each read while copying file contents
+const chunkSize = 32 * 1024
+
+// Entry is a file or directory sent or received over SCP
+type Entry struct {
+	// Path is slash separated and relative to the transfer target
+	Path string
+	// Mode holds the permission bits, with fs.ModeDir set for directories
+	Mode fs.FileMode
+	Size int64
+	// ModTime and AccessTime are zero unless times are preserved
+	ModTime    time.Time
+	AccessTime time.Time
+	// Reader provides the contents of a file being sent
+	Reader io.Reader
+}
+
+// IsDir reports whether the entry is a directory
+func (e Entry) IsDir() bool {
+	return e.Mode.IsDir()
+}
+
+// ProgressFunc reports how much of the file at path has been transferred
+type ProgressFunc func(path string, transferred, total int64)
+
+// Handler is called for every entry received. For files it returns the
+// writer for the contents; a nil writer discards them.
+type Handler func(entry Entry) (io.Writer, error)
+
+// Options configures a transfer
+type Options struct {
+	// Preserve sends modification and access times along with each entry
+	Preserve bool
+	// OnProgress is called after every chunk of file contents
+	OnProgress ProgressFunc
+}
+
+// Send runs the source side of an upload. r is the stdout of the remote
+// "scp -t" and w its stdin. Missing parent directories are created as the
+// entries below them are reached, so a directory only has to be listed,
+// before its contents, when its mode or times matter.
+func Send(r io.Reader, w io.Writer, entries []Entry, options Options) error {
+	s := &conn{r: bufio.NewReader(r), w: w, options: options}
+	if err := s.response(); err != nil {
+		return err
+	}
+
+	// Directories currently entered on the remote side, outermost first
+	var open []string
+	for _, entry := range entries {
+		p := path.Clean(entry.Path)
+		if !fs.ValidPath(p) || p == "." || strings.Contains(p, "\n") {
+			return fmt.Errorf("scp: invalid path %q", entry.Path)
+		}
+
+		target := strings.Split(p, "/")
+		if !entry.IsDir() {
+			target = target[:len(target)-1]
+		}
+
+		common := 0
+		for common < len(open) && common < len(target) && open[common] == target[common] {
+			common++
+		}
+		for len(open) > common {
+			if err := s.command("E\n"); err != nil {
+				return err
+			}
+			open = open[:len(open)-1]
+		}
+		for _, name := range target[common:] {
+			dir := Entry{Mode: fs.ModeDir | 0755}
+			if entry.IsDir() && len(open) == len(target)-1 {
+				dir = entry
+			}
+			if err := s.times(dir); err != nil {
+				return err
+			}
+			if err := s.command(fmt.Sprintf("D%04o 0 %s\n", ModeBits(dir.Mode), name)); err != nil {
+				return err
+			}
+			open = append(open, name)
+		}
+
+		if !entry.IsDir() {
+			if err := s.sendFile(p, entry); err != nil {
+				return err
+			}
+		}
+	}
+
+	for range open {
+		if err := s.command("E\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Receive runs the sink side of a download. r is the stdout of the remote
+// "scp -f" and w its stdin. Files the remote could not read are skipped and
+// reported in the returned error once the rest has been received.
+func Receive(r io.Reader, w io.Writer, handler Handler, options Options) error {
+	s := &conn{r: bufio.NewReader(r), w: w, options: options}
+	if err := s.ack(); err != nil {
+		return err
+	}
+
+	var dirs []string
+	var modTime, accessTime time.Time
+	var skipped []string
+	for {
+		line, err := s.r.ReadString('\n')
+		if err == io.EOF && line == "" {
+			if len(dirs) > 0 {
+				return fmt.Errorf("scp: connection closed inside %s", path.Join(dirs...))
+			}
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("scp: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			return errors.New("scp: protocol error: empty record")
+		}
+
+		switch line[0] {
+		case 1:
+			skipped = append(skipped, strings.TrimPrefix(strings.TrimSpace(line[1:]), "scp: "))
+			continue
+		case 2:
+			return remoteError(line[1:])
+		case 'T':
+			var mtime, mtimeUsec, atime, atimeUsec int64
+			if _, err := fmt.Sscanf(line[1:], "%d %d %d %d", &mtime, &mtimeUsec, &atime, &atimeUsec); err != nil {
+				return fmt.Errorf("scp: invalid times %q", line)
+			}
+			modTime = time.Unix(mtime, mtimeUsec*1000)
+			accessTime = time.Unix(atime, atimeUsec*1000)
+		case 'E':
+			if len(dirs) == 0 {
+				return errors.New("scp: unexpected end of directory")
+			}
+			dirs = dirs[:len(dirs)-1]
+		case 'C', 'D':
+			entry, err := parseHeader(line)
+			if err != nil {
+				return err
+			}
+			entry.Path = path.Join(append(dirs, entry.Path)...)
+			entry.ModTime, entry.AccessTime = modTime, accessTime
+			modTime, accessTime = time.Time{}, time.Time{}
+
+			dst, err := handler(entry)
+			if err != nil {
+				return err
+			}
+			if entry.IsDir() {
+				dirs = append(dirs, path.Base(entry.Path))
+				break
+			}
+
+			if err := s.ack(); err != nil {
+				return err
+			}
+			if dst == nil {
+				dst = io.Discard
+			}
+			if err := s.copy(dst, s.r, entry.Path, entry.Size); err != nil {
+				return err
+			}
+			if err := s.response(); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("scp: unexpected message %q", line)
+		}
+
+		if err := s.ack(); err != nil {
+			return err
+		}
+	}
+
+	if len(skipped) > 0 {
+		return fmt.Errorf("scp: %s", strings.Join(skipped, "; "))
+	}
+	return nil
+}
+
+// conn is one end of an SCP exchange
+type conn struct {
+	r       *bufio.Reader
+	w       io.Writer
+	options Options
+}
+
+// response reads the status byte the remote sends after each command
+func (s *conn) response() error {
+	status, err := s.r.ReadByte()
+	if err != nil {
+		return fmt.Errorf("scp: %v", err)
+	}
+	switch status {
+	case 0:
+		return nil
+	case 1, 2:
+		message, _ := s.r.ReadString('\n')
+		return remoteError(message)
+	}
+	return fmt.Errorf("scp: unexpected response %q", status)
+}
+
+// ack tells the remote to go on
+func (s *conn) ack() error {
+	if _, err := s.w.Write([]byte{0}); err != nil {
+		return fmt.Errorf("scp: %v", err)
+	}
+	return nil
+}
+
+// command sends a protocol line and waits for its response
+func (s *conn) command(line string) error {
+	if _, err := io.WriteString(s.w, line); err != nil {
+		return fmt.Errorf("scp: %v", err)
+	}
+	return s.response()
+}
+
+// times sends the modification and access times of entry when preserving them
+func (s *conn) times(entry Entry) error {
+	if !s.options.Preserve || entry.ModTime.IsZero() {
+		return nil
+	}
+	accessTime := entry.AccessTime
+	if accessTime.IsZero() {
+		accessTime = entry.ModTime
+	}
+	return s.command(fmt.Sprintf("T%d 0 %d 0\n", entry.ModTime.Unix(), accessTime.Unix()))
+}
+
+func (s *conn) sendFile(p string, entry Entry) error {
+	if entry.Reader == nil {
+		return fmt.Errorf("scp: no contents for %s", p)
+	}
+	if err := s.times(entry); err != nil {
+		return err
+	}
+	if err := s.command(fmt.Sprintf("C%04o %d %s\n", ModeBits(entry.Mode), entry.Size, path.Base(p))); err != nil {
+		return err
+	}
+	if err := s.copy(s.w, entry.Reader, p, entry.Size); err != nil {
+		return err
+	}
+	// The data is followed by our own status
+	if err := s.ack(); err != nil {
+		return err
+	}
+	return s.response()
+}
+
+// copy moves exactly size bytes of the file at p, reporting progress
+func (s *conn) copy(dst io.Writer, src io.Reader, p string, size int64) error {
+	buf := make([]byte, chunkSize)
+	var transferred int64
+	if s.options.OnProgress != nil {
+		s.options.OnProgress(p, 0, size)
+	}
+	for transferred < size {
+		n, err := io.ReadFull(src, buf[:min(int64(len(buf)), size-transferred)])
+		if n > 0 {
+			if _, werr := dst.Write(buf[:n]); werr != nil {
+				return fmt.Errorf("scp: failed to write %s: %v", p, werr)
+			}
+			transferred += int64(n)
+			if s.options.OnProgress != nil {
+				s.options.OnProgress(p, transferred, size)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("scp: failed to read %s after %d of %d bytes: %v", p, transferred, size, err)
+		}
+	}
+	return nil
+}
+
+// remoteError wraps a message from the remote scp, which usually carries
+// its own "scp: " prefix
+func remoteError(message string) error {
+	message = strings.TrimSpace(message)
+	if !strings.HasPrefix(message, "scp: ") {
+		message = "scp: " + message
+	}
+	return errors.New(message)
+}
+
+// parseHeader parses a "C" or "D" line of mode, size and name
+func parseHeader(line string) (Entry, error) {
+	fields := strings.SplitN(line[1:], " ", 3)
+	if len(fields) != 3 {
+		return Entry{}, fmt.Errorf("scp: invalid header %q", line)
+	}
+	bits, err := strconv.ParseUint(fields[0], 8, 32)
+	if err != nil {
+		return Entry{}, fmt.Errorf("scp: invalid mode in %q", line)
+	}
+	size, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil || size < 0 {
+		return Entry{}, fmt.Errorf("scp: invalid size in %q", line)
+	}
+	// A name must not climb out of or across directories
+	name := fields[2]
+	if name == "" || name == "." || name == ".." || strings.Contains(name, "/") {
+		return Entry{}, fmt.Errorf("scp: refusing unsafe name %q", name)
+	}
+
+	entry := Entry{Path: name, Mode: FileMode(uint32(bits)), Size: size}
+	if line[0] == 'D' {
+		entry.Mode |= fs.ModeDir
+		entry.Size = 0
+	}
+	return entry, nil
+}
+
+// ModeBits converts a file mode to the octal permissions SCP sends
+func ModeBits(mode fs.FileMode) uint32 {
+	bits := uint32(mode.Perm())
+	if mode&fs.ModeSetuid != 0 {
+		bits |= 04000
+	}
+	if mode&fs.ModeSetgid != 0 {
+		bits |= 02000
+	}
+	if mode&fs.ModeSticky != 0 {
+		bits |= 01000
+	}
+	return bits
+}
+
+// FileMode converts octal permissions from SCP to a file mode
+func FileMode(bits uint32) fs.FileMode {
+	mode := fs.FileMode(bits & 0777)
+	if bits&04000 != 0 {
+		mode |= fs.ModeSetuid
+	}
+	if bits&02000 != 0 {
+		mode |= fs.ModeSetgid
+	}
+	if bits&01000 != 0 {
+		mode |= fs.ModeSticky
+	}
+	return mode
+}
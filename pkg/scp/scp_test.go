@@ -0,0 +1,427 @@
+package scp
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseHeader(t *testing.T) {
+	tests := []struct {
+		name    string
+		line    string
+		want    Entry
+		wantErr bool
+	}{
+		{"file", "C0644 12 notes.txt", Entry{Path: "notes.txt", Mode: 0644, Size: 12}, false},
+		{"empty file", "C0600 0 empty", Entry{Path: "empty", Mode: 0600}, false},
+		{"name with spaces", "C0644 3 my file.txt", Entry{Path: "my file.txt", Mode: 0644, Size: 3}, false},
+		{"setuid", "C4755 1 tool", Entry{Path: "tool", Mode: fs.ModeSetuid | 0755, Size: 1}, false},
+		{"directory", "D0755 0 src", Entry{Path: "src", Mode: fs.ModeDir | 0755}, false},
+		{"directory ignores size", "D0755 99 src", Entry{Path: "src", Mode: fs.ModeDir | 0755}, false},
+		{"sticky directory", "D1777 0 tmp", Entry{Path: "tmp", Mode: fs.ModeDir | fs.ModeSticky | 0777}, false},
+		{"missing name", "C0644 12", Entry{}, true},
+		{"empty name", "C0644 12 ", Entry{}, true},
+		{"dot", "D0755 0 .", Entry{}, true},
+		{"dot dot", "D0755 0 ..", Entry{}, true},
+		{"slash", "C0644 1 ../passwd", Entry{}, true},
+		{"absolute", "C0644 1 /etc/passwd", Entry{}, true},
+		{"non-octal mode", "C0899 1 f", Entry{}, true},
+		{"negative size", "C0644 -1 f", Entry{}, true},
+		{"non-numeric size", "C0644 big f", Entry{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseHeader(tt.line)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseHeader(%q) error = %v, wantErr %v", tt.line, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseHeader(%q) = %+v, want %+v", tt.line, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestModeBits(t *testing.T) {
+	tests := []struct {
+		bits uint32
+		mode fs.FileMode
+	}{
+		{0644, 0644},
+		{0755, 0755},
+		{04755, fs.ModeSetuid | 0755},
+		{02755, fs.ModeSetgid | 0755},
+		{01777, fs.ModeSticky | 0777},
+		{07777, fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky | 0777},
+	}
+	for _, tt := range tests {
+		if got := FileMode(tt.bits); got != tt.mode {
+			t.Errorf("FileMode(%#o) = %v, want %v", tt.bits, got, tt.mode)
+		}
+		if got := ModeBits(tt.mode); got != tt.bits {
+			t.Errorf("ModeBits(%v) = %#o, want %#o", tt.mode, got, tt.bits)
+		}
+	}
+
+	// The type bits of a mode are not permissions
+	if got := ModeBits(fs.ModeDir | 0755); got != 0755 {
+		t.Errorf("ModeBits(dir) = %#o, want 0755", got)
+	}
+}
+
+// received is an entry collected by a test Handler, with its contents
+type received struct {
+	Entry
+	Contents string
+}
+
+func TestReceive(t *testing.T) {
+	modTime := time.Unix(1700000000, 0)
+	accessTime := time.Unix(1700000100, 5000)
+
+	tests := []struct {
+		name    string
+		remote  string
+		want    []received
+		wantErr string
+	}{
+		{
+			name: "nothing",
+		},
+		{
+			name:   "file",
+			remote: "C0644 5 hello.txt\nhello\x00",
+			want:   []received{{Entry: Entry{Path: "hello.txt", Mode: 0644, Size: 5}, Contents: "hello"}},
+		},
+		{
+			name:   "empty file",
+			remote: "C0600 0 empty\n\x00",
+			want:   []received{{Entry: Entry{Path: "empty", Mode: 0600}}},
+		},
+		{
+			name:   "contents with newlines",
+			remote: "C0644 6 lines\na\nb\nc\n\x00",
+			want:   []received{{Entry: Entry{Path: "lines", Mode: 0644, Size: 6}, Contents: "a\nb\nc\n"}},
+		},
+		{
+			name:   "times",
+			remote: "T1700000000 0 1700000100 5\nC0644 1 f\nx\x00C0644 1 g\ny\x00",
+			want: []received{
+				{Entry: Entry{Path: "f", Mode: 0644, Size: 1, ModTime: modTime, AccessTime: accessTime}, Contents: "x"},
+				{Entry: Entry{Path: "g", Mode: 0644, Size: 1}, Contents: "y"},
+			},
+		},
+		{
+			name:   "directories",
+			remote: "D0755 0 src\nC0644 2 a.go\nab\x00D0700 0 sub\nC0600 1 b.go\nb\x00E\nC0644 1 c.go\nc\x00E\nC0644 1 top\nt\x00",
+			want: []received{
+				{Entry: Entry{Path: "src", Mode: fs.ModeDir | 0755}},
+				{Entry: Entry{Path: "src/a.go", Mode: 0644, Size: 2}, Contents: "ab"},
+				{Entry: Entry{Path: "src/sub", Mode: fs.ModeDir | 0700}},
+				{Entry: Entry{Path: "src/sub/b.go", Mode: 0600, Size: 1}, Contents: "b"},
+				{Entry: Entry{Path: "src/c.go", Mode: 0644, Size: 1}, Contents: "c"},
+				{Entry: Entry{Path: "top", Mode: 0644, Size: 1}, Contents: "t"},
+			},
+		},
+		{
+			name:    "skipped file",
+			remote:  "\x01scp: secret: Permission denied\nC0644 1 f\nx\x00",
+			want:    []received{{Entry: Entry{Path: "f", Mode: 0644, Size: 1}, Contents: "x"}},
+			wantErr: "scp: secret: Permission denied",
+		},
+		{
+			name:    "fatal error",
+			remote:  "\x02scp: missing: No such file or directory\n",
+			wantErr: "scp: missing: No such file or directory",
+		},
+		{
+			name:    "empty record",
+			remote:  "\n",
+			wantErr: "scp: protocol error: empty record",
+		},
+		{
+			name:    "empty record after a file",
+			remote:  "C0644 1 f\nx\x00\n",
+			want:    []received{{Entry: Entry{Path: "f", Mode: 0644, Size: 1}, Contents: "x"}},
+			wantErr: "scp: protocol error: empty record",
+		},
+		{
+			name:    "unknown record",
+			remote:  "X nonsense\n",
+			wantErr: `scp: unexpected message "X nonsense"`,
+		},
+		{
+			name:    "invalid times",
+			remote:  "Tsoon\n",
+			wantErr: `scp: invalid times "Tsoon"`,
+		},
+		{
+			name:    "unexpected end of directory",
+			remote:  "E\n",
+			wantErr: "scp: unexpected end of directory",
+		},
+		{
+			name:    "closed inside a directory",
+			remote:  "D0755 0 src\nD0755 0 sub\n",
+			want:    []received{{Entry: Entry{Path: "src", Mode: fs.ModeDir | 0755}}, {Entry: Entry{Path: "src/sub", Mode: fs.ModeDir | 0755}}},
+			wantErr: "scp: connection closed inside src/sub",
+		},
+		{
+			name:    "unsafe name",
+			remote:  "C0644 1 ../f\nx\x00",
+			wantErr: `scp: refusing unsafe name "../f"`,
+		},
+		{
+			name:    "truncated contents",
+			remote:  "C0644 10 f\nshort",
+			want:    []received{{Entry: Entry{Path: "f", Mode: 0644, Size: 10}, Contents: "short"}},
+			wantErr: "scp: failed to read f after 5 of 10 bytes: unexpected EOF",
+		},
+		{
+			name:    "truncated record",
+			remote:  "C0644 1",
+			wantErr: "scp: EOF",
+		},
+		{
+			name:    "error after contents",
+			remote:  "C0644 1 f\nx\x01scp: f: read error\n",
+			want:    []received{{Entry: Entry{Path: "f", Mode: 0644, Size: 1}, Contents: "x"}},
+			wantErr: "scp: f: read error",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []received
+			handler := func(entry Entry) (io.Writer, error) {
+				got = append(got, received{Entry: entry})
+				if entry.IsDir() {
+					return nil, nil
+				}
+				return writerFunc(func(p []byte) (int, error) {
+					got[len(got)-1].Contents += string(p)
+					return len(p), nil
+				}), nil
+			}
+
+			var acks bytes.Buffer
+			err := Receive(strings.NewReader(tt.remote), &acks, handler, Options{})
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("Receive() error = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || err.Error() != tt.wantErr) {
+				t.Fatalf("Receive() error = %v, want %q", err, tt.wantErr)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("received %+v, want %+v", got, tt.want)
+			}
+			for i := range got {
+				if !sameEntry(got[i].Entry, tt.want[i].Entry) || got[i].Contents != tt.want[i].Contents {
+					t.Errorf("entry %d = %+v, want %+v", i, got[i], tt.want[i])
+				}
+			}
+			if strings.Trim(acks.String(), "\x00") != "" {
+				t.Errorf("sink wrote %q, want only acknowledgements", acks.String())
+			}
+		})
+	}
+}
+
+func TestReceiveHandlerError(t *testing.T) {
+	handlerErr := errors.New("disk full")
+	err := Receive(strings.NewReader("C0644 1 f\nx\x00"), io.Discard, func(Entry) (io.Writer, error) {
+		return nil, handlerErr
+	}, Options{})
+	if !errors.Is(err, handlerErr) {
+		t.Errorf("Receive() error = %v, want %v", err, handlerErr)
+	}
+}
+
+func TestReceiveDiscard(t *testing.T) {
+	err := Receive(strings.NewReader("C0644 3 f\nabc\x00C0644 1 g\nx\x00"), io.Discard, func(Entry) (io.Writer, error) {
+		return nil, nil
+	}, Options{})
+	if err != nil {
+		t.Errorf("Receive() error = %v", err)
+	}
+}
+
+func TestSend(t *testing.T) {
+	modTime := time.Unix(1700000000, 0)
+	file := func(p, contents string) Entry {
+		return Entry{Path: p, Mode: 0644, Size: int64(len(contents)), Reader: strings.NewReader(contents)}
+	}
+
+	tests := []struct {
+		name     string
+		entries  []Entry
+		options  Options
+		want     string
+		response string
+		wantErr  string
+	}{
+		{
+			name: "nothing",
+		},
+		{
+			name:    "file",
+			entries: []Entry{file("hello.txt", "hello")},
+			want:    "C0644 5 hello.txt\nhello\x00",
+		},
+		{
+			name:    "setuid file",
+			entries: []Entry{{Path: "tool", Mode: fs.ModeSetuid | 0755, Size: 1, Reader: strings.NewReader("x")}},
+			want:    "C4755 1 tool\nx\x00",
+		},
+		{
+			name:    "missing parents",
+			entries: []Entry{file("a/b/c.txt", "c"), file("a/d.txt", "d"), file("e.txt", "e")},
+			want:    "D0755 0 a\nD0755 0 b\nC0644 1 c.txt\nc\x00E\nC0644 1 d.txt\nd\x00E\nC0644 1 e.txt\ne\x00",
+		},
+		{
+			name:    "listed directory",
+			entries: []Entry{{Path: "a", Mode: fs.ModeDir | 0700}, file("a/f", "f")},
+			want:    "D0700 0 a\nC0644 1 f\nf\x00E\n",
+		},
+		{
+			name:    "preserve times",
+			entries: []Entry{{Path: "d", Mode: fs.ModeDir | 0755, ModTime: modTime}, {Path: "d/f", Mode: 0644, Size: 1, ModTime: modTime, Reader: strings.NewReader("x")}},
+			options: Options{Preserve: true},
+			want:    "T1700000000 0 1700000000 0\nD0755 0 d\nT1700000000 0 1700000000 0\nC0644 1 f\nx\x00E\n",
+		},
+		{
+			name:    "times without preserve",
+			entries: []Entry{{Path: "f", Mode: 0644, Size: 1, ModTime: modTime, Reader: strings.NewReader("x")}},
+			want:    "C0644 1 f\nx\x00",
+		},
+		{
+			name:    "climbing path",
+			entries: []Entry{file("../f", "x")},
+			wantErr: `scp: invalid path "../f"`,
+		},
+		{
+			name:    "newline in path",
+			entries: []Entry{file("a\nb", "x")},
+			wantErr: `scp: invalid path "a\nb"`,
+		},
+		{
+			name:    "no contents",
+			entries: []Entry{{Path: "f", Mode: 0644, Size: 1}},
+			wantErr: "scp: no contents for f",
+		},
+		{
+			name:    "short contents",
+			entries: []Entry{{Path: "f", Mode: 0644, Size: 4, Reader: strings.NewReader("ab")}},
+			want:    "C0644 4 f\nab",
+			wantErr: "scp: failed to read f after 2 of 4 bytes: unexpected EOF",
+		},
+		{
+			name:     "remote refuses",
+			entries:  []Entry{file("f", "x")},
+			response: "\x00\x01scp: f: Permission denied\n",
+			want:     "C0644 1 f\n",
+			wantErr:  "scp: f: Permission denied",
+		},
+		{
+			name:     "remote closes",
+			entries:  []Entry{file("f", "x")},
+			response: "\x00",
+			want:     "C0644 1 f\n",
+			wantErr:  "scp: EOF",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response := tt.response
+			if response == "" {
+				response = strings.Repeat("\x00", 64)
+			}
+
+			var sent bytes.Buffer
+			err := Send(strings.NewReader(response), &sent, tt.entries, tt.options)
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("Send() error = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || err.Error() != tt.wantErr) {
+				t.Fatalf("Send() error = %v, want %q", err, tt.wantErr)
+			}
+			if sent.String() != tt.want {
+				t.Errorf("sent %q, want %q", sent.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestSendReceive(t *testing.T) {
+	entries := []Entry{
+		{Path: "docs", Mode: fs.ModeDir | 0750},
+		{Path: "docs/readme", Mode: 0640, Size: 6, Reader: strings.NewReader("readme")},
+		{Path: "docs/big", Mode: 0600, Size: 3 * chunkSize, Reader: bytes.NewReader(bytes.Repeat([]byte("z"), 3*chunkSize))},
+		{Path: "bin/tool", Mode: fs.ModeSetuid | 0755, Size: 4, Reader: strings.NewReader("tool")},
+	}
+
+	// Send's output is exactly what a remote "scp -f" would print
+	var stream bytes.Buffer
+	if err := Send(strings.NewReader(strings.Repeat("\x00", 64)), &stream, entries, Options{}); err != nil {
+		t.Fatal(err)
+	}
+
+	var progress []int64
+	var got []received
+	err := Receive(&stream, io.Discard, func(entry Entry) (io.Writer, error) {
+		got = append(got, received{Entry: entry})
+		return writerFunc(func(p []byte) (int, error) {
+			got[len(got)-1].Contents += string(p)
+			return len(p), nil
+		}), nil
+	}, Options{OnProgress: func(p string, transferred, total int64) {
+		if p == "docs/big" {
+			progress = append(progress, transferred)
+		}
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []received{
+		{Entry: Entry{Path: "docs", Mode: fs.ModeDir | 0750}},
+		{Entry: Entry{Path: "docs/readme", Mode: 0640, Size: 6}, Contents: "readme"},
+		{Entry: Entry{Path: "docs/big", Mode: 0600, Size: 3 * chunkSize}, Contents: strings.Repeat("z", 3*chunkSize)},
+		{Entry: Entry{Path: "bin", Mode: fs.ModeDir | 0755}},
+		{Entry: Entry{Path: "bin/tool", Mode: fs.ModeSetuid | 0755, Size: 4}, Contents: "tool"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("received %d entries, want %d", len(got), len(want))
+	}
+	for i := range got {
+		if !sameEntry(got[i].Entry, want[i].Entry) || got[i].Contents != want[i].Contents {
+			t.Errorf("entry %d = %v %v (%d bytes), want %v %v", i, got[i].Path, got[i].Mode, len(got[i].Contents), want[i].Path, want[i].Mode)
+		}
+	}
+
+	wantProgress := []int64{0, chunkSize, 2 * chunkSize, 3 * chunkSize}
+	if len(progress) != len(wantProgress) {
+		t.Fatalf("progress %v, want %v", progress, wantProgress)
+	}
+	for i := range progress {
+		if progress[i] != wantProgress[i] {
+			t.Fatalf("progress %v, want %v", progress, wantProgress)
+		}
+	}
+}
+
+// sameEntry compares the metadata of two entries, ignoring their readers
+func sameEntry(a, b Entry) bool {
+	return a.Path == b.Path && a.Mode == b.Mode && a.Size == b.Size &&
+		a.ModTime.Equal(b.ModTime) && a.AccessTime.Equal(b.AccessTime)
+}
+
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
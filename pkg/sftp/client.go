@@ -0,0 +1,546 @@
+// Package sftp is an SFTP version 3 client that runs over the sftp subsystem
+// of an SSH connection.
+package sftp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const protocolVersion = 3
+
+// maxPacket bounds incoming packets; servers must accept 32 KiB of data per request
+const maxPacket = 256 * 1024
+
+// response is a reply to one request, without its request ID
+type response struct {
+	typ  byte
+	data []byte
+}
+
+// Client is an SFTP session. Requests may be issued concurrently.
+type Client struct {
+	session    *ssh.Session
+	w          io.WriteCloser
+	r          io.Reader
+	extensions map[string]string
+	nextID     uint32
+	pending    map[uint32]chan response
+	err        error
+	done       chan struct{}
+	mu         sync.Mutex
+	writeMu    sync.Mutex
+}
+
+// NewClient opens the sftp subsystem on conn
+func NewClient(conn *ssh.Client) (*Client, error) {
+	session, err := conn.NewSession()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create session: %v", err)
+	}
+
+	w, err := session.StdinPipe()
+	if err != nil {
+		session.Close()
+		return nil, fmt.Errorf("failed to create stdin pipe: %v", err)
+	}
+	r, err := session.StdoutPipe()
+	if err != nil {
+		session.Close()
+		return nil, fmt.Errorf("failed to create stdout pipe: %v", err)
+	}
+
+	if err := session.RequestSubsystem("sftp"); err != nil {
+		session.Close()
+		return nil, fmt.Errorf("failed to start sftp subsystem: %v", err)
+	}
+
+	client, err := NewClientPipe(r, w)
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+	client.session = session
+	return client, nil
+}
+
+// NewClientPipe runs the protocol over an existing stream, such as the
+// stdio of a subsystem started elsewhere
+func NewClientPipe(r io.Reader, w io.WriteCloser) (*Client, error) {
+	c := &Client{
+		w:       w,
+		r:       r,
+		pending: make(map[uint32]chan response),
+		done:    make(chan struct{}),
+	}
+
+	if err := c.init(); err != nil {
+		w.Close()
+		return nil, err
+	}
+
+	go c.recv()
+	return c, nil
+}
+
+// init exchanges SSH_FXP_INIT and SSH_FXP_VERSION before requests start
+func (c *Client) init() error {
+	var b buffer
+	b.byte(fxpInit)
+	b.uint32(protocolVersion)
+	if err := c.writePacket(b); err != nil {
+		return fmt.Errorf("failed to send sftp init: %v", err)
+	}
+
+	typ, data, err := c.readPacket()
+	if err != nil {
+		return fmt.Errorf("failed to read sftp version: %v", err)
+	}
+	if typ != fxpVersion {
+		return fmt.Errorf("sftp: expected version packet, got type %d", typ)
+	}
+
+	r := reader{data: data}
+	if version := r.uint32(); r.err != nil || version != protocolVersion {
+		return fmt.Errorf("sftp: unsupported protocol version %d", version)
+	}
+
+	c.extensions = make(map[string]string)
+	for len(r.data) > 0 && r.err == nil {
+		name := r.string()
+		c.extensions[name] = r.string()
+	}
+	return nil
+}
+
+// HasExtension reports whether the server announced an extension such as
+// posix-rename@openssh.com, along with its version data
+func (c *Client) HasExtension(name string) (string, bool) {
+	data, ok := c.extensions[name]
+	return data, ok
+}
+
+func (c *Client) writePacket(payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	packet := make([]byte, 4, 4+len(payload))
+	binary.BigEndian.PutUint32(packet, uint32(len(payload)))
+	packet = append(packet, payload...)
+	_, err := c.w.Write(packet)
+	return err
+}
+
+func (c *Client) readPacket() (byte, []byte, error) {
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(c.r, header); err != nil {
+		return 0, nil, err
+	}
+	length := binary.BigEndian.Uint32(header)
+	if length < 1 || length > maxPacket {
+		return 0, nil, fmt.Errorf("sftp: invalid packet length %d", length)
+	}
+	data := make([]byte, length-1)
+	if _, err := io.ReadFull(c.r, data); err != nil {
+		return 0, nil, err
+	}
+	return header[4], data, nil
+}
+
+// recv routes responses to the requests waiting for them until the stream ends
+func (c *Client) recv() {
+	for {
+		typ, data, err := c.readPacket()
+		if err == nil && len(data) < 4 {
+			err = errShortPacket
+		}
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				err = errors.New("sftp: connection closed")
+			}
+			c.fail(err)
+			return
+		}
+
+		id := binary.BigEndian.Uint32(data)
+		c.mu.Lock()
+		ch, ok := c.pending[id]
+		delete(c.pending, id)
+		c.mu.Unlock()
+
+		if ok {
+			ch <- response{typ: typ, data: data[4:]}
+		}
+	}
+}
+
+// fail ends all outstanding requests with err
+func (c *Client) fail(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err != nil {
+		return
+	}
+	c.err = err
+	for id, ch := range c.pending {
+		close(ch)
+		delete(c.pending, id)
+	}
+	close(c.done)
+}
+
+// send issues a request and returns the channel its response arrives on.
+// The channel is closed without a value if the connection fails first.
+func (c *Client) send(typ byte, build func(b *buffer)) (<-chan response, error) {
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return nil, c.err
+	}
+	c.nextID++
+	id := c.nextID
+	ch := make(chan response, 1)
+	c.pending[id] = ch
+	c.mu.Unlock()
+
+	var b buffer
+	b.byte(typ)
+	b.uint32(id)
+	if build != nil {
+		build(&b)
+	}
+
+	if err := c.writePacket(b); err != nil {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+		return nil, err
+	}
+	return ch, nil
+}
+
+// wait returns the response delivered on ch
+func (c *Client) wait(ch <-chan response) (response, error) {
+	resp, ok := <-ch
+	if !ok {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return response{}, c.err
+	}
+	return resp, nil
+}
+
+func (c *Client) request(typ byte, build func(b *buffer)) (response, error) {
+	ch, err := c.send(typ, build)
+	if err != nil {
+		return response{}, err
+	}
+	return c.wait(ch)
+}
+
+// Done is closed once the session has ended
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Close ends the SFTP session
+func (c *Client) Close() error {
+	err := c.w.Close()
+	if c.session != nil {
+		c.session.Close()
+	}
+	c.fail(errors.New("sftp: client closed"))
+	return err
+}
+
+// statusError turns an SSH_FXP_STATUS reply into an error, nil for OK
+func statusError(resp response) error {
+	r := reader{data: resp.data}
+	code := r.uint32()
+	if r.err != nil {
+		return r.err
+	}
+	// Some servers leave out the message and language tag
+	message := r.string()
+	if code == StatusOK {
+		return nil
+	}
+	if code == StatusEOF {
+		return io.EOF
+	}
+	return &StatusError{Code: code, Message: message}
+}
+
+// unexpected reports a reply of the wrong type, or the status it carries
+func unexpected(resp response, want byte) error {
+	if resp.typ == fxpStatus {
+		if err := statusError(resp); err != nil {
+			return err
+		}
+	}
+	return fmt.Errorf("sftp: expected packet type %d, got %d", want, resp.typ)
+}
+
+func expectStatus(resp response) error {
+	if resp.typ != fxpStatus {
+		return fmt.Errorf("sftp: expected status packet, got type %d", resp.typ)
+	}
+	return statusError(resp)
+}
+
+func expectHandle(resp response) (string, error) {
+	if resp.typ != fxpHandle {
+		return "", unexpected(resp, fxpHandle)
+	}
+	r := reader{data: resp.data}
+	handle := r.string()
+	return handle, r.err
+}
+
+func expectAttrs(resp response) (Attributes, error) {
+	if resp.typ != fxpAttrs {
+		return Attributes{}, unexpected(resp, fxpAttrs)
+	}
+	r := reader{data: resp.data}
+	attrs := r.attrs()
+	return attrs, r.err
+}
+
+func expectNames(resp response) ([]*FileInfo, error) {
+	if resp.typ != fxpName {
+		return nil, unexpected(resp, fxpName)
+	}
+	r := reader{data: resp.data}
+	count := r.uint32()
+	names := make([]*FileInfo, 0, count)
+	for i := uint32(0); i < count && r.err == nil; i++ {
+		fi := &FileInfo{name: r.string(), longName: r.string()}
+		fi.attrs = r.attrs()
+		names = append(names, fi)
+	}
+	return names, r.err
+}
+
+// pathRequest sends a request whose only argument is a path
+func (c *Client) pathRequest(typ byte, p string) (response, error) {
+	return c.request(typ, func(b *buffer) {
+		b.string(p)
+	})
+}
+
+func pathError(op, p string, err error) error {
+	return &fs.PathError{Op: op, Path: p, Err: err}
+}
+
+// ReadDir lists a directory, without the . and .. entries
+func (c *Client) ReadDir(p string) ([]*FileInfo, error) {
+	resp, err := c.pathRequest(fxpOpendir, p)
+	if err != nil {
+		return nil, pathError("readdir", p, err)
+	}
+	handle, err := expectHandle(resp)
+	if err != nil {
+		return nil, pathError("readdir", p, err)
+	}
+	defer c.closeHandle(handle)
+
+	var entries []*FileInfo
+	for {
+		resp, err := c.request(fxpReaddir, func(b *buffer) {
+			b.string(handle)
+		})
+		if err != nil {
+			return nil, pathError("readdir", p, err)
+		}
+		names, err := expectNames(resp)
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, pathError("readdir", p, err)
+		}
+		for _, name := range names {
+			if name.name != "." && name.name != ".." {
+				entries = append(entries, name)
+			}
+		}
+	}
+}
+
+// Stat returns the attributes of a file, following symlinks
+func (c *Client) Stat(p string) (*FileInfo, error) {
+	return c.stat(fxpStat, "stat", p)
+}
+
+// Lstat returns the attributes of a file without following symlinks
+func (c *Client) Lstat(p string) (*FileInfo, error) {
+	return c.stat(fxpLstat, "lstat", p)
+}
+
+func (c *Client) stat(typ byte, op, p string) (*FileInfo, error) {
+	resp, err := c.pathRequest(typ, p)
+	if err != nil {
+		return nil, pathError(op, p, err)
+	}
+	attrs, err := expectAttrs(resp)
+	if err != nil {
+		return nil, pathError(op, p, err)
+	}
+	return &FileInfo{name: path.Base(p), attrs: attrs}, nil
+}
+
+// Mkdir creates a directory
+func (c *Client) Mkdir(p string) error {
+	resp, err := c.request(fxpMkdir, func(b *buffer) {
+		b.string(p)
+		b.attrs(Attributes{})
+	})
+	if err == nil {
+		err = expectStatus(resp)
+	}
+	if err != nil {
+		return pathError("mkdir", p, err)
+	}
+	return nil
+}
+
+// Remove removes a file
+func (c *Client) Remove(p string) error {
+	return c.simple(fxpRemove, "remove", p)
+}
+
+// RemoveDirectory removes an empty directory
+func (c *Client) RemoveDirectory(p string) error {
+	return c.simple(fxpRmdir, "rmdir", p)
+}
+
+func (c *Client) simple(typ byte, op, p string) error {
+	resp, err := c.pathRequest(typ, p)
+	if err == nil {
+		err = expectStatus(resp)
+	}
+	if err != nil {
+		return pathError(op, p, err)
+	}
+	return nil
+}
+
+// Rename renames a file. SFTP v3 servers usually refuse to replace an
+// existing target; PosixRename does that where supported.
+func (c *Client) Rename(oldPath, newPath string) error {
+	resp, err := c.request(fxpRename, func(b *buffer) {
+		b.string(oldPath)
+		b.string(newPath)
+	})
+	if err == nil {
+		err = expectStatus(resp)
+	}
+	if err != nil {
+		return &os.LinkError{Op: "rename", Old: oldPath, New: newPath, Err: err}
+	}
+	return nil
+}
+
+// PosixRename renames a file, replacing the target, with the
+// posix-rename@openssh.com extension
+func (c *Client) PosixRename(oldPath, newPath string) error {
+	if _, ok := c.HasExtension("posix-rename@openssh.com"); !ok {
+		return &os.LinkError{Op: "rename", Old: oldPath, New: newPath, Err: errors.New("sftp: server does not support posix-rename")}
+	}
+	resp, err := c.request(fxpExtended, func(b *buffer) {
+		b.string("posix-rename@openssh.com")
+		b.string(oldPath)
+		b.string(newPath)
+	})
+	if err == nil {
+		err = expectStatus(resp)
+	}
+	if err != nil {
+		return &os.LinkError{Op: "rename", Old: oldPath, New: newPath, Err: err}
+	}
+	return nil
+}
+
+// Symlink creates linkPath pointing at target. The arguments are sent in
+// the order OpenSSH expects, which is the reverse of the draft.
+func (c *Client) Symlink(target, linkPath string) error {
+	resp, err := c.request(fxpSymlink, func(b *buffer) {
+		b.string(target)
+		b.string(linkPath)
+	})
+	if err == nil {
+		err = expectStatus(resp)
+	}
+	if err != nil {
+		return &os.LinkError{Op: "symlink", Old: target, New: linkPath, Err: err}
+	}
+	return nil
+}
+
+// ReadLink returns the target of a symlink
+func (c *Client) ReadLink(p string) (string, error) {
+	return c.resolve(fxpReadlink, "readlink", p)
+}
+
+// RealPath canonicalizes a path; "." resolves to the login directory
+func (c *Client) RealPath(p string) (string, error) {
+	return c.resolve(fxpRealpath, "realpath", p)
+}
+
+func (c *Client) resolve(typ byte, op, p string) (string, error) {
+	resp, err := c.pathRequest(typ, p)
+	if err != nil {
+		return "", pathError(op, p, err)
+	}
+	names, err := expectNames(resp)
+	if err != nil {
+		return "", pathError(op, p, err)
+	}
+	if len(names) != 1 {
+		return "", pathError(op, p, fmt.Errorf("sftp: expected one name, got %d", len(names)))
+	}
+	return names[0].name, nil
+}
+
+// Chmod changes the permission bits of a file
+func (c *Client) Chmod(p string, mode fs.FileMode) error {
+	return c.setstat("chmod", p, Attributes{Flags: attrPermissions, Permissions: Permissions(mode)})
+}
+
+// Truncate changes the size of a file
+func (c *Client) Truncate(p string, size int64) error {
+	return c.setstat("truncate", p, Attributes{Flags: attrSize, Size: uint64(size)})
+}
+
+func (c *Client) setstat(op, p string, attrs Attributes) error {
+	resp, err := c.request(fxpSetstat, func(b *buffer) {
+		b.string(p)
+		b.attrs(attrs)
+	})
+	if err == nil {
+		err = expectStatus(resp)
+	}
+	if err != nil {
+		return pathError(op, p, err)
+	}
+	return nil
+}
+
+func (c *Client) closeHandle(handle string) error {
+	resp, err := c.request(fxpClose, func(b *buffer) {
+		b.string(handle)
+	})
+	if err != nil {
+		return err
+	}
+	return expectStatus(resp)
+}
@@ -0,0 +1,237 @@
+package sftp
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"testing"
+
+	"github.com/andrew/sshclient-wasm/internal/sftptest"
+)
+
+func newTestClient(t *testing.T, server *sftptest.Server, options sftptest.Options) *Client {
+	t.Helper()
+	r, w := server.Pipe(options)
+	client, err := NewClientPipe(r, w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestClientFiles(t *testing.T) {
+	server := sftptest.NewServer()
+	c := newTestClient(t, server, sftptest.Options{})
+
+	if err := c.Mkdir("/dir"); err != nil {
+		t.Fatal(err)
+	}
+	f, err := c.Create("/dir/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if server.OpenHandles() != 1 {
+		t.Errorf("open handles = %d, want 1", server.OpenHandles())
+	}
+	if _, err := f.Write([]byte("hello, world")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Seek(7, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 16)
+	n, err := f.Read(buf)
+	if err != nil || string(buf[:n]) != "world" {
+		t.Errorf("read %q, %v", buf[:n], err)
+	}
+	if _, err := f.Read(buf); err != io.EOF {
+		t.Errorf("read at end: err = %v, want io.EOF", err)
+	}
+	if pos, err := f.Seek(-5, io.SeekEnd); err != nil || pos != 7 {
+		t.Errorf("seek from end = %d, %v", pos, err)
+	}
+	if info, err := f.Stat(); err != nil || info.Size() != 12 || !info.Mode().IsRegular() {
+		t.Errorf("fstat = %+v, %v", info, err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err == nil {
+		t.Error("closing a closed handle succeeded")
+	}
+	if server.OpenHandles() != 0 {
+		t.Errorf("open handles after close = %d", server.OpenHandles())
+	}
+
+	f, err = c.OpenFile("/dir/a.txt", os.O_WRONLY|os.O_APPEND)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt([]byte("!"), 0); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if data, _ := server.ReadFile("/dir/a.txt"); string(data) != "hello, world!" {
+		t.Errorf("after append: %q", data)
+	}
+	if _, err := c.OpenFile("/dir/a.txt", os.O_WRONLY|os.O_CREATE|os.O_EXCL); err == nil {
+		t.Error("exclusive create of an existing file succeeded")
+	}
+
+	if err := c.Chmod("/dir/a.txt", fs.ModeSetuid|0750); err != nil {
+		t.Fatal(err)
+	}
+	if mode, _ := server.Mode("/dir/a.txt"); mode != 0104750 {
+		t.Errorf("mode after chmod = %o", mode)
+	}
+	if err := c.Truncate("/dir/a.txt", 5); err != nil {
+		t.Fatal(err)
+	}
+	info, err := c.Stat("/dir/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Name() != "a.txt" || info.Size() != 5 || info.Mode() != fs.ModeSetuid|0750 {
+		t.Errorf("stat = %s %d %v", info.Name(), info.Size(), info.Mode())
+	}
+
+	if err := c.Symlink("a.txt", "/dir/link"); err != nil {
+		t.Fatal(err)
+	}
+	if target, err := c.ReadLink("/dir/link"); err != nil || target != "a.txt" {
+		t.Errorf("readlink = %q, %v", target, err)
+	}
+	if info, err := c.Lstat("/dir/link"); err != nil || info.Mode()&fs.ModeSymlink == 0 {
+		t.Errorf("lstat = %+v, %v", info, err)
+	}
+	if info, err := c.Stat("/dir/link"); err != nil || info.Size() != 5 {
+		t.Errorf("stat through link = %+v, %v", info, err)
+	}
+
+	if err := c.Mkdir("/dir/sub"); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := c.ReadDir("/dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	if len(names) != 3 || names[0] != "a.txt" || names[1] != "link" || names[2] != "sub" {
+		t.Errorf("readdir = %v, want a.txt, link and sub without . and ..", names)
+	}
+	if !entries[2].IsDir() {
+		t.Errorf("sub has mode %v", entries[2].Mode())
+	}
+	if server.OpenHandles() != 0 {
+		t.Errorf("readdir left %d handles open", server.OpenHandles())
+	}
+
+	if err := c.Rename("/dir/a.txt", "/dir/b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	server.WriteFile("/dir/c.txt", []byte("c"))
+	if err := c.Rename("/dir/b.txt", "/dir/c.txt"); err == nil {
+		t.Error("rename onto an existing file succeeded")
+	}
+	if err := c.PosixRename("/dir/b.txt", "/dir/c.txt"); err == nil {
+		t.Error("posix-rename succeeded without the extension")
+	}
+
+	if err := c.RemoveDirectory("/dir"); err == nil {
+		t.Error("removed a directory that is not empty")
+	}
+	for _, p := range []string{"/dir/b.txt", "/dir/c.txt", "/dir/link"} {
+		if err := c.Remove(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.RemoveDirectory("/dir/sub"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.RemoveDirectory("/dir"); err != nil {
+		t.Fatal(err)
+	}
+
+	if p, err := c.RealPath("."); err != nil || p != "/" {
+		t.Errorf("realpath = %q, %v", p, err)
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	c := newTestClient(t, sftptest.NewServer(), sftptest.Options{})
+
+	_, err := c.Stat("/missing")
+	var pathErr *fs.PathError
+	if !errors.As(err, &pathErr) || pathErr.Op != "stat" || pathErr.Path != "/missing" {
+		t.Errorf("stat error = %v, want a PathError", err)
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("stat error %v is not fs.ErrNotExist", err)
+	}
+	if _, err := c.Open("/missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("open error %v is not fs.ErrNotExist", err)
+	}
+	if err := c.Remove("/missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("remove error %v is not fs.ErrNotExist", err)
+	}
+	var linkErr *os.LinkError
+	if err := c.Rename("/missing", "/other"); !errors.As(err, &linkErr) || !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("rename error = %v", err)
+	}
+	if _, err := c.ReadDir("/missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("readdir error %v is not fs.ErrNotExist", err)
+	}
+	if _, err := c.CheckFile("/missing", "sha256"); err == nil {
+		t.Error("check-file succeeded without the extension")
+	}
+}
+
+func TestClientPosixRename(t *testing.T) {
+	server := sftptest.NewServer()
+	server.WriteFile("/a", []byte("a"))
+	server.WriteFile("/b", []byte("b"))
+	c := newTestClient(t, server, sftptest.Options{PosixRename: true})
+
+	if _, ok := c.HasExtension("posix-rename@openssh.com"); !ok {
+		t.Fatal("extension not announced")
+	}
+	if err := c.PosixRename("/a", "/b"); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := server.ReadFile("/b"); string(data) != "a" {
+		t.Errorf("/b = %q after replacing it", data)
+	}
+	if _, ok := server.ReadFile("/a"); ok {
+		t.Error("/a still exists")
+	}
+}
+
+func TestClientConnectionLost(t *testing.T) {
+	server := sftptest.NewServer()
+	server.WriteFile("/big", make([]byte, 100000))
+	c := newTestClient(t, server, sftptest.Options{CloseAfter: 1000})
+
+	f, err := c.Open("/big")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.ReadAt(make([]byte, 5000), 0); err == nil {
+		t.Fatal("read past the cut succeeded")
+	}
+	select {
+	case <-c.Done():
+	default:
+		t.Error("Done not closed after the connection was lost")
+	}
+	if _, err := c.Stat("/big"); err == nil {
+		t.Error("request after the connection was lost succeeded")
+	}
+	if server.OpenHandles() != 0 {
+		t.Errorf("%d handles survived the session", server.OpenHandles())
+	}
+}
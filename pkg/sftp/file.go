@@ -0,0 +1,240 @@
+package sftp
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"sync"
+)
+
+// chunkSize is the largest read or write request; every server accepts 32 KiB
+const chunkSize = 32 * 1024
+
+// ProgressFunc reports how many bytes of a transfer are done. Total is -1
+// when the size is unknown.
+type ProgressFunc func(transferred, total int64)
+
+// File is an open remote file
+type File struct {
+	client *Client
+	path   string
+	handle string
+	offset int64
+	mu     sync.Mutex
+}
+
+// Open opens a file for reading
+func (c *Client) Open(p string) (*File, error) {
+	return c.OpenFile(p, os.O_RDONLY)
+}
+
+// Create creates or truncates a file for writing
+func (c *Client) Create(p string) (*File, error) {
+	return c.OpenFile(p, os.O_RDWR|os.O_CREATE|os.O_TRUNC)
+}
+
+// OpenFile opens a file with os.OpenFile style flags
+func (c *Client) OpenFile(p string, flag int) (*File, error) {
+	resp, err := c.request(fxpOpen, func(b *buffer) {
+		b.string(p)
+		b.uint32(toFlags(flag))
+		b.attrs(Attributes{})
+	})
+	if err != nil {
+		return nil, pathError("open", p, err)
+	}
+	handle, err := expectHandle(resp)
+	if err != nil {
+		return nil, pathError("open", p, err)
+	}
+	return &File{client: c, path: p, handle: handle}, nil
+}
+
+// Name returns the path the file was opened with
+func (f *File) Name() string {
+	return f.path
+}
+
+// Close closes the remote handle
+func (f *File) Close() error {
+	if err := f.client.closeHandle(f.handle); err != nil {
+		return pathError("close", f.path, err)
+	}
+	return nil
+}
+
+// Stat returns the attributes of the open file
+func (f *File) Stat() (*FileInfo, error) {
+	resp, err := f.client.request(fxpFstat, func(b *buffer) {
+		b.string(f.handle)
+	})
+	if err != nil {
+		return nil, pathError("stat", f.path, err)
+	}
+	attrs, err := expectAttrs(resp)
+	if err != nil {
+		return nil, pathError("stat", f.path, err)
+	}
+	return &FileInfo{name: f.path, attrs: attrs}, nil
+}
+
+// ReadAt reads len(p) bytes at off, implementing io.ReaderAt
+func (f *File) ReadAt(p []byte, off int64) (int, error) {
+	read := 0
+	for read < len(p) {
+		n, err := f.readChunk(p[read:], off+int64(read))
+		read += n
+		if err != nil {
+			return read, err
+		}
+	}
+	return read, nil
+}
+
+// readChunk issues one SSH_FXP_READ, which may return fewer bytes than asked
+func (f *File) readChunk(p []byte, off int64) (int, error) {
+	if len(p) > chunkSize {
+		p = p[:chunkSize]
+	}
+	resp, err := f.client.request(fxpRead, func(b *buffer) {
+		b.string(f.handle)
+		b.uint64(uint64(off))
+		b.uint32(uint32(len(p)))
+	})
+	if err != nil {
+		return 0, pathError("read", f.path, err)
+	}
+	return f.copyData(resp, p)
+}
+
+func (f *File) copyData(resp response, p []byte) (int, error) {
+	data, err := f.readData(resp, len(p))
+	if err != nil {
+		return 0, err
+	}
+	return copy(p, data), nil
+}
+
+// Read reads from the current offset, implementing io.Reader
+func (f *File) Read(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	n, err := f.readChunk(p, f.offset)
+	f.offset += int64(n)
+	return n, err
+}
+
+// WriteAt writes p at off, implementing io.WriterAt
+func (f *File) WriteAt(p []byte, off int64) (int, error) {
+	written := 0
+	for written < len(p) {
+		chunk := p[written:]
+		if len(chunk) > chunkSize {
+			chunk = chunk[:chunkSize]
+		}
+		resp, err := f.client.request(fxpWrite, func(b *buffer) {
+			b.string(f.handle)
+			b.uint64(uint64(off + int64(written)))
+			b.bytes(chunk)
+		})
+		if err == nil {
+			err = expectStatus(resp)
+		}
+		if err != nil {
+			return written, pathError("write", f.path, err)
+		}
+		written += len(chunk)
+	}
+	return written, nil
+}
+
+// Write writes at the current offset, implementing io.Writer
+func (f *File) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	n, err := f.WriteAt(p, f.offset)
+	f.offset += int64(n)
+	return n, err
+}
+
+// Seek sets the offset for the next Read or Write, implementing io.Seeker
+func (f *File) Seek(offset int64, whence int) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		fi, err := f.Stat()
+		if err != nil {
+			return f.offset, err
+		}
+		offset += fi.Size()
+	default:
+		return f.offset, fmt.Errorf("sftp: invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return f.offset, pathError("seek", f.path, fs.ErrInvalid)
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+// Download copies a remote file to w
+func (c *Client) Download(p string, w io.Writer, progress ProgressFunc) (int64, error) {
+	f, err := c.Open(p)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	total := int64(-1)
+	if fi, err := f.Stat(); err == nil && fi.attrs.HasSize() {
+		total = fi.Size()
+	}
+
+	return copyWithProgress(w, f, total, progress)
+}
+
+// Upload creates or truncates a remote file with the contents of r. Size is
+// only used for progress and may be -1.
+func (c *Client) Upload(p string, r io.Reader, size int64, progress ProgressFunc) (int64, error) {
+	f, err := c.Create(p)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := copyWithProgress(f, r, size, progress)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return n, err
+}
+
+func copyWithProgress(dst io.Writer, src io.Reader, total int64, progress ProgressFunc) (int64, error) {
+	buf := make([]byte, chunkSize)
+	var transferred int64
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			if _, werr := dst.Write(buf[:n]); werr != nil {
+				return transferred, werr
+			}
+			transferred += int64(n)
+			if progress != nil {
+				progress(transferred, total)
+			}
+		}
+		if err == io.EOF {
+			return transferred, nil
+		}
+		if err != nil {
+			return transferred, err
+		}
+	}
+}
@@ -0,0 +1,360 @@
+package sftp
+
+import (
+	"encoding/binary"
+	"errors"
+	"io/fs"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Packet types (draft-ietf-secsh-filexfer-02)
+const (
+	fxpInit          = 1
+	fxpVersion       = 2
+	fxpOpen          = 3
+	fxpClose         = 4
+	fxpRead          = 5
+	fxpWrite         = 6
+	fxpLstat         = 7
+	fxpFstat         = 8
+	fxpSetstat       = 9
+	fxpFsetstat      = 10
+	fxpOpendir       = 11
+	fxpReaddir       = 12
+	fxpRemove        = 13
+	fxpMkdir         = 14
+	fxpRmdir         = 15
+	fxpRealpath      = 16
+	fxpStat          = 17
+	fxpRename        = 18
+	fxpReadlink      = 19
+	fxpSymlink       = 20
+	fxpStatus        = 101
+	fxpHandle        = 102
+	fxpData          = 103
+	fxpName          = 104
+	fxpAttrs         = 105
+	fxpExtended      = 200
+	fxpExtendedReply = 201
+)
+
+// Open flags
+const (
+	fxfRead   = 0x01
+	fxfWrite  = 0x02
+	fxfAppend = 0x04
+	fxfCreat  = 0x08
+	fxfTrunc  = 0x10
+	fxfExcl   = 0x20
+)
+
+// Attribute flags
+const (
+	attrSize        = 0x00000001
+	attrUIDGID      = 0x00000002
+	attrPermissions = 0x00000004
+	attrACModTime   = 0x00000008
+	attrExtended    = 0x80000000
+)
+
+// Status codes
+const (
+	StatusOK               = 0
+	StatusEOF              = 1
+	StatusNoSuchFile       = 2
+	StatusPermissionDenied = 3
+	StatusFailure          = 4
+	StatusBadMessage       = 5
+	StatusNoConnection     = 6
+	StatusConnectionLost   = 7
+	StatusOpUnsupported    = 8
+)
+
+// POSIX file type bits carried in the permissions attribute
+const (
+	modeType    = 0170000
+	modeFIFO    = 0010000
+	modeChar    = 0020000
+	modeDir     = 0040000
+	modeBlock   = 0060000
+	modeRegular = 0100000
+	modeSymlink = 0120000
+	modeSocket  = 0140000
+	modeSetuid  = 0004000
+	modeSetgid  = 0002000
+	modeSticky  = 0001000
+)
+
+var errShortPacket = errors.New("sftp: packet too short")
+
+// StatusError is a failure reported by the server in an SSH_FXP_STATUS reply
+type StatusError struct {
+	Code    uint32
+	Message string
+}
+
+func (e *StatusError) Error() string {
+	if e.Message != "" {
+		return "sftp: " + e.Message
+	}
+	return "sftp: status code " + strconv.FormatUint(uint64(e.Code), 10)
+}
+
+// Is maps status codes to the matching io/fs errors
+func (e *StatusError) Is(target error) bool {
+	switch e.Code {
+	case StatusNoSuchFile:
+		return target == fs.ErrNotExist
+	case StatusPermissionDenied:
+		return target == fs.ErrPermission
+	}
+	return false
+}
+
+// Attributes are the file attributes of SFTP version 3. Flags tells which
+// fields are present.
+type Attributes struct {
+	Flags       uint32
+	Size        uint64
+	UID         uint32
+	GID         uint32
+	Permissions uint32
+	Atime       uint32
+	Mtime       uint32
+	Extended    map[string]string
+}
+
+// HasSize reports whether the server sent the file size
+func (a *Attributes) HasSize() bool {
+	return a.Flags&attrSize != 0
+}
+
+// FileMode converts the POSIX permissions to an fs.FileMode
+func (a *Attributes) FileMode() fs.FileMode {
+	return FileMode(a.Permissions)
+}
+
+// FileMode converts a POSIX st_mode, as sent in SFTP attributes or passed to
+// chmod, to an fs.FileMode including the file type and setuid, setgid and
+// sticky bits
+func FileMode(permissions uint32) fs.FileMode {
+	mode := fs.FileMode(permissions & 0777)
+	switch permissions & modeType {
+	case modeDir:
+		mode |= fs.ModeDir
+	case modeSymlink:
+		mode |= fs.ModeSymlink
+	case modeFIFO:
+		mode |= fs.ModeNamedPipe
+	case modeSocket:
+		mode |= fs.ModeSocket
+	case modeChar:
+		mode |= fs.ModeDevice | fs.ModeCharDevice
+	case modeBlock:
+		mode |= fs.ModeDevice
+	}
+	if permissions&modeSetuid != 0 {
+		mode |= fs.ModeSetuid
+	}
+	if permissions&modeSetgid != 0 {
+		mode |= fs.ModeSetgid
+	}
+	if permissions&modeSticky != 0 {
+		mode |= fs.ModeSticky
+	}
+	return mode
+}
+
+// Permissions converts the permission bits of an fs.FileMode, including
+// setuid, setgid and sticky, to POSIX
+func Permissions(mode fs.FileMode) uint32 {
+	permissions := uint32(mode.Perm())
+	if mode&fs.ModeSetuid != 0 {
+		permissions |= modeSetuid
+	}
+	if mode&fs.ModeSetgid != 0 {
+		permissions |= modeSetgid
+	}
+	if mode&fs.ModeSticky != 0 {
+		permissions |= modeSticky
+	}
+	return permissions
+}
+
+// FileInfo describes a remote file and implements fs.FileInfo
+type FileInfo struct {
+	name     string
+	longName string
+	attrs    Attributes
+}
+
+// Name returns the base name of the file
+func (fi *FileInfo) Name() string { return fi.name }
+
+// LongName returns the ls -l style line sent by the server, if any
+func (fi *FileInfo) LongName() string { return fi.longName }
+
+// Size returns the size in bytes
+func (fi *FileInfo) Size() int64 { return int64(fi.attrs.Size) }
+
+// Mode returns the file mode bits
+func (fi *FileInfo) Mode() fs.FileMode { return fi.attrs.FileMode() }
+
+// ModTime returns the modification time
+func (fi *FileInfo) ModTime() time.Time { return time.Unix(int64(fi.attrs.Mtime), 0) }
+
+// IsDir reports whether the file is a directory
+func (fi *FileInfo) IsDir() bool { return fi.Mode().IsDir() }
+
+// Sys returns the raw *Attributes
+func (fi *FileInfo) Sys() interface{} { return &fi.attrs }
+
+// toFlags converts os.OpenFile flags to SFTP open flags
+func toFlags(flag int) uint32 {
+	var flags uint32
+	switch flag & (os.O_RDONLY | os.O_WRONLY | os.O_RDWR) {
+	case os.O_RDONLY:
+		flags |= fxfRead
+	case os.O_WRONLY:
+		flags |= fxfWrite
+	case os.O_RDWR:
+		flags |= fxfRead | fxfWrite
+	}
+	if flag&os.O_APPEND != 0 {
+		flags |= fxfAppend
+	}
+	if flag&os.O_CREATE != 0 {
+		flags |= fxfCreat
+	}
+	if flag&os.O_TRUNC != 0 {
+		flags |= fxfTrunc
+	}
+	if flag&os.O_EXCL != 0 {
+		flags |= fxfExcl
+	}
+	return flags
+}
+
+// buffer builds a packet payload
+type buffer []byte
+
+func (b *buffer) byte(v byte) {
+	*b = append(*b, v)
+}
+
+func (b *buffer) uint32(v uint32) {
+	*b = binary.BigEndian.AppendUint32(*b, v)
+}
+
+func (b *buffer) uint64(v uint64) {
+	*b = binary.BigEndian.AppendUint64(*b, v)
+}
+
+func (b *buffer) string(v string) {
+	b.uint32(uint32(len(v)))
+	*b = append(*b, v...)
+}
+
+func (b *buffer) bytes(v []byte) {
+	b.uint32(uint32(len(v)))
+	*b = append(*b, v...)
+}
+
+func (b *buffer) attrs(a Attributes) {
+	flags := a.Flags &^ attrExtended
+	if len(a.Extended) > 0 {
+		flags |= attrExtended
+	}
+	b.uint32(flags)
+	if flags&attrSize != 0 {
+		b.uint64(a.Size)
+	}
+	if flags&attrUIDGID != 0 {
+		b.uint32(a.UID)
+		b.uint32(a.GID)
+	}
+	if flags&attrPermissions != 0 {
+		b.uint32(a.Permissions)
+	}
+	if flags&attrACModTime != 0 {
+		b.uint32(a.Atime)
+		b.uint32(a.Mtime)
+	}
+	if flags&attrExtended != 0 {
+		b.uint32(uint32(len(a.Extended)))
+		for name, value := range a.Extended {
+			b.string(name)
+			b.string(value)
+		}
+	}
+}
+
+// reader decodes a packet payload, remembering the first error
+type reader struct {
+	data []byte
+	err  error
+}
+
+func (r *reader) uint32() uint32 {
+	if r.err != nil || len(r.data) < 4 {
+		r.err = errShortPacket
+		return 0
+	}
+	v := binary.BigEndian.Uint32(r.data)
+	r.data = r.data[4:]
+	return v
+}
+
+func (r *reader) uint64() uint64 {
+	if r.err != nil || len(r.data) < 8 {
+		r.err = errShortPacket
+		return 0
+	}
+	v := binary.BigEndian.Uint64(r.data)
+	r.data = r.data[8:]
+	return v
+}
+
+func (r *reader) bytes() []byte {
+	n := r.uint32()
+	if r.err != nil || uint32(len(r.data)) < n {
+		r.err = errShortPacket
+		return nil
+	}
+	v := r.data[:n]
+	r.data = r.data[n:]
+	return v
+}
+
+func (r *reader) string() string {
+	return string(r.bytes())
+}
+
+func (r *reader) attrs() Attributes {
+	a := Attributes{Flags: r.uint32()}
+	if a.Flags&attrSize != 0 {
+		a.Size = r.uint64()
+	}
+	if a.Flags&attrUIDGID != 0 {
+		a.UID = r.uint32()
+		a.GID = r.uint32()
+	}
+	if a.Flags&attrPermissions != 0 {
+		a.Permissions = r.uint32()
+	}
+	if a.Flags&attrACModTime != 0 {
+		a.Atime = r.uint32()
+		a.Mtime = r.uint32()
+	}
+	if a.Flags&attrExtended != 0 {
+		count := r.uint32()
+		a.Extended = make(map[string]string)
+		for i := uint32(0); i < count && r.err == nil; i++ {
+			name := r.string()
+			a.Extended[name] = r.string()
+		}
+	}
+	return a
+}
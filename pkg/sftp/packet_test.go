@@ -0,0 +1,250 @@
+package sftp
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestAttributesRoundTrip(t *testing.T) {
+	tests := []struct {
+		name  string
+		attrs Attributes
+		flags uint32
+	}{
+		{"empty", Attributes{}, 0},
+		{"size", Attributes{Flags: attrSize, Size: 1 << 40}, attrSize},
+		{"owner", Attributes{Flags: attrUIDGID, UID: 1000, GID: 100}, attrUIDGID},
+		{"permissions", Attributes{Flags: attrPermissions, Permissions: modeRegular | 0644}, attrPermissions},
+		{"times", Attributes{Flags: attrACModTime, Atime: 1700000000, Mtime: 1700000001}, attrACModTime},
+		{"extended", Attributes{Extended: map[string]string{"a@example.com": "1"}}, attrExtended},
+		{"extended flag without entries", Attributes{Flags: attrSize | attrExtended, Size: 5}, attrSize},
+		{
+			"all",
+			Attributes{
+				Flags:       attrSize | attrUIDGID | attrPermissions | attrACModTime,
+				Size:        42,
+				UID:         1,
+				GID:         2,
+				Permissions: modeDir | 0755,
+				Atime:       3,
+				Mtime:       4,
+				Extended:    map[string]string{"x@example.com": "y", "z@example.com": ""},
+			},
+			attrSize | attrUIDGID | attrPermissions | attrACModTime | attrExtended,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b buffer
+			b.attrs(tt.attrs)
+			r := reader{data: b}
+			got := r.attrs()
+			if r.err != nil {
+				t.Fatal(r.err)
+			}
+			if len(r.data) != 0 {
+				t.Errorf("%d bytes left after decoding", len(r.data))
+			}
+			if got.Flags != tt.flags {
+				t.Errorf("flags = %#x, want %#x", got.Flags, tt.flags)
+			}
+			want := tt.attrs
+			want.Flags = tt.flags
+			if len(want.Extended) == 0 {
+				want.Extended = nil
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("decoded %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestReaderShortPacket(t *testing.T) {
+	var b buffer
+	b.attrs(Attributes{
+		Flags:       attrSize | attrUIDGID | attrPermissions | attrACModTime,
+		Permissions: 0644,
+		Extended:    map[string]string{"name": "value"},
+	})
+	for n := 0; n < len(b); n++ {
+		r := reader{data: b[:n]}
+		r.attrs()
+		if r.err != errShortPacket {
+			t.Errorf("attributes cut to %d bytes: err = %v, want errShortPacket", n, r.err)
+		}
+	}
+
+	b = nil
+	b.string("name")
+	r := reader{data: b[:6]}
+	if s := r.string(); r.err != errShortPacket || s != "" {
+		t.Errorf("truncated string = %q, %v", s, r.err)
+	}
+	r = reader{data: []byte{0, 0, 0, 0, 0, 0, 0}}
+	if r.uint64(); r.err != errShortPacket {
+		t.Errorf("truncated uint64: err = %v", r.err)
+	}
+}
+
+func TestFileMode(t *testing.T) {
+	tests := []struct {
+		permissions uint32
+		mode        fs.FileMode
+	}{
+		{modeRegular | 0644, 0644},
+		{modeDir | 0755, fs.ModeDir | 0755},
+		{modeSymlink | 0777, fs.ModeSymlink | 0777},
+		{modeFIFO | 0600, fs.ModeNamedPipe | 0600},
+		{modeSocket | 0700, fs.ModeSocket | 0700},
+		{modeChar | 0620, fs.ModeDevice | fs.ModeCharDevice | 0620},
+		{modeBlock | 0660, fs.ModeDevice | 0660},
+		{modeRegular | modeSetuid | 0755, fs.ModeSetuid | 0755},
+		{modeDir | modeSetgid | 0775, fs.ModeDir | fs.ModeSetgid | 0775},
+		{modeDir | modeSticky | 0777, fs.ModeDir | fs.ModeSticky | 0777},
+		{0640, 0640},
+	}
+
+	for _, tt := range tests {
+		if got := FileMode(tt.permissions); got != tt.mode {
+			t.Errorf("FileMode(%o) = %v, want %v", tt.permissions, got, tt.mode)
+		}
+		// The type bits are not sent back, everything else survives
+		if got := Permissions(tt.mode); got != tt.permissions&^modeType {
+			t.Errorf("Permissions(%v) = %o, want %o", tt.mode, got, tt.permissions&^modeType)
+		}
+	}
+}
+
+func TestToFlags(t *testing.T) {
+	tests := []struct {
+		flag  int
+		flags uint32
+	}{
+		{os.O_RDONLY, fxfRead},
+		{os.O_WRONLY, fxfWrite},
+		{os.O_RDWR, fxfRead | fxfWrite},
+		{os.O_WRONLY | os.O_APPEND, fxfWrite | fxfAppend},
+		{os.O_RDWR | os.O_CREATE | os.O_TRUNC, fxfRead | fxfWrite | fxfCreat | fxfTrunc},
+		{os.O_WRONLY | os.O_CREATE | os.O_EXCL, fxfWrite | fxfCreat | fxfExcl},
+	}
+
+	for _, tt := range tests {
+		if got := toFlags(tt.flag); got != tt.flags {
+			t.Errorf("toFlags(%#x) = %#x, want %#x", tt.flag, got, tt.flags)
+		}
+	}
+}
+
+func statusResponse(code uint32, message ...string) response {
+	var b buffer
+	b.uint32(code)
+	for _, m := range message {
+		b.string(m)
+	}
+	return response{typ: fxpStatus, data: b}
+}
+
+func TestStatusError(t *testing.T) {
+	tests := []struct {
+		name   string
+		resp   response
+		text   string
+		target error
+	}{
+		{"ok", statusResponse(StatusOK, "Success", "en"), "", nil},
+		{"eof", statusResponse(StatusEOF, "End of file", "en"), "EOF", io.EOF},
+		{"no such file", statusResponse(StatusNoSuchFile, "No such file", "en"), "sftp: No such file", fs.ErrNotExist},
+		{"permission denied without message", statusResponse(StatusPermissionDenied), "sftp: status code 3", fs.ErrPermission},
+		{"failure", statusResponse(StatusFailure, "Failure"), "sftp: Failure", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := statusError(tt.resp)
+			if tt.text == "" {
+				if err != nil {
+					t.Fatalf("err = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.text {
+				t.Fatalf("err = %v, want %q", err, tt.text)
+			}
+			if tt.target != nil && !errors.Is(err, tt.target) {
+				t.Errorf("errors.Is(%v, %v) = false", err, tt.target)
+			}
+			if tt.target == nil && (errors.Is(err, fs.ErrNotExist) || errors.Is(err, fs.ErrPermission)) {
+				t.Errorf("%v matches an fs error", err)
+			}
+		})
+	}
+
+	if err := statusError(response{typ: fxpStatus, data: []byte{0, 0}}); err != errShortPacket {
+		t.Errorf("truncated status: err = %v, want errShortPacket", err)
+	}
+}
+
+func TestResponses(t *testing.T) {
+	var b buffer
+	b.string("handle-1")
+	if handle, err := expectHandle(response{typ: fxpHandle, data: b}); err != nil || handle != "handle-1" {
+		t.Errorf("handle = %q, %v", handle, err)
+	}
+
+	attrs := Attributes{Flags: attrSize | attrPermissions, Size: 7, Permissions: modeRegular | 0600}
+	b = nil
+	b.attrs(attrs)
+	if got, err := expectAttrs(response{typ: fxpAttrs, data: b}); err != nil || !reflect.DeepEqual(got, attrs) {
+		t.Errorf("attrs = %+v, %v", got, err)
+	}
+
+	b = nil
+	b.uint32(2)
+	b.string("a.txt")
+	b.string("-rw------- 1 u g 7 Jan 1 00:00 a.txt")
+	b.attrs(attrs)
+	b.string("sub")
+	b.string("")
+	b.attrs(Attributes{Flags: attrPermissions, Permissions: modeDir | 0755})
+	names, err := expectNames(response{typ: fxpName, data: b})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 2 || names[0].Name() != "a.txt" || names[0].Size() != 7 || names[0].Mode() != 0600 ||
+		names[0].LongName() == "" || names[1].Name() != "sub" || !names[1].IsDir() {
+		t.Errorf("names = %+v %+v", names[0], names[1])
+	}
+	if _, err := expectNames(response{typ: fxpName, data: b[:len(b)-3]}); err != errShortPacket {
+		t.Errorf("truncated names: err = %v", err)
+	}
+
+	f := &File{path: "/f"}
+	b = nil
+	b.string("data")
+	if data, err := f.readData(response{typ: fxpData, data: b}, 4); err != nil || string(data) != "data" {
+		t.Errorf("data = %q, %v", data, err)
+	}
+	if _, err := f.readData(response{typ: fxpData, data: b}, 3); err == nil {
+		t.Error("more data than requested was accepted")
+	}
+	if _, err := f.readData(statusResponse(StatusEOF), 4); err != io.EOF {
+		t.Errorf("read at EOF: err = %v, want io.EOF", err)
+	}
+
+	// A status in place of the expected reply carries its error
+	if _, err := expectHandle(statusResponse(StatusNoSuchFile, "gone")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("handle from status: err = %v", err)
+	}
+	if _, err := expectAttrs(response{typ: fxpData}); err == nil {
+		t.Error("attrs accepted a data reply")
+	}
+	if err := expectStatus(response{typ: fxpHandle}); err == nil {
+		t.Error("status accepted a handle reply")
+	}
+}
@@ -0,0 +1,294 @@
+package sftp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	// DefaultOutstanding is the number of requests kept in flight by default
+	DefaultOutstanding = 16
+	// maxChunkSize keeps a chunk and its packet header under maxPacket
+	maxChunkSize = maxPacket - 1024
+)
+
+// ErrCanceled is returned by a pipelined transfer stopped through its Cancel channel
+var ErrCanceled = errors.New("sftp: transfer canceled")
+
+// PipelineOptions tunes a pipelined transfer
+type PipelineOptions struct {
+	// ChunkSize is the size of each read or write request, 32 KiB by default
+	ChunkSize int
+	// MaxOutstanding is the number of requests kept in flight, DefaultOutstanding by default
+	MaxOutstanding int
+	// OnCommit receives the offset up to which every request has completed.
+	// Everything before it is safe to skip when resuming.
+	OnCommit func(offset int64)
+	// Cancel stops issuing requests once closed
+	Cancel <-chan struct{}
+}
+
+func (o PipelineOptions) withDefaults() PipelineOptions {
+	if o.ChunkSize <= 0 {
+		o.ChunkSize = chunkSize
+	}
+	if o.ChunkSize > maxChunkSize {
+		o.ChunkSize = maxChunkSize
+	}
+	if o.MaxOutstanding <= 0 {
+		o.MaxOutstanding = DefaultOutstanding
+	}
+	return o
+}
+
+func (o PipelineOptions) canceled() bool {
+	select {
+	case <-o.Cancel:
+		return true
+	default:
+		return false
+	}
+}
+
+// pendingRequest is a request in flight covering [offset, offset+length)
+type pendingRequest struct {
+	ch     <-chan response
+	offset int64
+	length int
+}
+
+// PipelinedWrite copies src[off:end] to the same offsets of the file with
+// several write requests in flight. It returns the committed offset, which
+// is also reported to OnCommit after every acknowledged chunk.
+func (f *File) PipelinedWrite(src io.ReaderAt, off, end int64, options PipelineOptions) (int64, error) {
+	options = options.withDefaults()
+	buf := make([]byte, options.ChunkSize)
+
+	var queue []pendingRequest
+	next, committed := off, off
+	var firstErr error
+
+	for {
+		// Keep the window full until an error or cancel stops new requests
+		for firstErr == nil && next < end && len(queue) < options.MaxOutstanding {
+			if options.canceled() {
+				firstErr = ErrCanceled
+				break
+			}
+
+			n := int(min(int64(options.ChunkSize), end-next))
+			read, err := src.ReadAt(buf[:n], next)
+			if read < n {
+				if err == nil || err == io.EOF {
+					err = io.ErrUnexpectedEOF
+				}
+				firstErr = fmt.Errorf("failed to read source at offset %d: %w", next, err)
+				break
+			}
+
+			offset := next
+			ch, err := f.client.send(fxpWrite, func(b *buffer) {
+				b.string(f.handle)
+				b.uint64(uint64(offset))
+				b.bytes(buf[:n])
+			})
+			if err != nil {
+				firstErr = pathError("write", f.path, err)
+				break
+			}
+			queue = append(queue, pendingRequest{ch: ch, offset: offset, length: n})
+			next += int64(n)
+		}
+
+		if len(queue) == 0 {
+			break
+		}
+
+		// Responses are handled in request order so the commit stays contiguous
+		req := queue[0]
+		queue = queue[1:]
+		resp, err := f.client.wait(req.ch)
+		if err == nil {
+			err = expectStatus(resp)
+		}
+		if err != nil {
+			if firstErr == nil {
+				firstErr = pathError("write", f.path, err)
+			}
+			continue
+		}
+		if firstErr == nil {
+			committed = req.offset + int64(req.length)
+			if options.OnCommit != nil {
+				options.OnCommit(committed)
+			}
+		}
+	}
+
+	return committed, firstErr
+}
+
+// PipelinedRead copies the file from off to dst with several read requests in
+// flight, stopping at end or at EOF when end is negative. Chunks are written
+// to dst in order. It returns the committed offset.
+func (f *File) PipelinedRead(dst io.WriterAt, off, end int64, options PipelineOptions) (int64, error) {
+	options = options.withDefaults()
+
+	var queue []pendingRequest
+	next, committed := off, off
+	var firstErr error
+	eof := false
+
+	for {
+		for firstErr == nil && !eof && (end < 0 || next < end) && len(queue) < options.MaxOutstanding {
+			if options.canceled() {
+				firstErr = ErrCanceled
+				break
+			}
+
+			n := options.ChunkSize
+			if end >= 0 {
+				n = int(min(int64(n), end-next))
+			}
+
+			offset := next
+			ch, err := f.client.send(fxpRead, func(b *buffer) {
+				b.string(f.handle)
+				b.uint64(uint64(offset))
+				b.uint32(uint32(n))
+			})
+			if err != nil {
+				firstErr = pathError("read", f.path, err)
+				break
+			}
+			queue = append(queue, pendingRequest{ch: ch, offset: offset, length: n})
+			next += int64(n)
+		}
+
+		if len(queue) == 0 {
+			break
+		}
+
+		req := queue[0]
+		queue = queue[1:]
+		resp, err := f.client.wait(req.ch)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = pathError("read", f.path, err)
+			}
+			continue
+		}
+		if firstErr != nil || eof {
+			continue
+		}
+
+		data, err := f.readData(resp, req.length)
+		if err == io.EOF {
+			eof = true
+			continue
+		}
+		if err != nil {
+			firstErr = err
+			continue
+		}
+
+		// A short read leaves a gap that has to be filled before committing
+		for err == nil && len(data) < req.length {
+			var more []byte
+			more, err = f.readRange(req.offset+int64(len(data)), req.length-len(data))
+			data = append(data, more...)
+		}
+		if err != nil && err != io.EOF {
+			firstErr = err
+			continue
+		}
+		if err == io.EOF {
+			eof = true
+		}
+
+		if len(data) > 0 {
+			if _, werr := dst.WriteAt(data, req.offset); werr != nil {
+				firstErr = fmt.Errorf("failed to write destination at offset %d: %w", req.offset, werr)
+				continue
+			}
+		}
+		committed = req.offset + int64(len(data))
+		if options.OnCommit != nil {
+			options.OnCommit(committed)
+		}
+	}
+
+	return committed, firstErr
+}
+
+// readRange issues one read request and waits for it
+func (f *File) readRange(off int64, length int) ([]byte, error) {
+	resp, err := f.client.request(fxpRead, func(b *buffer) {
+		b.string(f.handle)
+		b.uint64(uint64(off))
+		b.uint32(uint32(length))
+	})
+	if err != nil {
+		return nil, pathError("read", f.path, err)
+	}
+	return f.readData(resp, length)
+}
+
+// readData extracts the payload of an SSH_FXP_DATA reply, or io.EOF
+func (f *File) readData(resp response, length int) ([]byte, error) {
+	if resp.typ != fxpData {
+		err := unexpected(resp, fxpData)
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		return nil, pathError("read", f.path, err)
+	}
+	r := reader{data: resp.data}
+	data := r.bytes()
+	if r.err != nil {
+		return nil, pathError("read", f.path, r.err)
+	}
+	if len(data) > length {
+		return nil, pathError("read", f.path, errors.New("sftp: server returned more data than requested"))
+	}
+	return data, nil
+}
+
+// CheckFile hashes a file on the server with the check-file-name extension.
+// Algorithm is a name such as "sha256"; servers without the extension fail
+// with StatusOpUnsupported.
+func (c *Client) CheckFile(p, algorithm string) ([]byte, error) {
+	return c.CheckFileRange(p, algorithm, 0, 0)
+}
+
+// CheckFileRange is CheckFile for length bytes from offset; a length of 0
+// hashes up to the end of the file
+func (c *Client) CheckFileRange(p, algorithm string, offset, length uint64) ([]byte, error) {
+	resp, err := c.request(fxpExtended, func(b *buffer) {
+		b.string("check-file-name")
+		b.string(p)
+		b.string(algorithm)
+		b.uint64(offset)
+		b.uint64(length)
+		b.uint32(0) // block size, 0 for a single hash
+	})
+	if err != nil {
+		return nil, pathError("check-file", p, err)
+	}
+	if resp.typ != fxpExtendedReply {
+		return nil, pathError("check-file", p, unexpected(resp, fxpExtendedReply))
+	}
+
+	r := reader{data: resp.data}
+	if name := r.string(); r.err == nil && name != "check-file" {
+		return nil, pathError("check-file", p, fmt.Errorf("sftp: unexpected extended reply %q", name))
+	}
+	if used := r.string(); r.err == nil && used != algorithm {
+		return nil, pathError("check-file", p, fmt.Errorf("sftp: server hashed with %s instead of %s", used, algorithm))
+	}
+	if r.err != nil {
+		return nil, pathError("check-file", p, r.err)
+	}
+	return r.data, nil
+}
@@ -0,0 +1,294 @@
+package sftp
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"math/rand"
+	"os"
+	"testing"
+
+	"github.com/andrew/sshclient-wasm/internal/sftptest"
+)
+
+// memFile is an in-memory io.WriterAt
+type memFile struct {
+	data []byte
+}
+
+func (m *memFile) WriteAt(p []byte, off int64) (int, error) {
+	if end := off + int64(len(p)); end > int64(len(m.data)) {
+		m.data = append(m.data, make([]byte, end-int64(len(m.data)))...)
+	}
+	return copy(m.data[off:], p), nil
+}
+
+func testData(n int) []byte {
+	data := make([]byte, n)
+	rand.New(rand.NewSource(int64(n))).Read(data)
+	return data
+}
+
+// commits records the offsets reported to OnCommit and checks they only grow
+type commits struct {
+	t       *testing.T
+	offsets []int64
+}
+
+func (c *commits) record(offset int64) {
+	if n := len(c.offsets); n > 0 && offset < c.offsets[n-1] {
+		c.t.Errorf("commit went back from %d to %d", c.offsets[n-1], offset)
+	}
+	c.offsets = append(c.offsets, offset)
+}
+
+func (c *commits) last() int64 {
+	if len(c.offsets) == 0 {
+		return -1
+	}
+	return c.offsets[len(c.offsets)-1]
+}
+
+func TestPipelinedWrite(t *testing.T) {
+	data := testData(100003)
+	tests := []struct {
+		name    string
+		options sftptest.Options
+	}{
+		{"in order", sftptest.Options{}},
+		{"out of order acknowledgements", sftptest.Options{Reorder: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := sftptest.NewServer()
+			c := newTestClient(t, server, tt.options)
+			f, err := c.Create("/upload")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			seen := &commits{t: t}
+			committed, err := f.PipelinedWrite(bytes.NewReader(data), 0, int64(len(data)), PipelineOptions{
+				ChunkSize:      4096,
+				MaxOutstanding: 8,
+				OnCommit:       seen.record,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if committed != int64(len(data)) || seen.last() != committed {
+				t.Errorf("committed %d, last commit %d, want %d", committed, seen.last(), len(data))
+			}
+			if got, _ := server.ReadFile("/upload"); !bytes.Equal(got, data) {
+				t.Errorf("uploaded %d bytes that differ from the source", len(got))
+			}
+		})
+	}
+}
+
+func TestPipelinedRead(t *testing.T) {
+	data := testData(100003)
+	tests := []struct {
+		name    string
+		options sftptest.Options
+		end     int64
+	}{
+		{"to known size", sftptest.Options{}, int64(len(data))},
+		{"to EOF", sftptest.Options{}, -1},
+		{"short reads", sftptest.Options{MaxRead: 1000}, -1},
+		{"short reads out of order", sftptest.Options{MaxRead: 1000, Reorder: true}, int64(len(data))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := sftptest.NewServer()
+			server.WriteFile("/download", data)
+			c := newTestClient(t, server, tt.options)
+			f, err := c.Open("/download")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			dst := &memFile{}
+			seen := &commits{t: t}
+			committed, err := f.PipelinedRead(dst, 0, tt.end, PipelineOptions{
+				ChunkSize:      4096,
+				MaxOutstanding: 8,
+				OnCommit:       seen.record,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if committed != int64(len(data)) || seen.last() != committed {
+				t.Errorf("committed %d, last commit %d, want %d", committed, seen.last(), len(data))
+			}
+			if !bytes.Equal(dst.data, data) {
+				t.Errorf("downloaded %d bytes that differ from the source", len(dst.data))
+			}
+		})
+	}
+}
+
+func TestPipelinedWriteResumesAfterCut(t *testing.T) {
+	data := testData(200000)
+	server := sftptest.NewServer()
+	c := newTestClient(t, server, sftptest.Options{CloseAfter: 70000, Reorder: true})
+	f, err := c.Create("/upload")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seen := &commits{t: t}
+	options := PipelineOptions{ChunkSize: 4096, MaxOutstanding: 8, OnCommit: seen.record}
+	committed, err := f.PipelinedWrite(bytes.NewReader(data), 0, int64(len(data)), options)
+	if err == nil {
+		t.Fatal("upload survived the cut")
+	}
+	if committed != max(seen.last(), 0) || committed > 70000 {
+		t.Fatalf("committed %d after a cut at 70000, last commit %d", committed, seen.last())
+	}
+
+	// Everything up to the checkpoint reached the server
+	partial, _ := server.ReadFile("/upload")
+	if int64(len(partial)) < committed || !bytes.Equal(partial[:committed], data[:committed]) {
+		t.Fatalf("server holds %d bytes, not the %d committed", len(partial), committed)
+	}
+
+	c = newTestClient(t, server, sftptest.Options{CheckFile: true})
+	f, err = c.OpenFile("/upload", os.O_WRONLY)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if committed, err = f.PipelinedWrite(bytes.NewReader(data), committed, int64(len(data)), options); err != nil {
+		t.Fatal(err)
+	}
+	if committed != int64(len(data)) {
+		t.Errorf("resumed upload committed %d", committed)
+	}
+	if got, _ := server.ReadFile("/upload"); !bytes.Equal(got, data) {
+		t.Fatal("resumed upload differs from the source")
+	}
+	want := sha256.Sum256(data)
+	if sum, err := c.CheckFile("/upload", "sha256"); err != nil || !bytes.Equal(sum, want[:]) {
+		t.Errorf("check-file = %x, %v, want %x", sum, err, want)
+	}
+}
+
+func TestPipelinedReadResumesAfterCut(t *testing.T) {
+	data := testData(200000)
+	server := sftptest.NewServer()
+	server.WriteFile("/download", data)
+	c := newTestClient(t, server, sftptest.Options{CloseAfter: 70000, MaxRead: 3000})
+	f, err := c.Open("/download")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dst := &memFile{}
+	seen := &commits{t: t}
+	options := PipelineOptions{ChunkSize: 4096, MaxOutstanding: 8, OnCommit: seen.record}
+	committed, err := f.PipelinedRead(dst, 0, -1, options)
+	if err == nil {
+		t.Fatal("download survived the cut")
+	}
+	if committed != max(seen.last(), 0) || committed > 70000 {
+		t.Fatalf("committed %d after a cut at 70000, last commit %d", committed, seen.last())
+	}
+	if !bytes.Equal(dst.data[:committed], data[:committed]) {
+		t.Fatal("committed data differs from the source")
+	}
+
+	c = newTestClient(t, server, sftptest.Options{})
+	f, err = c.Open("/download")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if committed, err = f.PipelinedRead(dst, committed, -1, options); err != nil {
+		t.Fatal(err)
+	}
+	if committed != int64(len(data)) || !bytes.Equal(dst.data, data) {
+		t.Errorf("resumed download committed %d of %d bytes, equal %v", committed, len(data), bytes.Equal(dst.data, data))
+	}
+}
+
+func TestPipelinedCancel(t *testing.T) {
+	data := testData(100000)
+	server := sftptest.NewServer()
+	c := newTestClient(t, server, sftptest.Options{})
+	f, err := c.Create("/upload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	cancel := make(chan struct{})
+	options := PipelineOptions{ChunkSize: 4096, MaxOutstanding: 4, Cancel: cancel}
+	options.OnCommit = func(offset int64) {
+		if offset >= 20000 && offset-4096 < 20000 {
+			close(cancel)
+		}
+	}
+	committed, err := f.PipelinedWrite(bytes.NewReader(data), 0, int64(len(data)), options)
+	if !errors.Is(err, ErrCanceled) {
+		t.Fatalf("err = %v, want ErrCanceled", err)
+	}
+	// Requests already in flight complete and count
+	if committed < 20000 || committed >= int64(len(data)) {
+		t.Fatalf("committed %d after canceling at 20000", committed)
+	}
+
+	options = PipelineOptions{ChunkSize: 4096}
+	if committed, err = f.PipelinedWrite(bytes.NewReader(data), committed, int64(len(data)), options); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := server.ReadFile("/upload"); committed != int64(len(data)) || !bytes.Equal(got, data) {
+		t.Error("upload resumed after cancel differs from the source")
+	}
+
+	closed := make(chan struct{})
+	close(closed)
+	dst := &memFile{}
+	committed, err = f.PipelinedRead(dst, 0, -1, PipelineOptions{Cancel: closed})
+	if !errors.Is(err, ErrCanceled) || committed != 0 || len(dst.data) != 0 {
+		t.Errorf("read canceled up front: committed %d, err %v", committed, err)
+	}
+}
+
+func TestCheckFileRange(t *testing.T) {
+	data := testData(5000)
+	server := sftptest.NewServer()
+	server.WriteFile("/file", data)
+	c := newTestClient(t, server, sftptest.Options{CheckFile: true})
+
+	tests := []struct {
+		offset, length uint64
+		want           []byte
+	}{
+		{0, 0, data},
+		{1000, 0, data[1000:]},
+		{1000, 500, data[1000:1500]},
+	}
+	for _, tt := range tests {
+		want := sha256.Sum256(tt.want)
+		sum, err := c.CheckFileRange("/file", "sha256", tt.offset, tt.length)
+		if err != nil || !bytes.Equal(sum, want[:]) {
+			t.Errorf("check-file %d+%d = %x, %v, want %x", tt.offset, tt.length, sum, err, want)
+		}
+	}
+
+	if _, err := c.CheckFile("/file", "md5"); err == nil {
+		t.Error("check-file hashed with an algorithm the server lacks")
+	}
+
+	c = newTestClient(t, server, sftptest.Options{})
+	_, err := c.CheckFile("/file", "sha256")
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.Code != StatusOpUnsupported {
+		t.Errorf("check-file without the extension: err = %v, want StatusOpUnsupported", err)
+	}
+}
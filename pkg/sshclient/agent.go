@@ -0,0 +1,328 @@
+package sshclient
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// AgentIdentity describes a key held by the in-memory agent
+type AgentIdentity struct {
+	KeyType          string
+	Fingerprint      string
+	PublicKey        string
+	Comment          string
+	ConfirmBeforeUse bool
+	// ExpiresAt is zero for keys without a lifetime
+	ExpiresAt time.Time
+}
+
+// AgentKeyOptions configures a key added to the agent
+type AgentKeyOptions struct {
+	Comment string
+	// Lifetime removes the key automatically once it elapses
+	Lifetime time.Duration
+	// ConfirmBeforeUse asks the confirm callback before every signature
+	ConfirmBeforeUse bool
+	// Certificate is an optional OpenSSH certificate for the key in authorized_keys format
+	Certificate string
+}
+
+// AgentConfirmCallback is asked whether a confirm-before-use key may sign
+type AgentConfirmCallback func(identity AgentIdentity) (bool, error)
+
+// Agent is an in-memory SSH agent that serves both local authentication and
+// agent channels forwarded by the server. It wraps the x/crypto keyring with
+// confirm-before-use support, which the keyring itself ignores.
+type Agent struct {
+	keyring   agent.ExtendedAgent
+	confirm   map[string]bool
+	expiry    map[string]time.Time
+	onConfirm AgentConfirmCallback
+	mu        sync.Mutex
+}
+
+// NewAgent creates an empty agent
+func NewAgent() *Agent {
+	return &Agent{
+		keyring: agent.NewKeyring().(agent.ExtendedAgent),
+		confirm: make(map[string]bool),
+		expiry:  make(map[string]time.Time),
+	}
+}
+
+// OnConfirm sets the callback asked before confirm-before-use keys sign
+func (a *Agent) OnConfirm(callback AgentConfirmCallback) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.onConfirm = callback
+}
+
+// AddPrivateKey decodes a PEM or OpenSSH private key and adds it to the agent
+func (a *Agent) AddPrivateKey(pemKey, passphrase string, options AgentKeyOptions) (AgentIdentity, error) {
+	var raw interface{}
+	var err error
+	if passphrase != "" {
+		raw, err = ssh.ParseRawPrivateKeyWithPassphrase([]byte(pemKey), []byte(passphrase))
+	} else {
+		raw, err = ssh.ParseRawPrivateKey([]byte(pemKey))
+	}
+	if err != nil {
+		return AgentIdentity{}, fmt.Errorf("failed to parse private key: %v", err)
+	}
+
+	added := agent.AddedKey{
+		PrivateKey:       raw,
+		Comment:          options.Comment,
+		LifetimeSecs:     uint32(options.Lifetime / time.Second),
+		ConfirmBeforeUse: options.ConfirmBeforeUse,
+	}
+
+	signer, err := ssh.NewSignerFromKey(raw)
+	if err != nil {
+		return AgentIdentity{}, fmt.Errorf("unsupported private key: %v", err)
+	}
+	pub := signer.PublicKey()
+
+	if options.Certificate != "" {
+		certKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(options.Certificate))
+		if err != nil {
+			return AgentIdentity{}, fmt.Errorf("failed to parse certificate: %v", err)
+		}
+		cert, ok := certKey.(*ssh.Certificate)
+		if !ok {
+			return AgentIdentity{}, errors.New("certificate is a plain public key")
+		}
+		if !keysEqual(cert.Key, pub) {
+			return AgentIdentity{}, errors.New("certificate does not match the private key")
+		}
+		added.Certificate = cert
+		pub = cert
+	}
+
+	if err := a.Add(added); err != nil {
+		return AgentIdentity{}, err
+	}
+	return a.identity(pub, options.Comment), nil
+}
+
+// Identities lists the keys currently held by the agent
+func (a *Agent) Identities() ([]AgentIdentity, error) {
+	a.expire()
+	keys, err := a.keyring.List()
+	if err != nil {
+		return nil, err
+	}
+
+	identities := make([]AgentIdentity, 0, len(keys))
+	for _, key := range keys {
+		identities = append(identities, a.identity(key, key.Comment))
+	}
+	return identities, nil
+}
+
+func (a *Agent) identity(pub ssh.PublicKey, comment string) AgentIdentity {
+	fingerprint := ssh.FingerprintSHA256(pub)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return AgentIdentity{
+		KeyType:          pub.Type(),
+		Fingerprint:      fingerprint,
+		PublicKey:        strings.TrimSpace(string(ssh.MarshalAuthorizedKey(pub))),
+		Comment:          comment,
+		ConfirmBeforeUse: a.confirm[fingerprint],
+		ExpiresAt:        a.expiry[fingerprint],
+	}
+}
+
+// List returns the identities of all keys, implementing agent.Agent
+func (a *Agent) List() ([]*agent.Key, error) {
+	a.expire()
+	return a.keyring.List()
+}
+
+// Add adds a key, implementing agent.Agent
+func (a *Agent) Add(key agent.AddedKey) error {
+	a.expire()
+	if err := a.keyring.Add(key); err != nil {
+		return err
+	}
+
+	pub, err := addedKeyPublicKey(key)
+	if err != nil {
+		return err
+	}
+	fingerprint := ssh.FingerprintSHA256(pub)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.confirm[fingerprint] = key.ConfirmBeforeUse
+	if key.LifetimeSecs > 0 {
+		a.expiry[fingerprint] = time.Now().Add(time.Duration(key.LifetimeSecs) * time.Second)
+	} else {
+		delete(a.expiry, fingerprint)
+	}
+	return nil
+}
+
+// Remove removes a key, implementing agent.Agent
+func (a *Agent) Remove(key ssh.PublicKey) error {
+	if err := a.keyring.Remove(key); err != nil {
+		return err
+	}
+
+	fingerprint := ssh.FingerprintSHA256(key)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.confirm, fingerprint)
+	delete(a.expiry, fingerprint)
+	return nil
+}
+
+// RemoveAll removes every key, implementing agent.Agent
+func (a *Agent) RemoveAll() error {
+	a.mu.Lock()
+	a.confirm = make(map[string]bool)
+	a.expiry = make(map[string]time.Time)
+	a.mu.Unlock()
+	return a.keyring.RemoveAll()
+}
+
+// Lock locks the agent with a passphrase, implementing agent.Agent
+func (a *Agent) Lock(passphrase []byte) error {
+	return a.keyring.Lock(passphrase)
+}
+
+// Unlock unlocks the agent, implementing agent.Agent
+func (a *Agent) Unlock(passphrase []byte) error {
+	return a.keyring.Unlock(passphrase)
+}
+
+// Sign signs data, implementing agent.Agent
+func (a *Agent) Sign(key ssh.PublicKey, data []byte) (*ssh.Signature, error) {
+	return a.SignWithFlags(key, data, 0)
+}
+
+// SignWithFlags signs data after confirmation when the key requires it, implementing agent.ExtendedAgent
+func (a *Agent) SignWithFlags(key ssh.PublicKey, data []byte, flags agent.SignatureFlags) (*ssh.Signature, error) {
+	a.expire()
+	if err := a.confirmUse(key); err != nil {
+		return nil, err
+	}
+	return a.keyring.SignWithFlags(key, data, flags)
+}
+
+// Signers returns signers for all keys, implementing agent.Agent
+func (a *Agent) Signers() ([]ssh.Signer, error) {
+	a.expire()
+	signers, err := a.keyring.Signers()
+	if err != nil {
+		return nil, err
+	}
+
+	// Route signatures through the agent so confirmation also applies to local authentication
+	wrapped := make([]ssh.Signer, len(signers))
+	for i, signer := range signers {
+		wrapped[i] = &agentSigner{agent: a, pub: signer.PublicKey()}
+	}
+	return wrapped, nil
+}
+
+// Extension implements agent.ExtendedAgent
+func (a *Agent) Extension(extensionType string, contents []byte) ([]byte, error) {
+	return a.keyring.Extension(extensionType, contents)
+}
+
+// expire forgets the confirm and expiry state of keys whose lifetime has
+// elapsed. The keyring drops those keys itself whenever it is used, but it
+// does not know about these maps.
+func (a *Agent) expire() {
+	now := time.Now()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for fingerprint, expiresAt := range a.expiry {
+		if !now.Before(expiresAt) {
+			delete(a.expiry, fingerprint)
+			delete(a.confirm, fingerprint)
+		}
+	}
+}
+
+func (a *Agent) confirmUse(key ssh.PublicKey) error {
+	fingerprint := ssh.FingerprintSHA256(key)
+
+	a.mu.Lock()
+	needsConfirm := a.confirm[fingerprint]
+	onConfirm := a.onConfirm
+	a.mu.Unlock()
+
+	if !needsConfirm {
+		return nil
+	}
+	if onConfirm == nil {
+		return errors.New("agent: key requires confirmation but no confirm callback is set")
+	}
+
+	comment := ""
+	if keys, err := a.keyring.List(); err == nil {
+		for _, k := range keys {
+			if keysEqual(k, key) {
+				comment = k.Comment
+				break
+			}
+		}
+	}
+
+	ok, err := onConfirm(a.identity(key, comment))
+	if err != nil {
+		return fmt.Errorf("agent: confirmation failed: %v", err)
+	}
+	if !ok {
+		return errors.New("agent: signature refused by user")
+	}
+	return nil
+}
+
+func addedKeyPublicKey(key agent.AddedKey) (ssh.PublicKey, error) {
+	if key.Certificate != nil {
+		return key.Certificate, nil
+	}
+	signer, err := ssh.NewSignerFromKey(key.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	return signer.PublicKey(), nil
+}
+
+// agentSigner signs through the Agent so confirm-before-use is enforced
+type agentSigner struct {
+	agent *Agent
+	pub   ssh.PublicKey
+}
+
+func (s *agentSigner) PublicKey() ssh.PublicKey {
+	return s.pub
+}
+
+func (s *agentSigner) Sign(_ io.Reader, data []byte) (*ssh.Signature, error) {
+	return s.agent.Sign(s.pub, data)
+}
+
+func (s *agentSigner) SignWithAlgorithm(_ io.Reader, data []byte, algorithm string) (*ssh.Signature, error) {
+	var flags agent.SignatureFlags
+	switch algorithm {
+	case ssh.KeyAlgoRSASHA256, ssh.CertAlgoRSASHA256v01:
+		flags = agent.SignatureFlagRsaSha256
+	case ssh.KeyAlgoRSASHA512, ssh.CertAlgoRSASHA512v01:
+		flags = agent.SignatureFlagRsaSha512
+	}
+	return s.agent.SignWithFlags(s.pub, data, flags)
+}
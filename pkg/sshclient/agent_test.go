@@ -0,0 +1,75 @@
+package sshclient
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestAgentForgetsExpiredKeys(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fingerprint := ssh.FingerprintSHA256(signer.PublicKey())
+
+	a := NewAgent()
+	confirmations := 0
+	a.OnConfirm(func(identity AgentIdentity) (bool, error) {
+		confirmations++
+		return true, nil
+	})
+
+	if err := a.Add(agent.AddedKey{PrivateKey: priv, LifetimeSecs: 1, ConfirmBeforeUse: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Sign(signer.PublicKey(), []byte("data")); err != nil {
+		t.Fatal(err)
+	}
+	if confirmations != 1 {
+		t.Fatalf("confirmed %d times, want 1", confirmations)
+	}
+
+	time.Sleep(1100 * time.Millisecond)
+
+	identities, err := a.Identities()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(identities) != 0 {
+		t.Fatalf("identities after expiry = %v, want none", identities)
+	}
+	a.mu.Lock()
+	_, confirmKept := a.confirm[fingerprint]
+	_, expiryKept := a.expiry[fingerprint]
+	a.mu.Unlock()
+	if confirmKept || expiryKept {
+		t.Errorf("expired key still tracked: confirm %v, expiry %v", confirmKept, expiryKept)
+	}
+
+	// Re-adding the key without confirmation must not inherit the old settings
+	if err := a.Add(agent.AddedKey{PrivateKey: priv}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Sign(signer.PublicKey(), []byte("data")); err != nil {
+		t.Fatal(err)
+	}
+	if confirmations != 1 {
+		t.Errorf("confirmed %d times, want the re-added key to sign without asking", confirmations)
+	}
+	identities, err = a.Identities()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(identities) != 1 || identities[0].ConfirmBeforeUse || !identities[0].ExpiresAt.IsZero() {
+		t.Errorf("re-added identity = %+v, want no confirmation or lifetime", identities)
+	}
+}
@@ -0,0 +1,385 @@
+package sshclient
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// maxPassphraseAttempts bounds how often the passphrase callback is asked for one key
+const maxPassphraseAttempts = 3
+
+// PrivateKey is a private key offered for public key authentication
+type PrivateKey struct {
+	// Label identifies the key when reporting which one authenticated
+	Label string
+	// Key is the PEM or OpenSSH encoded private key
+	Key string
+	// Passphrase decrypts an encrypted key
+	Passphrase string
+	// Certificate is an OpenSSH user certificate for the key in authorized_keys format
+	Certificate string
+}
+
+// PassphraseCallback returns the passphrase for the encrypted key with the given label
+type PassphraseCallback func(label string) (string, error)
+
+// AuthPrompt is a single keyboard-interactive challenge sent by the server
+type AuthPrompt struct {
+	User        string
+	Name        string
+	Instruction string
+	Questions   []string
+	Echos       []bool
+}
+
+// AuthPromptCallback answers a keyboard-interactive challenge, one answer per question
+type AuthPromptCallback func(prompt AuthPrompt) ([]string, error)
+
+// OnAuthPrompt sets the callback used to answer keyboard-interactive challenges
+func (c *Client) OnAuthPrompt(callback AuthPromptCallback) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onAuthPrompt = callback
+}
+
+// OnPassphraseNeeded sets the callback used to decrypt keys that came without a passphrase
+func (c *Client) OnPassphraseNeeded(callback PassphraseCallback) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onPassphraseNeeded = callback
+}
+
+// AuthenticatedKey returns the label of the private key the server accepted, if any
+func (c *Client) AuthenticatedKey() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.authenticatedKey
+}
+
+func (c *Client) setAuthenticatedKey(label string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.authenticatedKey = label
+}
+
+// authMethods builds the authentication methods offered to the server
+// described by options, in the order they are tried
+func (c *Client) authMethods(options ConnectionOptions) ([]ssh.AuthMethod, error) {
+	var methods []ssh.AuthMethod
+
+	if options.Password != "" {
+		methods = append(methods, ssh.Password(options.Password))
+	}
+
+	signers, err := c.keySigners(options)
+	if err != nil {
+		return nil, err
+	}
+	// Agent keys are looked up when authentication runs so keys added
+	// between connections are offered too. All keys share one method
+	// because the ssh package tries each method name only once.
+	methods = append(methods, ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+		agentSigners, err := c.agent.Signers()
+		if err != nil {
+			return signers, nil
+		}
+		return append(append([]ssh.Signer(nil), signers...), agentSigners...), nil
+	}))
+
+	if options.Password != "" || c.onAuthPrompt != nil {
+		methods = append(methods, ssh.KeyboardInteractive(c.keyboardInteractiveChallenge(options)))
+	}
+
+	return methods, nil
+}
+
+// keyboardInteractiveChallenge forwards server challenges to the auth prompt
+// callback. For servers that disable the "password" method, the configured
+// password answers the first lone hidden question.
+func (c *Client) keyboardInteractiveChallenge(options ConnectionOptions) ssh.KeyboardInteractiveChallenge {
+	passwordUsed := false
+
+	return func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+		// PAM often sends empty rounds that only carry an instruction
+		if len(questions) == 0 {
+			return []string{}, nil
+		}
+
+		if options.Password != "" && !passwordUsed && len(questions) == 1 && !echos[0] {
+			passwordUsed = true
+			return []string{options.Password}, nil
+		}
+
+		if c.onAuthPrompt == nil {
+			return nil, errors.New("keyboard-interactive authentication requires an auth prompt callback")
+		}
+
+		answers, err := c.onAuthPrompt(AuthPrompt{
+			User:        options.User,
+			Name:        name,
+			Instruction: instruction,
+			Questions:   questions,
+			Echos:       echos,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if len(answers) != len(questions) {
+			return nil, fmt.Errorf("auth prompt returned %d answers for %d questions", len(answers), len(questions))
+		}
+		return answers, nil
+	}
+}
+
+// privateKeys lists the keys configured in options in the order they are tried
+func privateKeys(options ConnectionOptions) []PrivateKey {
+	var keys []PrivateKey
+	if options.PrivateKey != "" {
+		keys = append(keys, PrivateKey{
+			Label:       "privateKey",
+			Key:         options.PrivateKey,
+			Passphrase:  options.Passphrase,
+			Certificate: options.Certificate,
+		})
+	}
+	for i, key := range options.PrivateKeys {
+		if key.Label == "" {
+			key.Label = fmt.Sprintf("privateKeys[%d]", i)
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func (c *Client) keySigners(options ConnectionOptions) ([]ssh.Signer, error) {
+	var signers []ssh.Signer
+	for _, key := range privateKeys(options) {
+		signer, err := c.newKeySigner(key)
+		if err != nil {
+			return nil, err
+		}
+		if key.Certificate != "" {
+			if signer, err = newCertSigner(key, signer, options.User); err != nil {
+				return nil, err
+			}
+		}
+		signers = append(signers, signer)
+	}
+
+	for i, key := range options.ExternalKeys {
+		if key.Label == "" {
+			key.Label = fmt.Sprintf("externalKeys[%d]", i)
+		}
+		signer, err := c.newExternalKeySigner(key, options.User)
+		if err != nil {
+			return nil, err
+		}
+		signers = append(signers, signer)
+	}
+
+	return signers, nil
+}
+
+func (c *Client) newExternalKeySigner(key ExternalKey, user string) (ssh.Signer, error) {
+	pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(key.PublicKey))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key of external key %s: %v", key.Label, err)
+	}
+
+	external, err := NewExternalSigner(pub, key.Sign)
+	if err != nil {
+		return nil, fmt.Errorf("external key %s: %v", key.Label, err)
+	}
+
+	var signer ssh.Signer = &keySigner{
+		label:  key.Label,
+		pub:    pub,
+		signer: external,
+		onSign: c.setAuthenticatedKey,
+	}
+	if key.Certificate != "" {
+		return newCertSigner(PrivateKey{Label: key.Label, Certificate: key.Certificate}, signer, user)
+	}
+	return signer, nil
+}
+
+// newKeySigner parses key, deferring the passphrase prompt for encrypted
+// OpenSSH keys until the server has accepted their public half
+func (c *Client) newKeySigner(key PrivateKey) (ssh.Signer, error) {
+	s := &keySigner{
+		label:  key.Label,
+		onSign: c.setAuthenticatedKey,
+	}
+
+	signer, err := ssh.ParsePrivateKey([]byte(key.Key))
+	if err == nil {
+		s.signer = signer
+		s.pub = signer.PublicKey()
+		return s, nil
+	}
+
+	var missing *ssh.PassphraseMissingError
+	if !errors.As(err, &missing) {
+		return nil, fmt.Errorf("failed to parse private key %s: %v", key.Label, err)
+	}
+
+	s.decrypt = func() (ssh.Signer, error) {
+		return c.decryptKey(key)
+	}
+	if key.Passphrase == "" && missing.PublicKey != nil {
+		s.pub = missing.PublicKey
+		return s, nil
+	}
+
+	// Legacy PEM keys do not carry a clear-text public key, so decrypt now
+	if s.signer, err = s.decrypt(); err != nil {
+		return nil, err
+	}
+	s.pub = s.signer.PublicKey()
+	return s, nil
+}
+
+// newCertSigner pairs signer with the key's certificate after checking that
+// the certificate is usable for a login as user, so an expired or mismatched
+// certificate fails with a clear reason instead of a generic auth failure
+func newCertSigner(key PrivateKey, signer ssh.Signer, user string) (ssh.Signer, error) {
+	pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(key.Certificate))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate for private key %s: %v", key.Label, err)
+	}
+	cert, ok := pub.(*ssh.Certificate)
+	if !ok {
+		return nil, fmt.Errorf("certificate for private key %s is a plain %s public key", key.Label, pub.Type())
+	}
+
+	if err := validateUserCertificate(cert, user, time.Now()); err != nil {
+		return nil, fmt.Errorf("certificate for private key %s: %v", key.Label, err)
+	}
+
+	certSigner, err := ssh.NewCertSigner(cert, signer)
+	if err != nil {
+		return nil, fmt.Errorf("certificate for private key %s does not match the key: %v", key.Label, err)
+	}
+
+	return certSigner, nil
+}
+
+// validateUserCertificate checks the fields the server will reject a certificate for
+func validateUserCertificate(cert *ssh.Certificate, user string, now time.Time) error {
+	if cert.CertType != ssh.UserCert {
+		return fmt.Errorf("%q is a host certificate, not a user certificate", cert.KeyId)
+	}
+
+	unix := uint64(now.Unix())
+	if unix < cert.ValidAfter {
+		return fmt.Errorf("%q is not valid before %s", cert.KeyId, certTime(cert.ValidAfter))
+	}
+	if cert.ValidBefore != ssh.CertTimeInfinity && unix >= cert.ValidBefore {
+		return fmt.Errorf("%q expired at %s", cert.KeyId, certTime(cert.ValidBefore))
+	}
+
+	if len(cert.ValidPrincipals) == 0 {
+		return fmt.Errorf("%q has no principals", cert.KeyId)
+	}
+	for _, principal := range cert.ValidPrincipals {
+		if principal == user {
+			return nil
+		}
+	}
+	return fmt.Errorf("%q is not valid for user %q (principals: %s)", cert.KeyId, user, strings.Join(cert.ValidPrincipals, ", "))
+}
+
+func certTime(t uint64) string {
+	return time.Unix(int64(t), 0).UTC().Format(time.RFC3339)
+}
+
+func (c *Client) decryptKey(key PrivateKey) (ssh.Signer, error) {
+	if key.Passphrase != "" {
+		signer, err := ssh.ParsePrivateKeyWithPassphrase([]byte(key.Key), []byte(key.Passphrase))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt private key %s: %v", key.Label, err)
+		}
+		return signer, nil
+	}
+
+	if c.onPassphraseNeeded == nil {
+		return nil, fmt.Errorf("private key %s is passphrase protected and no passphrase was provided", key.Label)
+	}
+
+	var lastErr error
+	for attempt := 0; attempt < maxPassphraseAttempts; attempt++ {
+		passphrase, err := c.onPassphraseNeeded(key.Label)
+		if err != nil {
+			return nil, err
+		}
+		signer, err := ssh.ParsePrivateKeyWithPassphrase([]byte(key.Key), []byte(passphrase))
+		if err == nil {
+			return signer, nil
+		}
+		lastErr = err
+	}
+	return nil, fmt.Errorf("failed to decrypt private key %s: %v", key.Label, lastErr)
+}
+
+// keySigner wraps a private key so that decryption happens on first use and
+// the key that produced the authentication signature can be reported
+type keySigner struct {
+	label   string
+	pub     ssh.PublicKey
+	signer  ssh.Signer
+	decrypt func() (ssh.Signer, error)
+	onSign  func(label string)
+	mu      sync.Mutex
+}
+
+func (s *keySigner) PublicKey() ssh.PublicKey {
+	return s.pub
+}
+
+// Algorithms reports what the wrapped signer supports, or every algorithm for
+// the key type while an encrypted key is still locked
+func (s *keySigner) Algorithms() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if multi, ok := s.signer.(ssh.MultiAlgorithmSigner); ok {
+		return multi.Algorithms()
+	}
+	return algorithmsForKeyType(s.pub.Type())
+}
+
+func (s *keySigner) Sign(rand io.Reader, data []byte) (*ssh.Signature, error) {
+	return s.SignWithAlgorithm(rand, data, "")
+}
+
+func (s *keySigner) SignWithAlgorithm(rand io.Reader, data []byte, algorithm string) (*ssh.Signature, error) {
+	s.mu.Lock()
+	if s.signer == nil {
+		signer, err := s.decrypt()
+		if err != nil {
+			s.mu.Unlock()
+			return nil, err
+		}
+		s.signer = signer
+	}
+	signer := s.signer
+	s.mu.Unlock()
+
+	if s.onSign != nil {
+		s.onSign(s.label)
+	}
+
+	if algorithm == "" {
+		return signer.Sign(rand, data)
+	}
+	algorithmSigner, ok := signer.(ssh.AlgorithmSigner)
+	if !ok {
+		return nil, fmt.Errorf("private key %s does not support %s signatures", s.label, algorithm)
+	}
+	return algorithmSigner.SignWithAlgorithm(rand, data, algorithm)
+}
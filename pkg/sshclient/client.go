@@ -1,38 +1,91 @@
 package sshclient
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/andrew/sshclient-wasm/pkg/sftp"
 	"golang.org/x/crypto/ssh"
 )
 
 type ConnectionOptions struct {
-	Host       string
-	Port       int
-	User       string
-	Password   string
-	PrivateKey string
-	Timeout    int
+	Host         string
+	Port         int
+	User         string
+	Password     string
+	PrivateKey   string
+	Passphrase   string
+	PrivateKeys  []PrivateKey
+	Certificate  string
+	ExternalKeys []ExternalKey
+	ForwardAgent bool
+	PTY          PTYOptions
+	// Timeout bounds the whole handshake and authentication, in seconds
+	Timeout      int
+	HostKey      HostKeyPolicy
+	Keepalive    KeepaliveOptions
+	Reconnect    ReconnectOptions
+	// JumpHosts are bastions connected through in order, like ProxyJump.
+	// Only the address, user, credentials and HostKey of each are used.
+	JumpHosts []ConnectionOptions
 }
 
+// ErrHandshakeTimeout is wrapped by the error of a Connect that did not
+// finish within ConnectionOptions.Timeout
+var ErrHandshakeTimeout = errors.New("ssh handshake timed out")
+
 type PacketCallback func(data []byte, metadata map[string]interface{})
-type StateCallback func(state string)
+type StateCallback func(state string, detail StateDetail)
+
+// StateDetail tells which server a state change is about
+type StateDetail struct {
+	// Hop is the 1-based position in the jump chain, 0 for the target server
+	Hop  int
+	Host string
+	Port int
+	// Reason explains an error detected after connecting, or why the client
+	// is reconnecting
+	Reason string
+	// Attempt counts reconnection attempts from 1, 0 otherwise
+	Attempt int
+}
 
 type Client struct {
-	options          ConnectionOptions
-	conn             *ssh.Client
-	session          *ssh.Session
-	sessionID        string
-	mu               sync.RWMutex
-	onPacketReceive  PacketCallback
-	onPacketSend     PacketCallback
-	onStateChange    StateCallback
-	transport        Transport
-	stdin            chan []byte
-	stdout           chan []byte
-	shellStarted     bool
+	options            ConnectionOptions
+	conn               *ssh.Client
+	jumps              []*ssh.Client
+	channels           map[string]*Session
+	forwards           map[string]*ForwardedChannel
+	listeners          map[string]*RemoteListener
+	sftp               *sftp.Client
+	sftpMu             sync.Mutex
+	shell              *Session
+	shellMu            sync.Mutex
+	nextChannel        int
+	sessionID          string
+	mu                 sync.RWMutex
+	onPacketReceive    PacketCallback
+	onPacketSend       PacketCallback
+	onStderr           PacketCallback
+	onStateChange      StateCallback
+	onAuthPrompt       AuthPromptCallback
+	onPassphraseNeeded PassphraseCallback
+	onRequestTransport TransportRequestFunc
+	stopReconnect      chan struct{}
+	authenticatedKey   string
+	transport          Transport
+	hostKeys           *hostKeyVerifier
+	jumpHostKeys       []*hostKeyVerifier
+	agent              *Agent
+	// agentForwarding is the connection agent channels are handled on
+	agentForwarding    *ssh.Client
+	pty                PTYOptions
 }
 
 var (
@@ -41,11 +94,22 @@ var (
 )
 
 func New(options ConnectionOptions) *Client {
+	// Jump hosts keep their own verifiers so accepted keys last across connects
+	jumpHostKeys := make([]*hostKeyVerifier, len(options.JumpHosts))
+	for i, jump := range options.JumpHosts {
+		jumpHostKeys[i] = newHostKeyVerifier(jump.HostKey)
+	}
+
 	return &Client{
-		options:   options,
-		sessionID: generateSessionID(),
-		stdin:     make(chan []byte, 100),
-		stdout:    make(chan []byte, 100),
+		options:      options,
+		sessionID:    generateSessionID(),
+		channels:     make(map[string]*Session),
+		forwards:     make(map[string]*ForwardedChannel),
+		listeners:    make(map[string]*RemoteListener),
+		hostKeys:     newHostKeyVerifier(options.HostKey),
+		jumpHostKeys: jumpHostKeys,
+		agent:        NewAgent(),
+		pty:          options.PTY.withDefaults(),
 	}
 }
 
@@ -68,6 +132,19 @@ func (c *Client) OnPacketSend(callback PacketCallback) {
 	c.onPacketSend = callback
 }
 
+// OnStderr sets the callback for the default shell's stderr, which otherwise
+// goes to the packet receive callback
+func (c *Client) OnStderr(callback PacketCallback) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onStderr = callback
+}
+
+// Agent returns the in-memory agent used for authentication and forwarding
+func (c *Client) Agent() *Agent {
+	return c.agent
+}
+
 func (c *Client) OnStateChange(callback StateCallback) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -77,45 +154,22 @@ func (c *Client) OnStateChange(callback StateCallback) {
 func (c *Client) Connect() (string, error) {
 	c.notifyStateChange("connecting")
 	
-	config := &ssh.ClientConfig{
-		User:            c.options.User,
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-		Timeout:         time.Duration(c.options.Timeout) * time.Second,
-	}
-	
-	if c.options.Password != "" {
-		config.Auth = append(config.Auth, ssh.Password(c.options.Password))
-	}
-	
-	if c.options.PrivateKey != "" {
-		signer, err := ssh.ParsePrivateKey([]byte(c.options.PrivateKey))
-		if err != nil {
-			c.notifyStateChange("error")
-			return "", fmt.Errorf("failed to parse private key: %v", err)
-		}
-		config.Auth = append(config.Auth, ssh.PublicKeys(signer))
-	}
-	
 	// The transport should already be set before calling Connect
 	if c.transport == nil {
 		c.notifyStateChange("error")
 		return "", fmt.Errorf("no transport configured")
 	}
 	
-	// Create SSH connection over the transport
-	addr := fmt.Sprintf("%s:%d", c.options.Host, c.options.Port)
-	
-	// Wrap transport with packet interceptor
-	wrappedTransport := NewInterceptedTransport(c.transport, c.onPacketSend, c.onPacketReceive)
-	
-	// Create SSH client connection using the transport
-	sshConn, chans, reqs, err := ssh.NewClientConn(wrappedTransport, addr, config)
+	client, jumps, detail, err := c.establish()
 	if err != nil {
-		c.notifyStateChange("error")
-		return "", fmt.Errorf("failed to establish SSH connection: %v", err)
+		c.notifyState("error", detail)
+		return "", err
 	}
 	
-	c.conn = ssh.NewClient(sshConn, chans, reqs)
+	c.mu.Lock()
+	c.conn = client
+	c.jumps = jumps
+	c.mu.Unlock()
 	
 	sessionsMu.Lock()
 	sessions[c.sessionID] = c
@@ -123,184 +177,306 @@ func (c *Client) Connect() (string, error) {
 	
 	c.notifyStateChange("connected")
 	
+	c.startKeepalive(client)
+	c.watchConnection(client)
+
 	return c.sessionID, nil
 }
 
-func (c *Client) StartShell() error {
-	c.mu.Lock()
-	defer c.mu.Unlock()
+// establish runs the handshake over the current transport. ssh.ClientConfig.Timeout
+// only covers dialing, which the transport has already done, so the handshake
+// is bounded by closing the transport once the timeout runs out.
+func (c *Client) establish() (*ssh.Client, []*ssh.Client, StateDetail, error) {
+	c.mu.RLock()
+	transport := c.transport
+	c.mu.RUnlock()
 	
-	if c.shellStarted {
-		return nil // Shell already started
+	// Wrap transport with packet interceptor
+	wrappedTransport := NewInterceptedTransport(transport, c.onPacketSend, c.onPacketReceive)
+
+	var timer *time.Timer
+	var timedOut atomic.Bool
+	if c.options.Timeout > 0 {
+		timer = time.AfterFunc(time.Duration(c.options.Timeout)*time.Second, func() {
+			timedOut.Store(true)
+			wrappedTransport.Close()
+		})
 	}
 	
-	if c.conn == nil {
-		return fmt.Errorf("not connected")
+	client, jumps, detail, err := c.dialChain(wrappedTransport)
+	if timer != nil && !timer.Stop() && err == nil {
+		// The timer fired just as the handshake finished
+		client.Close()
+		for i := len(jumps) - 1; i >= 0; i-- {
+			jumps[i].Close()
+		}
+		err = errors.New("connection closed")
 	}
-	
-	// Create a new session
-	session, err := c.conn.NewSession()
 	if err != nil {
-		return fmt.Errorf("failed to create session: %v", err)
+		if timedOut.Load() {
+			err = fmt.Errorf("%w: no response from %s:%d within %d seconds", ErrHandshakeTimeout, detail.Host, detail.Port, c.options.Timeout)
+		}
+		return nil, nil, detail, err
+	}
+	return client, jumps, detail, nil
+}
+
+// dialChain connects to the target over conn, going through the jump hosts
+// first. On failure everything is closed and detail names the failing hop.
+func (c *Client) dialChain(conn net.Conn) (*ssh.Client, []*ssh.Client, StateDetail, error) {
+	// Each jump host carries a direct-tcpip channel to the next hop
+	var jumps []*ssh.Client
+	closeJumps := func() {
+		for i := len(jumps) - 1; i >= 0; i-- {
+			jumps[i].Close()
+		}
+	}
+	for i, jump := range c.options.JumpHosts {
+		detail := StateDetail{Hop: i + 1, Host: jump.Host, Port: jump.Port}
+		c.notifyState("jump-connecting", detail)
+
+		jumpClient, err := c.handshake(conn, jump, c.jumpHostKeys[i])
+		if err != nil {
+			conn.Close()
+			closeJumps()
+			return nil, nil, detail, fmt.Errorf("failed to connect to jump host %s:%d: %w", jump.Host, jump.Port, err)
+		}
+		jumps = append(jumps, jumpClient)
+
+		next := c.options
+		if i+1 < len(c.options.JumpHosts) {
+			next = c.options.JumpHosts[i+1]
+		}
+		conn, err = jumpClient.Dial("tcp", net.JoinHostPort(next.Host, strconv.Itoa(next.Port)))
+		if err != nil {
+			closeJumps()
+			return nil, nil, detail, fmt.Errorf("jump host %s:%d could not reach %s:%d: %v", jump.Host, jump.Port, next.Host, next.Port, err)
+		}
+
+		c.notifyState("jump-connected", detail)
 	}
-	c.session = session
 	
-	// Set up stdin pipe
-	stdin, err := session.StdinPipe()
+	// Only report a key the final server accepted
+	c.setAuthenticatedKey("")
+
+	detail := StateDetail{Host: c.options.Host, Port: c.options.Port}
+	client, err := c.handshake(conn, c.options, c.hostKeys)
 	if err != nil {
-		return fmt.Errorf("failed to create stdin pipe: %v", err)
+		conn.Close()
+		closeJumps()
+		return nil, nil, detail, fmt.Errorf("failed to establish SSH connection: %w", err)
+	}
+	return client, jumps, detail, nil
+}
+
+// handshake runs the SSH handshake and authentication with the server
+// described by options over conn
+func (c *Client) handshake(conn net.Conn, options ConnectionOptions, hostKeys *hostKeyVerifier) (*ssh.Client, error) {
+	config := &ssh.ClientConfig{
+		User:            options.User,
+		HostKeyCallback: hostKeys.Callback(),
 	}
 	
-	// Set up stdout pipe
-	stdout, err := session.StdoutPipe()
+	auth, err := c.authMethods(options)
 	if err != nil {
-		return fmt.Errorf("failed to create stdout pipe: %v", err)
+		return nil, err
+	}
+	config.Auth = auth
+
+	addr := fmt.Sprintf("%s:%d", options.Host, options.Port)
+
+	// Prefer host key types the known_hosts database can actually verify
+	if knownHosts := options.HostKey.KnownHosts; knownHosts != nil && len(options.HostKey.Fingerprints) == 0 {
+		config.HostKeyAlgorithms = knownHosts.HostKeyAlgorithms(addr)
 	}
 	
-	// Set up stderr pipe (combine with stdout)
-	stderr, err := session.StderrPipe()
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
 	if err != nil {
-		return fmt.Errorf("failed to create stderr pipe: %v", err)
+		return nil, err
 	}
+	return ssh.NewClient(sshConn, chans, reqs), nil
+}
+
+// StartShell opens the default shell used by Send and ResizeTerminal
+func (c *Client) StartShell() error {
+	c.shellMu.Lock()
+	defer c.shellMu.Unlock()
 	
-	// Request pseudo terminal
-	modes := ssh.TerminalModes{
-		ssh.ECHO:          1,     // enable echoing
-		ssh.TTY_OP_ISPEED: 14400, // input speed = 14.4kbaud
-		ssh.TTY_OP_OSPEED: 14400, // output speed = 14.4kbaud
+	c.mu.RLock()
+	started := c.shell != nil
+	pty := c.pty
+	options := SessionOptions{
+		ForwardAgent: c.options.ForwardAgent,
+		OnData:       c.onPacketReceive,
+		OnStderr:     c.onStderr,
 	}
+	c.mu.RUnlock()
 	
-	if err := session.RequestPty("xterm-256color", 24, 80, modes); err != nil {
-		return fmt.Errorf("request for pseudo terminal failed: %v", err)
+	if started {
+		return nil // Shell already started
 	}
 	
-	// Start the remote shell
-	if err := session.Shell(); err != nil {
-		return fmt.Errorf("failed to start shell: %v", err)
+	if !pty.Disabled {
+		options.PTY = &pty
 	}
 	
-	c.shellStarted = true
+	shell, err := c.OpenShell(options)
+	if err != nil {
+		return err
+	}
 	
-	// Start goroutine to handle stdin
-	go func() {
-		for data := range c.stdin {
-			stdin.Write(data)
-		}
-	}()
-	
-	// Start goroutine to handle stdout
-	go func() {
-		buf := make([]byte, 1024)
-		for {
-			n, err := stdout.Read(buf)
-			if err != nil {
-				break
-			}
-			if n > 0 && c.onPacketReceive != nil {
-				data := make([]byte, n)
-				copy(data, buf[:n])
-				metadata := map[string]interface{}{
-					"timestamp": time.Now().Unix(),
-					"type":      "data",
-					"direction": "receive",
-					"size":      n,
-				}
-				c.onPacketReceive(data, metadata)
-			}
-		}
-	}()
-	
-	// Start goroutine to handle stderr
-	go func() {
-		buf := make([]byte, 1024)
-		for {
-			n, err := stderr.Read(buf)
-			if err != nil {
-				break
-			}
-			if n > 0 && c.onPacketReceive != nil {
-				data := make([]byte, n)
-				copy(data, buf[:n])
-				metadata := map[string]interface{}{
-					"timestamp": time.Now().Unix(),
-					"type":      "data",
-					"direction": "receive",
-					"size":      n,
-				}
-				c.onPacketReceive(data, metadata)
-			}
-		}
-	}()
+	c.mu.Lock()
+	c.shell = shell
+	c.mu.Unlock()
 	
 	return nil
 }
 
+// Send writes data to the default shell, starting it on first use, and blocks
+// until the channel has accepted all of it
 func (c *Client) Send(data []byte) error {
+	return c.SendContext(context.Background(), data)
+}
+
+// SendContext is Send bounded by ctx
+func (c *Client) SendContext(ctx context.Context, data []byte) error {
+	shell, err := c.defaultShell()
+	if err != nil {
+		return err
+	}
+	return shell.SendContext(ctx, data)
+}
+
+// Queue adds data to the default shell's stdin without waiting for the
+// channel to accept it; see Session.Queue
+func (c *Client) Queue(data []byte) (<-chan error, error) {
+	return c.QueueContext(context.Background(), data)
+}
+
+// QueueContext is Queue bounded by ctx; see Session.QueueContext
+func (c *Client) QueueContext(ctx context.Context, data []byte) (<-chan error, error) {
+	shell, err := c.defaultShell()
+	if err != nil {
+		return nil, err
+	}
+	return shell.QueueContext(ctx, data)
+}
+
+// Buffered returns how many bytes sent to the default shell are not yet
+// accepted by the channel
+func (c *Client) Buffered() int {
 	c.mu.RLock()
-	defer c.mu.RUnlock()
+	shell := c.shell
+	c.mu.RUnlock()
 	
-	if !c.shellStarted {
-		// Start shell on first send
-		c.mu.RUnlock()
-		err := c.StartShell()
-		c.mu.RLock()
-		if err != nil {
-			return err
-		}
+	if shell == nil {
+		return 0
 	}
+	return shell.Buffered()
+}
+
+// Flush blocks until everything sent to the default shell has been accepted
+// by the channel
+func (c *Client) Flush(ctx context.Context) error {
+	c.mu.RLock()
+	shell := c.shell
+	c.mu.RUnlock()
 	
-	// Send data to stdin channel
-	select {
-	case c.stdin <- data:
-		if c.onPacketSend != nil {
-			metadata := map[string]interface{}{
-				"timestamp": time.Now().Unix(),
-				"type":      "data",
-				"direction": "send",
-				"size":      len(data),
-			}
-			c.onPacketSend(data, metadata)
-		}
+	if shell == nil {
 		return nil
-	default:
-		return fmt.Errorf("stdin buffer full")
 	}
+	return shell.Flush(ctx)
 }
 
-func (c *Client) ResizeTerminal(cols, rows int) error {
+// defaultShell returns the default shell, starting it on first use
+func (c *Client) defaultShell() (*Session, error) {
+	if err := c.StartShell(); err != nil {
+		return nil, err
+	}
+
 	c.mu.RLock()
-	defer c.mu.RUnlock()
-	
-	if c.session == nil {
-		return fmt.Errorf("no active session")
+	shell := c.shell
+	c.mu.RUnlock()
+
+	if shell == nil {
+		return nil, fmt.Errorf("not connected")
+	}
+	return shell, nil
+}
+
+func (c *Client) ResizeTerminal(cols, rows int) error {
+	return c.ResizeTerminalPixels(cols, rows, 0, 0)
+}
+
+// ResizeTerminalPixels changes the terminal size, including its size in pixels.
+// Before the shell starts it only records the size the PTY will be opened with.
+func (c *Client) ResizeTerminalPixels(cols, rows, width, height int) error {
+	c.mu.Lock()
+	c.pty.Cols = cols
+	c.pty.Rows = rows
+	c.pty.Width = width
+	c.pty.Height = height
+	shell := c.shell
+	c.mu.Unlock()
+
+	if shell == nil {
+		return nil
 	}
 	
-	return c.session.WindowChange(rows, cols)
+	// window-change goes over the network, so it is sent without holding c.mu
+	return shell.Resize(cols, rows, width, height)
 }
 
 func (c *Client) Disconnect() error {
 	c.notifyStateChange("disconnecting")
 	
+	// Listeners and forwarded channels unregister themselves, so close them before locking
+	c.mu.RLock()
+	listeners := make([]*RemoteListener, 0, len(c.listeners))
+	for _, listener := range c.listeners {
+		listeners = append(listeners, listener)
+	}
+	forwards := make([]*ForwardedChannel, 0, len(c.forwards))
+	for _, forward := range c.forwards {
+		forwards = append(forwards, forward)
+	}
+	c.mu.RUnlock()
+	for _, listener := range listeners {
+		listener.Close()
+	}
+	for _, forward := range forwards {
+		forward.Close()
+	}
+
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	
-	// Close stdin channel to stop goroutine
-	if c.stdin != nil {
-		close(c.stdin)
-		c.stdin = nil
+	if c.stopReconnect != nil {
+		close(c.stopReconnect)
+		c.stopReconnect = nil
 	}
 	
-	if c.session != nil {
-		c.session.Close()
-		c.session = nil
+	// Closing the sessions stops their stdin goroutines
+	for _, session := range c.channels {
+		session.Close()
+	}
+	c.shell = nil
+
+	if c.sftp != nil {
+		c.sftp.Close()
+		c.sftp = nil
 	}
 	
 	if c.conn != nil {
 		c.conn.Close()
 		c.conn = nil
 	}
+	for i := len(c.jumps) - 1; i >= 0; i-- {
+		c.jumps[i].Close()
+	}
+	c.jumps = nil
 	
-	c.shellStarted = false
+	c.agentForwarding = nil
 	
 	sessionsMu.Lock()
 	delete(sessions, c.sessionID)
@@ -312,8 +488,12 @@ func (c *Client) Disconnect() error {
 }
 
 func (c *Client) notifyStateChange(state string) {
+	c.notifyState(state, StateDetail{Host: c.options.Host, Port: c.options.Port})
+}
+
+func (c *Client) notifyState(state string, detail StateDetail) {
 	if c.onStateChange != nil {
-		c.onStateChange(state)
+		c.onStateChange(state, detail)
 	}
 }
 
@@ -341,6 +521,20 @@ func SendToSession(sessionID string, data []byte) error {
 	return client.Send(data)
 }
 
+// QueueToSession queues data for the default shell of a session, bounded by
+// ctx; see Client.QueueContext
+func QueueToSession(ctx context.Context, sessionID string, data []byte) (<-chan error, error) {
+	sessionsMu.RLock()
+	client, exists := sessions[sessionID]
+	sessionsMu.RUnlock()
+
+	if !exists {
+		return nil, fmt.Errorf("session not found: %s", sessionID)
+	}
+
+	return client.QueueContext(ctx, data)
+}
+
 func generateSessionID() string {
 	return fmt.Sprintf("ssh-%d", time.Now().UnixNano())
 }
\ No newline at end of file
@@ -0,0 +1,102 @@
+package sshclient
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// ExecOptions configures a remote command
+type ExecOptions struct {
+	// Stdin is sent to the command and followed by EOF
+	Stdin []byte
+	// OnStdout receives stdout as it arrives; without it stdout is collected in the result
+	OnStdout func(data []byte)
+	// OnStderr receives stderr as it arrives; without it stderr is collected in the result
+	OnStderr func(data []byte)
+}
+
+// ExecResult is the outcome of a remote command
+type ExecResult struct {
+	ExitCode int
+	// ExitSignal is the signal name without the SIG prefix when the command was killed
+	ExitSignal string
+	// ErrorMessage is the message the server sent along with the exit signal
+	ErrorMessage string
+	// ExitMissing is set when the server closed the channel without an exit status
+	ExitMissing bool
+	Stdout      []byte
+	Stderr      []byte
+}
+
+// Exec runs command in a new session and waits for it to finish. A non-zero
+// exit status is reported in the result rather than as an error.
+func (c *Client) Exec(command string, options ExecOptions) (ExecResult, error) {
+	c.mu.RLock()
+	conn := c.conn
+	c.mu.RUnlock()
+
+	if conn == nil {
+		return ExecResult{}, fmt.Errorf("not connected")
+	}
+
+	session, err := conn.NewSession()
+	if err != nil {
+		return ExecResult{}, fmt.Errorf("failed to create session: %v", err)
+	}
+	defer session.Close()
+
+	var stdout, stderr bytes.Buffer
+	session.Stdout = streamWriter(options.OnStdout, &stdout)
+	session.Stderr = streamWriter(options.OnStderr, &stderr)
+	if options.Stdin != nil {
+		session.Stdin = bytes.NewReader(options.Stdin)
+	}
+
+	result, err := exitResult(session.Run(command))
+	result.Stdout = stdout.Bytes()
+	result.Stderr = stderr.Bytes()
+	return result, err
+}
+
+// exitResult translates the error of Session.Wait into an exit status. Only
+// failures other than the command's own exit status are returned as errors.
+func exitResult(err error) (ExecResult, error) {
+	var result ExecResult
+	var exitErr *ssh.ExitError
+	var exitMissingErr *ssh.ExitMissingError
+	switch {
+	case err == nil:
+	case errors.As(err, &exitErr):
+		result.ExitCode = exitErr.ExitStatus()
+		result.ExitSignal = exitErr.Signal()
+		result.ErrorMessage = exitErr.Msg()
+	case errors.As(err, &exitMissingErr):
+		result.ExitCode = -1
+		result.ExitMissing = true
+	default:
+		return result, fmt.Errorf("failed to run command: %v", err)
+	}
+	return result, nil
+}
+
+// streamWriter forwards writes to callback, or buffers them when there is none
+func streamWriter(callback func([]byte), buf *bytes.Buffer) io.Writer {
+	if callback == nil {
+		return buf
+	}
+	return callbackWriter(callback)
+}
+
+// callbackWriter hands each write to a callback as a copy it may keep
+type callbackWriter func([]byte)
+
+func (w callbackWriter) Write(p []byte) (int, error) {
+	data := make([]byte, len(p))
+	copy(data, p)
+	w(data)
+	return len(p), nil
+}
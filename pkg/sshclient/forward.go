@@ -0,0 +1,185 @@
+package sshclient
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+)
+
+// ForwardedChannel is a direct-tcpip channel to a host reachable from the server
+type ForwardedChannel struct {
+	id      string
+	client  *Client
+	conn    net.Conn
+	onData  PacketCallback
+	onClose func(err error)
+	reading bool
+	closed  bool
+	done    chan struct{}
+	once    sync.Once
+	mu      sync.Mutex
+}
+
+// ForwardLocal opens a channel to host:port as seen from the server. Nothing
+// is read from the channel until OnData is set, so no data is lost before then.
+func (c *Client) ForwardLocal(host string, port int) (*ForwardedChannel, error) {
+	c.mu.RLock()
+	conn := c.conn
+	c.mu.RUnlock()
+
+	if conn == nil {
+		return nil, fmt.Errorf("not connected")
+	}
+
+	channel, err := conn.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open forwarded channel: %v", err)
+	}
+
+	return c.newForwardedChannel(channel), nil
+}
+
+// newForwardedChannel tracks conn on the client so Disconnect closes it
+func (c *Client) newForwardedChannel(conn net.Conn) *ForwardedChannel {
+	f := &ForwardedChannel{
+		id:     c.newChannelID(),
+		client: c,
+		conn:   conn,
+		done:   make(chan struct{}),
+	}
+
+	c.mu.Lock()
+	c.forwards[f.id] = f
+	c.mu.Unlock()
+
+	return f
+}
+
+// ID returns the channel identifier
+func (f *ForwardedChannel) ID() string {
+	return f.id
+}
+
+// RemoteAddr returns the target of a local forward, or the originator of a
+// connection accepted by a remote listener
+func (f *ForwardedChannel) RemoteAddr() net.Addr {
+	return f.conn.RemoteAddr()
+}
+
+// OnData sets the callback for data from the remote end and starts reading
+func (f *ForwardedChannel) OnData(callback PacketCallback) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.onData = callback
+	if !f.reading && !f.closed {
+		f.reading = true
+		go f.read()
+	}
+}
+
+// OnClose sets the callback called once the channel has closed, with the
+// error that closed it or nil
+func (f *ForwardedChannel) OnClose(callback func(err error)) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.onClose = callback
+}
+
+func (f *ForwardedChannel) read() {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := f.conn.Read(buf)
+		if n > 0 {
+			data := make([]byte, n)
+			copy(data, buf[:n])
+
+			f.mu.Lock()
+			onData := f.onData
+			f.mu.Unlock()
+
+			if onData != nil {
+				onData(data, channelMetadata(f.id, "receive", n))
+			}
+		}
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+				err = nil
+			}
+			f.finish(err)
+			return
+		}
+	}
+}
+
+// Send writes data to the remote end, blocking while the channel window is full
+func (f *ForwardedChannel) Send(data []byte) error {
+	f.mu.Lock()
+	closed := f.closed
+	f.mu.Unlock()
+
+	if closed {
+		return fmt.Errorf("channel closed")
+	}
+
+	if _, err := f.conn.Write(data); err != nil {
+		return fmt.Errorf("failed to write to forwarded channel: %v", err)
+	}
+
+	f.client.mu.RLock()
+	onPacketSend := f.client.onPacketSend
+	f.client.mu.RUnlock()
+
+	if onPacketSend != nil {
+		onPacketSend(data, channelMetadata(f.id, "send", len(data)))
+	}
+	return nil
+}
+
+// Close closes the channel
+func (f *ForwardedChannel) Close() error {
+	f.mu.Lock()
+	reading := f.reading
+	f.closed = true
+	f.mu.Unlock()
+
+	err := f.conn.Close()
+
+	// Without a read loop nobody else observes the close
+	if !reading {
+		f.finish(nil)
+	}
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+	return err
+}
+
+// Done is closed once the channel has closed
+func (f *ForwardedChannel) Done() <-chan struct{} {
+	return f.done
+}
+
+func (f *ForwardedChannel) finish(err error) {
+	f.once.Do(func() {
+		f.mu.Lock()
+		f.closed = true
+		onClose := f.onClose
+		f.mu.Unlock()
+
+		f.conn.Close()
+
+		f.client.mu.Lock()
+		delete(f.client.forwards, f.id)
+		f.client.mu.Unlock()
+
+		close(f.done)
+
+		if onClose != nil {
+			onClose(err)
+		}
+	})
+}
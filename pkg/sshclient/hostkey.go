@@ -0,0 +1,240 @@
+package sshclient
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// HostKeyDecision is the verdict returned by a HostKeyPrompt
+type HostKeyDecision int
+
+const (
+	// HostKeyReject aborts the connection
+	HostKeyReject HostKeyDecision = iota
+	// HostKeyAccept trusts the key for the lifetime of the client
+	HostKeyAccept
+	// HostKeyAcceptOnce trusts the key for the current handshake only
+	HostKeyAcceptOnce
+)
+
+// ParseHostKeyDecision converts "accept", "accept-once" or "reject" into a HostKeyDecision
+func ParseHostKeyDecision(s string) (HostKeyDecision, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "accept":
+		return HostKeyAccept, nil
+	case "accept-once":
+		return HostKeyAcceptOnce, nil
+	case "reject", "":
+		return HostKeyReject, nil
+	default:
+		return HostKeyReject, fmt.Errorf("unknown host key decision: %q", s)
+	}
+}
+
+// HostKeyInfo describes a server host key presented during the handshake
+type HostKeyInfo struct {
+	Hostname    string
+	RemoteAddr  string
+	KeyType     string
+	Fingerprint string
+	Key         []byte
+}
+
+// HostKeyPrompt is asked to decide on host keys that no other policy source vouches for
+type HostKeyPrompt func(info HostKeyInfo) (HostKeyDecision, error)
+
+// HostKeyPolicy configures how server host keys are verified.
+// Keys are checked against Fingerprints first, then KnownHosts, and finally
+// Prompt is consulted. A key that none of them accept is rejected.
+type HostKeyPolicy struct {
+	// Fingerprints lists pinned SHA256 fingerprints ("SHA256:..." as printed by ssh-keygen -l)
+	Fingerprints []string
+	// KnownHosts is a known_hosts database; accepted prompts are recorded in it
+	KnownHosts *KnownHosts
+	// Prompt is called for keys that are not pinned or known
+	Prompt HostKeyPrompt
+	// InsecureIgnoreHostKey disables verification entirely
+	InsecureIgnoreHostKey bool
+}
+
+// HostKeyRejectReason explains why a host key was not accepted
+type HostKeyRejectReason string
+
+const (
+	// HostKeyUnknown means no policy source knows the key and nobody was asked
+	HostKeyUnknown HostKeyRejectReason = "unknown"
+	// HostKeyMismatch means the host is known with a different key
+	HostKeyMismatch HostKeyRejectReason = "mismatch"
+	// HostKeyRejected means the prompt callback rejected the key
+	HostKeyRejected HostKeyRejectReason = "rejected"
+	// HostKeyRevoked means the key is listed as @revoked
+	HostKeyRevoked HostKeyRejectReason = "revoked"
+)
+
+// HostKeyError is returned when the server host key fails verification
+type HostKeyError struct {
+	Reason      HostKeyRejectReason
+	Hostname    string
+	KeyType     string
+	Fingerprint string
+	Err         error
+}
+
+func (e *HostKeyError) Error() string {
+	msg := fmt.Sprintf("host key verification failed for %s (%s %s): %s", e.Hostname, e.KeyType, e.Fingerprint, e.Reason)
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *HostKeyError) Unwrap() error {
+	return e.Err
+}
+
+// hostKeyVerifier applies a HostKeyPolicy and remembers keys accepted for the client lifetime
+type hostKeyVerifier struct {
+	policy       HostKeyPolicy
+	fingerprints map[string]bool
+	// accepted is keyed by hostname, so a key accepted for one jump hop
+	// is not trusted for the others
+	accepted map[string][]ssh.PublicKey
+	mu       sync.Mutex
+}
+
+func newHostKeyVerifier(policy HostKeyPolicy) *hostKeyVerifier {
+	v := &hostKeyVerifier{
+		policy:       policy,
+		fingerprints: make(map[string]bool),
+		accepted:     make(map[string][]ssh.PublicKey),
+	}
+	for _, fp := range policy.Fingerprints {
+		if fp = normalizeFingerprint(fp); fp != "" {
+			v.fingerprints[fp] = true
+		}
+	}
+	return v
+}
+
+// Callback returns an ssh.HostKeyCallback enforcing the policy
+func (v *hostKeyVerifier) Callback() ssh.HostKeyCallback {
+	if v.policy.InsecureIgnoreHostKey {
+		return ssh.InsecureIgnoreHostKey()
+	}
+	return v.verify
+}
+
+func (v *hostKeyVerifier) verify(hostname string, remote net.Addr, key ssh.PublicKey) error {
+	fingerprint := ssh.FingerprintSHA256(key)
+	hostErr := func(reason HostKeyRejectReason, err error) error {
+		return &HostKeyError{
+			Reason:      reason,
+			Hostname:    hostname,
+			KeyType:     key.Type(),
+			Fingerprint: fingerprint,
+			Err:         err,
+		}
+	}
+
+	v.mu.Lock()
+	for _, k := range v.accepted[hostname] {
+		if keysEqual(k, key) {
+			v.mu.Unlock()
+			return nil
+		}
+	}
+	v.mu.Unlock()
+
+	if v.fingerprints[fingerprint] {
+		return nil
+	}
+
+	if v.policy.KnownHosts != nil {
+		err := v.policy.KnownHosts.Check(hostname, remote, key)
+		var hostKeyErr *HostKeyError
+		if err == nil || !errors.As(err, &hostKeyErr) || hostKeyErr.Reason != HostKeyUnknown {
+			return err
+		}
+	}
+
+	if v.policy.Prompt == nil {
+		if len(v.fingerprints) > 0 {
+			return hostErr(HostKeyMismatch, nil)
+		}
+		return hostErr(HostKeyUnknown, nil)
+	}
+
+	remoteAddr := ""
+	if remote != nil {
+		remoteAddr = remote.String()
+	}
+	decision, err := v.policy.Prompt(HostKeyInfo{
+		Hostname:    hostname,
+		RemoteAddr:  remoteAddr,
+		KeyType:     key.Type(),
+		Fingerprint: fingerprint,
+		Key:         key.Marshal(),
+	})
+	if err != nil {
+		return hostErr(HostKeyRejected, err)
+	}
+
+	switch decision {
+	case HostKeyAccept:
+		v.mu.Lock()
+		v.accepted[hostname] = append(v.accepted[hostname], key)
+		v.mu.Unlock()
+		if v.policy.KnownHosts != nil {
+			if _, err := v.policy.KnownHosts.Learn(hostname, key); err != nil {
+				return hostErr(HostKeyRejected, err)
+			}
+		}
+		return nil
+	case HostKeyAcceptOnce:
+		return nil
+	default:
+		return hostErr(HostKeyRejected, nil)
+	}
+}
+
+type knownHostResult int
+
+const (
+	knownHostNone knownHostResult = iota
+	knownHostMatch
+	knownHostMismatch
+)
+
+// knownHostsAddress formats a host:port address the way known_hosts stores it
+func knownHostsAddress(hostname string) string {
+	host, port, err := net.SplitHostPort(hostname)
+	if err != nil {
+		return hostname
+	}
+	if p, err := strconv.Atoi(port); err == nil && p == 22 {
+		return host
+	}
+	return "[" + host + "]:" + port
+}
+
+func normalizeFingerprint(fp string) string {
+	fp = strings.TrimRight(strings.TrimSpace(fp), "=")
+	if fp == "" {
+		return ""
+	}
+	if !strings.HasPrefix(fp, "SHA256:") {
+		fp = "SHA256:" + fp
+	}
+	return fp
+}
+
+func keysEqual(a, b ssh.PublicKey) bool {
+	return a.Type() == b.Type() && bytes.Equal(a.Marshal(), b.Marshal())
+}
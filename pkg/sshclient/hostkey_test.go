@@ -0,0 +1,82 @@
+package sshclient
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func newTestSigner(t *testing.T) ssh.Signer {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+func hostKeyReason(err error) HostKeyRejectReason {
+	var hostKeyErr *HostKeyError
+	if errors.As(err, &hostKeyErr) {
+		return hostKeyErr.Reason
+	}
+	return ""
+}
+
+func TestHostKeyVerifierRemembersAcceptedKeysPerHost(t *testing.T) {
+	key := newTestSigner(t).PublicKey()
+	var prompts []string
+	decision := HostKeyAccept
+	v := newHostKeyVerifier(HostKeyPolicy{
+		Prompt: func(info HostKeyInfo) (HostKeyDecision, error) {
+			prompts = append(prompts, info.Hostname)
+			return decision, nil
+		},
+	})
+
+	if err := v.verify("bastion:22", nil, key); err != nil {
+		t.Fatalf("accepting bastion: %v", err)
+	}
+	if err := v.verify("bastion:22", nil, key); err != nil {
+		t.Fatalf("accepted key for bastion: %v", err)
+	}
+	if len(prompts) != 1 {
+		t.Fatalf("prompted %v, want only the first bastion connection", prompts)
+	}
+
+	// The same key on another host must be asked about again
+	decision = HostKeyReject
+	if err := v.verify("internal:22", nil, key); hostKeyReason(err) != HostKeyRejected {
+		t.Errorf("key accepted for bastion on internal = %v, want rejected", err)
+	}
+	if len(prompts) != 2 || prompts[1] != "internal:22" {
+		t.Errorf("prompted %v, want a prompt for internal:22", prompts)
+	}
+}
+
+func TestHostKeyVerifierAcceptOnce(t *testing.T) {
+	key := newTestSigner(t).PublicKey()
+	prompts := 0
+	v := newHostKeyVerifier(HostKeyPolicy{
+		Prompt: func(info HostKeyInfo) (HostKeyDecision, error) {
+			prompts++
+			return HostKeyAcceptOnce, nil
+		},
+	})
+
+	for i := 0; i < 2; i++ {
+		if err := v.verify("example.com:22", nil, key); err != nil {
+			t.Fatalf("verify: %v", err)
+		}
+	}
+	if prompts != 2 {
+		t.Errorf("prompted %d times, want every handshake", prompts)
+	}
+}
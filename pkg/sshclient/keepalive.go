@@ -0,0 +1,70 @@
+package sshclient
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	keepaliveRequest = "keepalive@openssh.com"
+	// defaultKeepaliveCountMax matches the default of OpenSSH's ServerAliveCountMax
+	defaultKeepaliveCountMax = 3
+)
+
+// KeepaliveOptions configures keepalive probes, like OpenSSH's
+// ServerAliveInterval and ServerAliveCountMax
+type KeepaliveOptions struct {
+	// Interval is the time between probes in seconds; 0 disables keepalive
+	Interval int
+	// CountMax is how many probes may go unanswered before the connection
+	// is considered dead, 3 by default
+	CountMax int
+}
+
+// startKeepalive probes conn until it closes. Any reply, even a refusal,
+// proves the server is alive. When CountMax probes in a row go unanswered
+// the connection is treated as lost.
+func (c *Client) startKeepalive(conn *ssh.Client) {
+	options := c.options.Keepalive
+	if options.Interval <= 0 {
+		return
+	}
+	countMax := options.CountMax
+	if countMax <= 0 {
+		countMax = defaultKeepaliveCountMax
+	}
+	interval := time.Duration(options.Interval) * time.Second
+
+	closed := make(chan struct{})
+	go func() {
+		conn.Wait()
+		close(closed)
+	}()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		var missed atomic.Int32
+		for {
+			select {
+			case <-closed:
+				return
+			case <-ticker.C:
+			}
+
+			if int(missed.Add(1)) > countMax {
+				c.connectionLost(conn, fmt.Sprintf("no keepalive response from %s:%d after %d probes %v apart", c.options.Host, c.options.Port, countMax, interval))
+				return
+			}
+			go func() {
+				if _, _, err := conn.SendRequest(keepaliveRequest, true, nil); err == nil {
+					missed.Store(0)
+				}
+			}()
+		}
+	}()
+}
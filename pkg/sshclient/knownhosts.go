@@ -0,0 +1,523 @@
+package sshclient
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// MarkerCertAuthority marks a known_hosts line as a trusted host CA
+	MarkerCertAuthority = "cert-authority"
+	// MarkerRevoked marks a known_hosts line as a revoked key
+	MarkerRevoked = "revoked"
+)
+
+// KnownHostEntry is a single key line of a known_hosts database
+type KnownHostEntry struct {
+	Marker      string
+	Hosts       []string
+	KeyType     string
+	Fingerprint string
+	Comment     string
+	Line        string
+}
+
+// KnownHostsOptions configures a KnownHosts database
+type KnownHostsOptions struct {
+	// TrustOnFirstUse adds keys of hosts that have no entry yet instead of rejecting them
+	TrustOnFirstUse bool
+	// HashHostnames stores newly added hosts in the hashed |1| form
+	HashHostnames bool
+	// OnLearn is called with every entry added through TOFU or an accepted prompt
+	OnLearn func(entry KnownHostEntry)
+}
+
+// KnownHosts is an in-memory OpenSSH known_hosts database.
+// The browser has no ~/.ssh, so the content is loaded from and serialized to
+// text that the caller persists wherever it likes.
+type KnownHosts struct {
+	id      string
+	options KnownHostsOptions
+	lines   []knownHostsLine
+	mu      sync.RWMutex
+}
+
+// knownHostsLine keeps the original text so serialization round-trips comments and formatting
+type knownHostsLine struct {
+	raw      string
+	marker   string
+	patterns []string
+	key      ssh.PublicKey
+	comment  string
+}
+
+// NewKnownHosts creates an empty known_hosts database
+func NewKnownHosts(options KnownHostsOptions) *KnownHosts {
+	return &KnownHosts{
+		id:      fmt.Sprintf("known-hosts-%d", time.Now().UnixNano()),
+		options: options,
+	}
+}
+
+// ParseKnownHosts creates a database from known_hosts text
+func ParseKnownHosts(data string, options KnownHostsOptions) (*KnownHosts, error) {
+	kh := NewKnownHosts(options)
+	if err := kh.Load(data); err != nil {
+		return nil, err
+	}
+	return kh, nil
+}
+
+// ID returns the identifier the database is registered under
+func (kh *KnownHosts) ID() string {
+	return kh.id
+}
+
+// Load appends the lines of known_hosts text to the database
+func (kh *KnownHosts) Load(data string) error {
+	var parsed []knownHostsLine
+	for i, raw := range strings.Split(strings.ReplaceAll(data, "\r\n", "\n"), "\n") {
+		line, err := parseKnownHostsLine(raw)
+		if err != nil {
+			return fmt.Errorf("known_hosts line %d: %v", i+1, err)
+		}
+		parsed = append(parsed, line)
+	}
+
+	// Drop the empty line produced by a trailing newline
+	if n := len(parsed); n > 0 && parsed[n-1].raw == "" {
+		parsed = parsed[:n-1]
+	}
+
+	kh.mu.Lock()
+	defer kh.mu.Unlock()
+	kh.lines = append(kh.lines, parsed...)
+	return nil
+}
+
+// Serialize renders the database as known_hosts text
+func (kh *KnownHosts) Serialize() string {
+	kh.mu.RLock()
+	defer kh.mu.RUnlock()
+
+	var b strings.Builder
+	for _, line := range kh.lines {
+		b.WriteString(line.raw)
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// Entries returns all key lines in file order
+func (kh *KnownHosts) Entries() []KnownHostEntry {
+	kh.mu.RLock()
+	defer kh.mu.RUnlock()
+
+	var entries []KnownHostEntry
+	for _, line := range kh.lines {
+		if line.key != nil {
+			entries = append(entries, line.entry())
+		}
+	}
+	return entries
+}
+
+// Add records key for hostname, an address in host:port form or a bare host
+func (kh *KnownHosts) Add(hostname string, key ssh.PublicKey) (KnownHostEntry, error) {
+	return kh.addLine("", hostname, key)
+}
+
+// AddMarked records a @cert-authority or @revoked line for a host pattern
+func (kh *KnownHosts) AddMarked(marker, pattern string, key ssh.PublicKey) (KnownHostEntry, error) {
+	if marker != MarkerCertAuthority && marker != MarkerRevoked {
+		return KnownHostEntry{}, fmt.Errorf("unknown known_hosts marker: %q", marker)
+	}
+	return kh.addLine(marker, pattern, key)
+}
+
+func (kh *KnownHosts) addLine(marker, hostname string, key ssh.PublicKey) (KnownHostEntry, error) {
+	pattern := knownHostsAddress(hostname)
+	if kh.options.HashHostnames && marker == "" {
+		hashed, err := hashHostname(pattern)
+		if err != nil {
+			return KnownHostEntry{}, err
+		}
+		pattern = hashed
+	}
+
+	raw := pattern + " " + strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+	if marker != "" {
+		raw = "@" + marker + " " + raw
+	}
+	line := knownHostsLine{
+		raw:      raw,
+		marker:   marker,
+		patterns: []string{pattern},
+		key:      key,
+	}
+
+	kh.mu.Lock()
+	kh.lines = append(kh.lines, line)
+	kh.mu.Unlock()
+
+	return line.entry(), nil
+}
+
+// Remove deletes the unmarked entries matching hostname and returns how many were removed
+func (kh *KnownHosts) Remove(hostname string) int {
+	host := knownHostsAddress(hostname)
+
+	kh.mu.Lock()
+	defer kh.mu.Unlock()
+
+	kept := kh.lines[:0]
+	removed := 0
+	for _, line := range kh.lines {
+		if line.key != nil && line.marker == "" && line.matches(host) {
+			removed++
+			continue
+		}
+		kept = append(kept, line)
+	}
+	kh.lines = kept
+	return removed
+}
+
+// HostKeyAlgorithms returns the host key algorithms in preference order:
+// the key types known for hostname first, so the server offers a key that is
+// actually verifiable, then every other algorithm, as OpenSSH does. Keeping
+// the rest lets a changed or unknown key still reach verification instead of
+// failing negotiation. It returns nil when nothing is known for hostname.
+func (kh *KnownHosts) HostKeyAlgorithms(hostname string) []string {
+	host := knownHostsAddress(hostname)
+
+	kh.mu.RLock()
+	defer kh.mu.RUnlock()
+
+	seen := make(map[string]bool)
+	var algos []string
+	add := func(algo string) {
+		if !seen[algo] {
+			seen[algo] = true
+			algos = append(algos, algo)
+		}
+	}
+	for _, line := range kh.lines {
+		if line.key == nil || line.marker == MarkerRevoked || !line.matches(host) {
+			continue
+		}
+		if line.marker == MarkerCertAuthority {
+			for _, algo := range certAlgorithms {
+				add(algo)
+			}
+			continue
+		}
+		for _, algo := range algorithmsForKeyType(line.key.Type()) {
+			add(algo)
+		}
+	}
+	if len(algos) == 0 {
+		return nil
+	}
+
+	for _, algo := range ssh.SupportedAlgorithms().HostKeys {
+		add(algo)
+	}
+	for _, algo := range ssh.InsecureAlgorithms().HostKeys {
+		add(algo)
+	}
+	return algos
+}
+
+// HostKeyCallback returns the database as an ssh.HostKeyCallback
+func (kh *KnownHosts) HostKeyCallback() ssh.HostKeyCallback {
+	return kh.Check
+}
+
+// Check verifies key for hostname. It fails with a *HostKeyError whose
+// reason is HostKeyUnknown when the host has no entry and trust on first use
+// is off, so callers can fall back to asking the user.
+func (kh *KnownHosts) Check(hostname string, remote net.Addr, key ssh.PublicKey) error {
+	host := knownHostsAddress(hostname)
+	hostErr := func(reason HostKeyRejectReason, err error) error {
+		return &HostKeyError{
+			Reason:      reason,
+			Hostname:    hostname,
+			KeyType:     key.Type(),
+			Fingerprint: ssh.FingerprintSHA256(key),
+			Err:         err,
+		}
+	}
+
+	if kh.isRevoked(host, key) {
+		return hostErr(HostKeyRevoked, nil)
+	}
+
+	if cert, ok := key.(*ssh.Certificate); ok {
+		if kh.isRevoked(host, cert.SignatureKey) {
+			return hostErr(HostKeyRevoked, errors.New("certificate authority is revoked"))
+		}
+		if kh.isRevoked(host, cert.Key) {
+			return hostErr(HostKeyRevoked, errors.New("certified key is revoked"))
+		}
+		if kh.isHostAuthority(host, cert.SignatureKey) {
+			checker := &ssh.CertChecker{
+				IsHostAuthority: func(auth ssh.PublicKey, _ string) bool {
+					return kh.isHostAuthority(host, auth)
+				},
+			}
+			if err := checker.CheckHostKey(hostname, remote, key); err != nil {
+				return hostErr(HostKeyRejected, err)
+			}
+			return nil
+		}
+		// Like OpenSSH, fall back to the certified key when no CA vouches for it
+		key = cert.Key
+	}
+
+	switch kh.lookup(host, key) {
+	case knownHostMatch:
+		return nil
+	case knownHostMismatch:
+		return hostErr(HostKeyMismatch, nil)
+	}
+
+	if kh.options.TrustOnFirstUse {
+		if _, err := kh.Learn(hostname, key); err != nil {
+			return hostErr(HostKeyUnknown, err)
+		}
+		return nil
+	}
+
+	return hostErr(HostKeyUnknown, nil)
+}
+
+// Learn adds key for hostname and reports it through OnLearn
+func (kh *KnownHosts) Learn(hostname string, key ssh.PublicKey) (KnownHostEntry, error) {
+	entry, err := kh.Add(hostname, key)
+	if err != nil {
+		return entry, err
+	}
+	if kh.options.OnLearn != nil {
+		kh.options.OnLearn(entry)
+	}
+	return entry, nil
+}
+
+func (kh *KnownHosts) lookup(host string, key ssh.PublicKey) knownHostResult {
+	kh.mu.RLock()
+	defer kh.mu.RUnlock()
+
+	result := knownHostNone
+	for _, line := range kh.lines {
+		if line.key == nil || line.marker != "" || !line.matches(host) {
+			continue
+		}
+		if keysEqual(line.key, key) {
+			return knownHostMatch
+		}
+		result = knownHostMismatch
+	}
+	return result
+}
+
+func (kh *KnownHosts) isRevoked(host string, key ssh.PublicKey) bool {
+	return kh.hasMarked(MarkerRevoked, host, key)
+}
+
+func (kh *KnownHosts) isHostAuthority(host string, key ssh.PublicKey) bool {
+	return kh.hasMarked(MarkerCertAuthority, host, key)
+}
+
+func (kh *KnownHosts) hasMarked(marker, host string, key ssh.PublicKey) bool {
+	kh.mu.RLock()
+	defer kh.mu.RUnlock()
+
+	for _, line := range kh.lines {
+		if line.marker == marker && line.key != nil && keysEqual(line.key, key) && line.matches(host) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseKnownHostsLine(raw string) (knownHostsLine, error) {
+	line := knownHostsLine{raw: strings.TrimRight(raw, "\r")}
+
+	trimmed := strings.TrimSpace(line.raw)
+	if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+		return line, nil
+	}
+
+	marker, hosts, key, comment, _, err := ssh.ParseKnownHosts([]byte(trimmed))
+	if err != nil {
+		return line, err
+	}
+	if marker != "" && marker != MarkerCertAuthority && marker != MarkerRevoked {
+		return line, fmt.Errorf("unknown marker @%s", marker)
+	}
+
+	line.marker = marker
+	line.patterns = hosts
+	line.key = key
+	line.comment = comment
+	return line, nil
+}
+
+func (l knownHostsLine) entry() KnownHostEntry {
+	return KnownHostEntry{
+		Marker:      l.marker,
+		Hosts:       append([]string(nil), l.patterns...),
+		KeyType:     l.key.Type(),
+		Fingerprint: ssh.FingerprintSHA256(l.key),
+		Comment:     l.comment,
+		Line:        l.raw,
+	}
+}
+
+// matches applies the line's host patterns, where any negated pattern that
+// matches excludes the host regardless of the others
+func (l knownHostsLine) matches(host string) bool {
+	host = strings.ToLower(host)
+	matched := false
+	for _, pattern := range l.patterns {
+		negate := strings.HasPrefix(pattern, "!")
+		if negate {
+			pattern = pattern[1:]
+		}
+		if !matchHostPattern(pattern, host) {
+			continue
+		}
+		if negate {
+			return false
+		}
+		matched = true
+	}
+	return matched
+}
+
+func matchHostPattern(pattern, host string) bool {
+	if strings.HasPrefix(pattern, "|1|") {
+		return matchHashedHost(pattern, host)
+	}
+	return wildcardMatch(strings.ToLower(pattern), host)
+}
+
+// matchHashedHost checks a |1|salt|hash entry written by ssh-keygen -H
+func matchHashedHost(pattern, host string) bool {
+	parts := strings.Split(pattern[len("|1|"):], "|")
+	if len(parts) != 2 {
+		return false
+	}
+	salt, err := base64.StdEncoding.DecodeString(parts[0])
+	if err != nil {
+		return false
+	}
+	want, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha1.New, salt)
+	mac.Write([]byte(host))
+	return hmac.Equal(mac.Sum(nil), want)
+}
+
+func hashHostname(host string) (string, error) {
+	salt := make([]byte, sha1.Size)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("failed to generate salt: %v", err)
+	}
+	mac := hmac.New(sha1.New, salt)
+	mac.Write([]byte(host))
+	return "|1|" + base64.StdEncoding.EncodeToString(salt) + "|" + base64.StdEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+// wildcardMatch implements the * and ? globbing of OpenSSH host patterns
+func wildcardMatch(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			pattern = pattern[1:]
+			if pattern == "" {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if wildcardMatch(pattern, s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if s == "" {
+				return false
+			}
+		default:
+			if s == "" || s[0] != pattern[0] {
+				return false
+			}
+		}
+		pattern = pattern[1:]
+		s = s[1:]
+	}
+	return s == ""
+}
+
+var certAlgorithms = []string{
+	ssh.CertAlgoED25519v01,
+	ssh.CertAlgoECDSA256v01,
+	ssh.CertAlgoECDSA384v01,
+	ssh.CertAlgoECDSA521v01,
+	ssh.CertAlgoRSASHA512v01,
+	ssh.CertAlgoRSASHA256v01,
+	ssh.CertAlgoRSAv01,
+}
+
+// algorithmsForKeyType expands an RSA key into the signature algorithms that can verify it
+func algorithmsForKeyType(keyType string) []string {
+	if keyType == ssh.KeyAlgoRSA {
+		return []string{ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA}
+	}
+	return []string{keyType}
+}
+
+// KnownHostsManager manages known_hosts databases shared with JavaScript
+type KnownHostsManager struct {
+	databases map[string]*KnownHosts
+	mu        sync.RWMutex
+}
+
+var knownHostsManager = &KnownHostsManager{
+	databases: make(map[string]*KnownHosts),
+}
+
+// RegisterKnownHosts registers a database under its ID
+func RegisterKnownHosts(kh *KnownHosts) {
+	knownHostsManager.mu.Lock()
+	defer knownHostsManager.mu.Unlock()
+	knownHostsManager.databases[kh.id] = kh
+}
+
+// GetKnownHosts retrieves a database by ID
+func GetKnownHosts(id string) (*KnownHosts, bool) {
+	knownHostsManager.mu.RLock()
+	defer knownHostsManager.mu.RUnlock()
+	kh, ok := knownHostsManager.databases[id]
+	return kh, ok
+}
+
+// RemoveKnownHosts removes a database
+func RemoveKnownHosts(id string) {
+	knownHostsManager.mu.Lock()
+	defer knownHostsManager.mu.Unlock()
+	delete(knownHostsManager.databases, id)
+}
@@ -0,0 +1,380 @@
+package sshclient
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func newTestECDSAKey(t *testing.T) ssh.PublicKey {
+	t.Helper()
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.NewPublicKey(&priv.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// newTestCert certifies key as a host key for principals, signed by ca
+func newTestCert(t *testing.T, ca ssh.Signer, key ssh.PublicKey, principals ...string) *ssh.Certificate {
+	t.Helper()
+	cert := &ssh.Certificate{
+		Key:             key,
+		CertType:        ssh.HostCert,
+		ValidPrincipals: principals,
+		ValidBefore:     ssh.CertTimeInfinity,
+	}
+	if err := cert.SignCert(rand.Reader, ca); err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func knownHostsLineFor(pattern string, key ssh.PublicKey) string {
+	return pattern + " " + strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+}
+
+func TestKnownHostsHostMatching(t *testing.T) {
+	key := newTestSigner(t).PublicKey()
+	hashed, err := hashHostname("hashed.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashedPort, err := hashHostname("[hashed.example.com]:2222")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		pattern  string
+		hostname string
+		want     bool
+	}{
+		{"plain", "example.com", "example.com:22", true},
+		{"plain is case insensitive", "Example.COM", "example.com:22", true},
+		{"plain other host", "example.com", "example.org:22", false},
+		{"default port is plain", "example.com", "example.com", true},
+		{"non-default port", "[example.com]:2222", "example.com:2222", true},
+		{"non-default port needs brackets", "example.com", "example.com:2222", false},
+		{"bracketed port differs", "[example.com]:2222", "example.com:2200", false},
+		{"comma list", "a.example.com,b.example.com", "b.example.com:22", true},
+		{"star", "*.example.com", "host.example.com:22", true},
+		{"star does not match bare domain", "*.example.com", "example.com:22", false},
+		{"question mark", "host?.example.com", "host1.example.com:22", true},
+		{"question mark needs a character", "host?.example.com", "host.example.com:22", false},
+		{"negation excludes", "*.example.com,!bad.example.com", "bad.example.com:22", false},
+		{"negation keeps others", "*.example.com,!bad.example.com", "good.example.com:22", true},
+		{"negation alone never matches", "!bad.example.com", "good.example.com:22", false},
+		{"hashed", hashed, "hashed.example.com:22", true},
+		{"hashed other host", hashed, "other.example.com:22", false},
+		{"hashed with port", hashedPort, "hashed.example.com:2222", true},
+		{"hashed with port needs the port", hashedPort, "hashed.example.com:22", false},
+		{"malformed hash", "|1|not-base64|x", "example.com:22", false},
+		{"IPv6 with port", "[::1]:2222", "[::1]:2222", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kh, err := ParseKnownHosts(knownHostsLineFor(tt.pattern, key), KnownHostsOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = kh.Check(tt.hostname, nil, key)
+			if got := err == nil; got != tt.want {
+				t.Errorf("Check(%q) against %q = %v, want match %v", tt.hostname, tt.pattern, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestKnownHostsCheck(t *testing.T) {
+	hostKey := newTestSigner(t).PublicKey()
+	otherKey := newTestSigner(t).PublicKey()
+	ca := newTestSigner(t)
+	otherCA := newTestSigner(t)
+	cert := newTestCert(t, ca, hostKey, "example.com")
+	untrustedCert := newTestCert(t, otherCA, hostKey, "example.com")
+	wrongPrincipal := newTestCert(t, ca, hostKey, "other.example.com")
+
+	tests := []struct {
+		name  string
+		lines []string
+		key   ssh.PublicKey
+		want  HostKeyRejectReason
+	}{
+		{
+			name:  "known key",
+			lines: []string{knownHostsLineFor("example.com", hostKey)},
+			key:   hostKey,
+		},
+		{
+			name: "unknown host",
+			key:  hostKey,
+			want: HostKeyUnknown,
+		},
+		{
+			name:  "changed key",
+			lines: []string{knownHostsLineFor("example.com", otherKey)},
+			key:   hostKey,
+			want:  HostKeyMismatch,
+		},
+		{
+			name: "any of several keys",
+			lines: []string{
+				knownHostsLineFor("example.com", otherKey),
+				knownHostsLineFor("example.com", hostKey),
+			},
+			key: hostKey,
+		},
+		{
+			name: "revoked key",
+			lines: []string{
+				"@revoked " + knownHostsLineFor("*", hostKey),
+				knownHostsLineFor("example.com", hostKey),
+			},
+			key:  hostKey,
+			want: HostKeyRevoked,
+		},
+		{
+			name: "revoked key for another host",
+			lines: []string{
+				"@revoked " + knownHostsLineFor("other.example.com", hostKey),
+				knownHostsLineFor("example.com", hostKey),
+			},
+			key: hostKey,
+		},
+		{
+			name:  "certificate from trusted CA",
+			lines: []string{"@cert-authority " + knownHostsLineFor("*.com", ca.PublicKey())},
+			key:   cert,
+		},
+		{
+			name:  "certificate for another principal",
+			lines: []string{"@cert-authority " + knownHostsLineFor("*.com", ca.PublicKey())},
+			key:   wrongPrincipal,
+			want:  HostKeyRejected,
+		},
+		{
+			name: "certificate from revoked CA",
+			lines: []string{
+				"@cert-authority " + knownHostsLineFor("*.com", ca.PublicKey()),
+				"@revoked " + knownHostsLineFor("*", ca.PublicKey()),
+			},
+			key:  cert,
+			want: HostKeyRevoked,
+		},
+		{
+			name: "certificate around a revoked key",
+			lines: []string{
+				"@cert-authority " + knownHostsLineFor("*.com", ca.PublicKey()),
+				"@revoked " + knownHostsLineFor("*", hostKey),
+			},
+			key:  cert,
+			want: HostKeyRevoked,
+		},
+		{
+			name:  "untrusted certificate falls back to its key",
+			lines: []string{knownHostsLineFor("example.com", hostKey)},
+			key:   untrustedCert,
+		},
+		{
+			name:  "untrusted certificate with unknown key",
+			lines: []string{"@cert-authority " + knownHostsLineFor("*.com", ca.PublicKey())},
+			key:   untrustedCert,
+			want:  HostKeyUnknown,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kh, err := ParseKnownHosts(strings.Join(tt.lines, "\n"), KnownHostsOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = kh.Check("example.com:22", nil, tt.key)
+			if got := hostKeyReason(err); got != tt.want {
+				t.Errorf("Check() = %v, want reason %q", err, tt.want)
+			}
+			if err != nil && tt.want == "" {
+				t.Errorf("Check() = %v, want success", err)
+			}
+		})
+	}
+}
+
+func TestKnownHostsTrustOnFirstUse(t *testing.T) {
+	key := newTestSigner(t).PublicKey()
+	var learned []KnownHostEntry
+	kh := NewKnownHosts(KnownHostsOptions{
+		TrustOnFirstUse: true,
+		HashHostnames:   true,
+		OnLearn: func(entry KnownHostEntry) {
+			learned = append(learned, entry)
+		},
+	})
+
+	if err := kh.Check("example.com:2222", nil, key); err != nil {
+		t.Fatalf("first connection: %v", err)
+	}
+	if len(learned) != 1 {
+		t.Fatalf("learned %d entries, want 1", len(learned))
+	}
+	if !strings.HasPrefix(learned[0].Hosts[0], "|1|") {
+		t.Errorf("learned host %q is not hashed", learned[0].Hosts[0])
+	}
+	if err := kh.Check("example.com:2222", nil, key); err != nil {
+		t.Errorf("second connection: %v", err)
+	}
+	if err := kh.Check("example.com:2222", nil, newTestSigner(t).PublicKey()); hostKeyReason(err) != HostKeyMismatch {
+		t.Errorf("changed key: %v, want mismatch", err)
+	}
+	if len(learned) != 1 {
+		t.Errorf("learned %d entries, want 1", len(learned))
+	}
+}
+
+func TestKnownHostsLoad(t *testing.T) {
+	key := newTestSigner(t).PublicKey()
+	tests := []struct {
+		name    string
+		data    string
+		entries int
+		wantErr bool
+	}{
+		{"empty", "", 0, false},
+		{"comments and blank lines", "# comment\n\n   \n", 0, false},
+		{"CRLF", knownHostsLineFor("a", key) + "\r\n" + knownHostsLineFor("b", key) + "\r\n", 2, false},
+		{"markers", "@revoked " + knownHostsLineFor("a", key) + "\n@cert-authority " + knownHostsLineFor("*", key), 2, false},
+		{"unknown marker", "@trusted " + knownHostsLineFor("a", key), 0, true},
+		{"bad key", "example.com ssh-ed25519 AAAA", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kh, err := ParseKnownHosts(tt.data, KnownHostsOptions{})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseKnownHosts() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got := len(kh.Entries()); got != tt.entries {
+				t.Errorf("got %d entries, want %d", got, tt.entries)
+			}
+		})
+	}
+}
+
+func TestKnownHostsSerializeRoundTrip(t *testing.T) {
+	key := newTestSigner(t).PublicKey()
+	data := "# my hosts\n" + knownHostsLineFor("a.example.com", key) + " laptop\n\n"
+	kh, err := ParseKnownHosts(data, KnownHostsOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := kh.Add("b.example.com:2222", key); err != nil {
+		t.Fatal(err)
+	}
+
+	want := data + knownHostsLineFor("[b.example.com]:2222", key) + "\n"
+	if got := kh.Serialize(); got != want {
+		t.Errorf("Serialize() = %q, want %q", got, want)
+	}
+
+	if removed := kh.Remove("a.example.com"); removed != 1 {
+		t.Errorf("Remove() = %d, want 1", removed)
+	}
+	if strings.Contains(kh.Serialize(), "a.example.com") {
+		t.Errorf("removed host is still serialized")
+	}
+}
+
+func TestKnownHostsHostKeyAlgorithms(t *testing.T) {
+	ed25519Key := newTestSigner(t).PublicKey()
+	ecdsaKey := newTestECDSAKey(t)
+	ca := newTestSigner(t).PublicKey()
+
+	tests := []struct {
+		name  string
+		lines []string
+		first []string
+	}{
+		{
+			name: "unknown host",
+		},
+		{
+			name:  "ed25519",
+			lines: []string{knownHostsLineFor("example.com", ed25519Key)},
+			first: []string{ssh.KeyAlgoED25519},
+		},
+		{
+			name:  "ecdsa",
+			lines: []string{knownHostsLineFor("example.com", ecdsaKey)},
+			first: []string{ssh.KeyAlgoECDSA256},
+		},
+		{
+			name: "file order",
+			lines: []string{
+				knownHostsLineFor("example.com", ecdsaKey),
+				knownHostsLineFor("example.com", ed25519Key),
+			},
+			first: []string{ssh.KeyAlgoECDSA256, ssh.KeyAlgoED25519},
+		},
+		{
+			name:  "cert-authority prefers certificates",
+			lines: []string{"@cert-authority " + knownHostsLineFor("*", ca)},
+			first: certAlgorithms,
+		},
+		{
+			name:  "revoked keys are not preferred",
+			lines: []string{"@revoked " + knownHostsLineFor("example.com", ecdsaKey)},
+		},
+		{
+			name:  "other hosts are ignored",
+			lines: []string{knownHostsLineFor("other.example.com", ecdsaKey)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kh, err := ParseKnownHosts(strings.Join(tt.lines, "\n"), KnownHostsOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			algos := kh.HostKeyAlgorithms("example.com:22")
+			if tt.first == nil {
+				if algos != nil {
+					t.Errorf("HostKeyAlgorithms() = %v, want nil", algos)
+				}
+				return
+			}
+			if len(algos) < len(tt.first) {
+				t.Fatalf("HostKeyAlgorithms() = %v, want prefix %v", algos, tt.first)
+			}
+			for i, algo := range tt.first {
+				if algos[i] != algo {
+					t.Fatalf("HostKeyAlgorithms() = %v, want prefix %v", algos, tt.first)
+				}
+			}
+
+			// The rest of the defaults follow, so a changed key type still negotiates
+			seen := make(map[string]bool)
+			for _, algo := range algos {
+				if seen[algo] {
+					t.Errorf("%s is listed twice", algo)
+				}
+				seen[algo] = true
+			}
+			for _, algo := range ssh.SupportedAlgorithms().HostKeys {
+				if !seen[algo] {
+					t.Errorf("%s is missing from %v", algo, algos)
+				}
+			}
+		})
+	}
+}
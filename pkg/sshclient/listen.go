@@ -0,0 +1,118 @@
+package sshclient
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+)
+
+// RemoteListener is a port on the server forwarded back to the client with
+// tcpip-forward. Each inbound connection arrives as a ForwardedChannel.
+type RemoteListener struct {
+	id           string
+	client       *Client
+	listener     net.Listener
+	onConnection func(channel *ForwardedChannel)
+	accepting    bool
+	done         chan struct{}
+	once         sync.Once
+	mu           sync.Mutex
+}
+
+// ListenRemote asks the server to listen on bindAddr:port. Port 0 lets the
+// server pick one, which Addr reports. Connections wait on the server until
+// OnConnection is set.
+func (c *Client) ListenRemote(bindAddr string, port int) (*RemoteListener, error) {
+	c.mu.RLock()
+	conn := c.conn
+	c.mu.RUnlock()
+
+	if conn == nil {
+		return nil, fmt.Errorf("not connected")
+	}
+
+	listener, err := conn.Listen("tcp", net.JoinHostPort(bindAddr, strconv.Itoa(port)))
+	if err != nil {
+		return nil, fmt.Errorf("remote port forwarding request failed: %v", err)
+	}
+
+	l := &RemoteListener{
+		id:       c.newChannelID(),
+		client:   c,
+		listener: listener,
+		done:     make(chan struct{}),
+	}
+
+	c.mu.Lock()
+	c.listeners[l.id] = l
+	c.mu.Unlock()
+
+	return l, nil
+}
+
+// ID returns the listener identifier
+func (l *RemoteListener) ID() string {
+	return l.id
+}
+
+// Addr returns the address the server is listening on
+func (l *RemoteListener) Addr() net.Addr {
+	return l.listener.Addr()
+}
+
+// OnConnection sets the callback for inbound connections and starts accepting them
+func (l *RemoteListener) OnConnection(callback func(channel *ForwardedChannel)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.onConnection = callback
+	if !l.accepting {
+		l.accepting = true
+		go l.accept()
+	}
+}
+
+func (l *RemoteListener) accept() {
+	for {
+		conn, err := l.listener.Accept()
+		if err != nil {
+			l.finish()
+			return
+		}
+
+		l.mu.Lock()
+		onConnection := l.onConnection
+		l.mu.Unlock()
+
+		channel := l.client.newForwardedChannel(conn)
+		if onConnection == nil {
+			channel.Close()
+			continue
+		}
+		onConnection(channel)
+	}
+}
+
+// Close sends cancel-tcpip-forward and stops accepting connections. Channels
+// that were already accepted stay open.
+func (l *RemoteListener) Close() error {
+	err := l.listener.Close()
+	l.finish()
+	return err
+}
+
+// Done is closed once the listener has closed
+func (l *RemoteListener) Done() <-chan struct{} {
+	return l.done
+}
+
+func (l *RemoteListener) finish() {
+	l.once.Do(func() {
+		l.client.mu.Lock()
+		delete(l.client.listeners, l.id)
+		l.client.mu.Unlock()
+
+		close(l.done)
+	})
+}
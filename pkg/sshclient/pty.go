@@ -0,0 +1,190 @@
+package sshclient
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	defaultTerm = "xterm-256color"
+	defaultCols = 80
+	defaultRows = 24
+)
+
+// PTYOptions configures the pseudo terminal requested for a shell
+type PTYOptions struct {
+	// Disabled skips the pty-req so the shell runs without a terminal
+	Disabled bool
+	// Term is the TERM value, xterm-256color by default
+	Term string
+	// Cols and Rows are the size in characters, 80x24 by default
+	Cols int
+	Rows int
+	// Width and Height are the size in pixels, zero when unknown
+	Width  int
+	Height int
+	// Modes are the terminal modes; ECHO on at 14400 baud by default
+	Modes ssh.TerminalModes
+}
+
+// withDefaults fills in unset fields with the values the shell used to hard-code
+func (o PTYOptions) withDefaults() PTYOptions {
+	if o.Term == "" {
+		o.Term = defaultTerm
+	}
+	if o.Cols <= 0 {
+		o.Cols = defaultCols
+	}
+	if o.Rows <= 0 {
+		o.Rows = defaultRows
+	}
+	if o.Modes == nil {
+		o.Modes = ssh.TerminalModes{
+			ssh.ECHO:          1,     // enable echoing
+			ssh.TTY_OP_ISPEED: 14400, // input speed = 14.4kbaud
+			ssh.TTY_OP_OSPEED: 14400, // output speed = 14.4kbaud
+		}
+	}
+	return o
+}
+
+// ptyRequestMsg is the payload of a pty-req channel request (RFC 4254 section 6.2)
+type ptyRequestMsg struct {
+	Term     string
+	Columns  uint32
+	Rows     uint32
+	Width    uint32
+	Height   uint32
+	Modelist string
+}
+
+// windowChangeMsg is the payload of a window-change channel request (RFC 4254 section 6.7)
+type windowChangeMsg struct {
+	Columns uint32
+	Rows    uint32
+	Width   uint32
+	Height  uint32
+}
+
+// requestPty sends pty-req itself because ssh.Session.RequestPty cannot carry pixel dimensions
+func requestPty(session *ssh.Session, options PTYOptions) error {
+	msg := ptyRequestMsg{
+		Term:     options.Term,
+		Columns:  uint32(options.Cols),
+		Rows:     uint32(options.Rows),
+		Width:    uint32(options.Width),
+		Height:   uint32(options.Height),
+		Modelist: encodeTerminalModes(options.Modes),
+	}
+
+	ok, err := session.SendRequest("pty-req", true, ssh.Marshal(&msg))
+	if err == nil && !ok {
+		err = errors.New("ssh: pty-req failed")
+	}
+	return err
+}
+
+// windowChange reports a new terminal size including pixel dimensions
+func windowChange(session *ssh.Session, cols, rows, width, height int) error {
+	msg := windowChangeMsg{
+		Columns: uint32(cols),
+		Rows:    uint32(rows),
+		Width:   uint32(width),
+		Height:  uint32(height),
+	}
+
+	_, err := session.SendRequest("window-change", false, ssh.Marshal(&msg))
+	return err
+}
+
+// encodeTerminalModes serializes modes in opcode order, terminated by TTY_OP_END
+func encodeTerminalModes(modes ssh.TerminalModes) string {
+	opcodes := make([]int, 0, len(modes))
+	for opcode := range modes {
+		opcodes = append(opcodes, int(opcode))
+	}
+	sort.Ints(opcodes)
+
+	var encoded []byte
+	for _, opcode := range opcodes {
+		value := modes[uint8(opcode)]
+		encoded = append(encoded, uint8(opcode), byte(value>>24), byte(value>>16), byte(value>>8), byte(value))
+	}
+	encoded = append(encoded, 0) // TTY_OP_END
+	return string(encoded)
+}
+
+// ParseTerminalMode resolves a mode name such as "ECHO" or "VERASE", or a
+// numeric opcode, to its opcode
+func ParseTerminalMode(name string) (uint8, bool) {
+	if opcode, ok := terminalModeNames[strings.ToUpper(name)]; ok {
+		return opcode, true
+	}
+	if opcode, err := strconv.ParseUint(name, 10, 8); err == nil && opcode > 0 && opcode < 160 {
+		return uint8(opcode), true
+	}
+	return 0, false
+}
+
+var terminalModeNames = map[string]uint8{
+	"VINTR":         ssh.VINTR,
+	"VQUIT":         ssh.VQUIT,
+	"VERASE":        ssh.VERASE,
+	"VKILL":         ssh.VKILL,
+	"VEOF":          ssh.VEOF,
+	"VEOL":          ssh.VEOL,
+	"VEOL2":         ssh.VEOL2,
+	"VSTART":        ssh.VSTART,
+	"VSTOP":         ssh.VSTOP,
+	"VSUSP":         ssh.VSUSP,
+	"VDSUSP":        ssh.VDSUSP,
+	"VREPRINT":      ssh.VREPRINT,
+	"VWERASE":       ssh.VWERASE,
+	"VLNEXT":        ssh.VLNEXT,
+	"VFLUSH":        ssh.VFLUSH,
+	"VSWTCH":        ssh.VSWTCH,
+	"VSTATUS":       ssh.VSTATUS,
+	"VDISCARD":      ssh.VDISCARD,
+	"IGNPAR":        ssh.IGNPAR,
+	"PARMRK":        ssh.PARMRK,
+	"INPCK":         ssh.INPCK,
+	"ISTRIP":        ssh.ISTRIP,
+	"INLCR":         ssh.INLCR,
+	"IGNCR":         ssh.IGNCR,
+	"ICRNL":         ssh.ICRNL,
+	"IUCLC":         ssh.IUCLC,
+	"IXON":          ssh.IXON,
+	"IXANY":         ssh.IXANY,
+	"IXOFF":         ssh.IXOFF,
+	"IMAXBEL":       ssh.IMAXBEL,
+	"IUTF8":         ssh.IUTF8,
+	"ISIG":          ssh.ISIG,
+	"ICANON":        ssh.ICANON,
+	"XCASE":         ssh.XCASE,
+	"ECHO":          ssh.ECHO,
+	"ECHOE":         ssh.ECHOE,
+	"ECHOK":         ssh.ECHOK,
+	"ECHONL":        ssh.ECHONL,
+	"NOFLSH":        ssh.NOFLSH,
+	"TOSTOP":        ssh.TOSTOP,
+	"IEXTEN":        ssh.IEXTEN,
+	"ECHOCTL":       ssh.ECHOCTL,
+	"ECHOKE":        ssh.ECHOKE,
+	"PENDIN":        ssh.PENDIN,
+	"OPOST":         ssh.OPOST,
+	"OLCUC":         ssh.OLCUC,
+	"ONLCR":         ssh.ONLCR,
+	"OCRNL":         ssh.OCRNL,
+	"ONOCR":         ssh.ONOCR,
+	"ONLRET":        ssh.ONLRET,
+	"CS7":           ssh.CS7,
+	"CS8":           ssh.CS8,
+	"PARENB":        ssh.PARENB,
+	"PARODD":        ssh.PARODD,
+	"TTY_OP_ISPEED": ssh.TTY_OP_ISPEED,
+	"TTY_OP_OSPEED": ssh.TTY_OP_OSPEED,
+}
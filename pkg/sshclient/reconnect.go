@@ -0,0 +1,220 @@
+package sshclient
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	defaultReconnectInitialDelay = 1000
+	defaultReconnectMaxDelay     = 30000
+	defaultReconnectMultiplier   = 2
+	defaultReconnectJitter       = 0.2
+)
+
+// TransportRequestFunc supplies a fresh, connected transport for a
+// reconnection attempt
+type TransportRequestFunc func(attempt int) (Transport, error)
+
+// ReconnectOptions configures reconnecting with exponential backoff after the
+// connection is lost
+type ReconnectOptions struct {
+	// MaxAttempts is how many times to try before giving up; 0 disables
+	// reconnecting
+	MaxAttempts int
+	// InitialDelay is the wait before the first attempt in milliseconds,
+	// 1000 by default
+	InitialDelay int
+	// MaxDelay caps the wait between attempts in milliseconds, 30000 by default
+	MaxDelay int
+	// Multiplier grows the wait after every attempt, 2 by default
+	Multiplier float64
+	// Jitter randomly shifts each wait by up to this fraction of it, 0.2 by
+	// default; a negative value disables it
+	Jitter float64
+}
+
+// delay returns the wait before attempt, counted from 1
+func (o ReconnectOptions) delay(attempt int) time.Duration {
+	initial := float64(o.InitialDelay)
+	if o.InitialDelay <= 0 {
+		initial = defaultReconnectInitialDelay
+	}
+	maxDelay := float64(o.MaxDelay)
+	if o.MaxDelay <= 0 {
+		maxDelay = defaultReconnectMaxDelay
+	}
+	multiplier := o.Multiplier
+	if multiplier < 1 {
+		multiplier = defaultReconnectMultiplier
+	}
+	jitter := o.Jitter
+	if jitter == 0 {
+		jitter = defaultReconnectJitter
+	}
+
+	delay := math.Min(initial*math.Pow(multiplier, float64(attempt-1)), maxDelay)
+	if jitter > 0 {
+		delay += delay * jitter * (2*rand.Float64() - 1)
+	}
+	return time.Duration(delay) * time.Millisecond
+}
+
+// OnRequestTransport sets the callback that supplies transports for
+// reconnecting. Without it the client never reconnects.
+func (c *Client) OnRequestTransport(callback TransportRequestFunc) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onRequestTransport = callback
+}
+
+// reconnectEnabled reports whether a lost connection should be re-established
+func (c *Client) reconnectEnabled() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.options.Reconnect.MaxAttempts > 0 && c.onRequestTransport != nil
+}
+
+// watchConnection reconnects when conn dies on its own. Without a reconnect
+// policy a dead connection is only noticed by keepalive.
+func (c *Client) watchConnection(conn *ssh.Client) {
+	if !c.reconnectEnabled() {
+		return
+	}
+	go func() {
+		err := conn.Wait()
+		c.connectionLost(conn, fmt.Sprintf("connection to %s:%d lost: %v", c.options.Host, c.options.Port, err))
+	}()
+}
+
+// connectionLost closes conn along with the jump hosts and transport beneath
+// it, then reconnects or reports an error
+func (c *Client) connectionLost(conn *ssh.Client, reason string) {
+	c.mu.Lock()
+	// A disconnect or a newer connection already took over
+	if c.conn != conn {
+		c.mu.Unlock()
+		return
+	}
+	c.conn = nil
+	c.agentForwarding = nil
+	jumps := c.jumps
+	c.jumps = nil
+	transport := c.transport
+	reopenShell := c.shell != nil
+	c.shell = nil
+	c.mu.Unlock()
+
+	conn.Close()
+	for i := len(jumps) - 1; i >= 0; i-- {
+		jumps[i].Close()
+	}
+	if transport != nil {
+		transport.Close()
+	}
+
+	if !c.reconnectEnabled() {
+		c.notifyState("error", StateDetail{Host: c.options.Host, Port: c.options.Port, Reason: reason})
+		return
+	}
+	c.reconnect(reason, reopenShell)
+}
+
+// reconnect asks for new transports and redoes the handshake until it
+// succeeds, the attempts run out or the client is disconnected. The shell is
+// reopened with the last terminal size when one was open.
+func (c *Client) reconnect(reason string, reopenShell bool) {
+	stop := make(chan struct{})
+	c.mu.Lock()
+	c.stopReconnect = stop
+	requestTransport := c.onRequestTransport
+	c.mu.Unlock()
+
+	options := c.options.Reconnect
+	for attempt := 1; attempt <= options.MaxAttempts; attempt++ {
+		c.notifyState("reconnecting", StateDetail{Host: c.options.Host, Port: c.options.Port, Reason: reason, Attempt: attempt})
+
+		select {
+		case <-stop:
+			return
+		case <-time.After(options.delay(attempt)):
+		}
+
+		transport, err := requestTransport(attempt)
+		if err != nil {
+			reason = fmt.Sprintf("failed to get a transport: %v", err)
+			continue
+		}
+
+		c.mu.Lock()
+		select {
+		case <-stop:
+			c.mu.Unlock()
+			transport.Close()
+			return
+		default:
+		}
+		c.transport = transport
+		c.mu.Unlock()
+
+		client, jumps, _, err := c.establish()
+		if err != nil {
+			reason = err.Error()
+			continue
+		}
+
+		c.mu.Lock()
+		select {
+		case <-stop:
+			c.mu.Unlock()
+			client.Close()
+			for i := len(jumps) - 1; i >= 0; i-- {
+				jumps[i].Close()
+			}
+			return
+		default:
+		}
+		c.conn = client
+		c.jumps = jumps
+		c.mu.Unlock()
+
+		if reopenShell {
+			if err := c.StartShell(); err != nil {
+				reason = fmt.Sprintf("failed to reopen the shell: %v", err)
+				c.mu.Lock()
+				c.conn = nil
+				c.jumps = nil
+				c.mu.Unlock()
+				client.Close()
+				for i := len(jumps) - 1; i >= 0; i-- {
+					jumps[i].Close()
+				}
+				continue
+			}
+		}
+
+		c.mu.Lock()
+		if c.stopReconnect == stop {
+			c.stopReconnect = nil
+		}
+		c.mu.Unlock()
+
+		c.startKeepalive(client)
+		c.watchConnection(client)
+
+		c.notifyState("reconnected", StateDetail{Host: c.options.Host, Port: c.options.Port, Attempt: attempt})
+		return
+	}
+
+	c.mu.Lock()
+	if c.stopReconnect == stop {
+		c.stopReconnect = nil
+	}
+	c.mu.Unlock()
+
+	c.notifyState("error", StateDetail{Host: c.options.Host, Port: c.options.Port, Reason: fmt.Sprintf("gave up reconnecting after %d attempts: %s", options.MaxAttempts, reason)})
+}
@@ -0,0 +1,110 @@
+package sshclient
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/andrew/sshclient-wasm/pkg/scp"
+)
+
+// SCPOptions configures an SCP transfer
+type SCPOptions struct {
+	// Recursive copies directories. Uploads turn it on when an entry needs it.
+	Recursive bool
+	// Preserve keeps mode bits and times, like scp -p
+	Preserve bool
+	// OnProgress is called after every chunk of file contents
+	OnProgress scp.ProgressFunc
+}
+
+// SCPUpload sends entries to target with a remote "scp -t", for servers
+// without an sftp subsystem
+func (c *Client) SCPUpload(target string, entries []scp.Entry, options SCPOptions) error {
+	recursive := options.Recursive
+	for _, entry := range entries {
+		if entry.IsDir() || strings.Contains(strings.Trim(entry.Path, "/"), "/") {
+			recursive = true
+		}
+	}
+
+	command := "scp" + scpFlags(recursive, options.Preserve) + " -t -- " + shellQuote(target)
+	return c.runSCP(command, func(r io.Reader, w io.Writer) error {
+		return scp.Send(r, w, entries, scp.Options{Preserve: options.Preserve, OnProgress: options.OnProgress})
+	})
+}
+
+// SCPDownload fetches source with a remote "scp -f", passing every file and
+// directory to handler
+func (c *Client) SCPDownload(source string, handler scp.Handler, options SCPOptions) error {
+	command := "scp" + scpFlags(options.Recursive, options.Preserve) + " -f -- " + shellQuote(source)
+	return c.runSCP(command, func(r io.Reader, w io.Writer) error {
+		return scp.Receive(r, w, handler, scp.Options{Preserve: options.Preserve, OnProgress: options.OnProgress})
+	})
+}
+
+func scpFlags(recursive, preserve bool) string {
+	flags := ""
+	if recursive {
+		flags += " -r"
+	}
+	if preserve {
+		flags += " -p"
+	}
+	return flags
+}
+
+// runSCP starts command on a new session and runs the protocol over its
+// stdin and stdout
+func (c *Client) runSCP(command string, run func(r io.Reader, w io.Writer) error) error {
+	c.mu.RLock()
+	conn := c.conn
+	c.mu.RUnlock()
+
+	if conn == nil {
+		return fmt.Errorf("not connected")
+	}
+
+	session, err := conn.NewSession()
+	if err != nil {
+		return fmt.Errorf("failed to create session: %v", err)
+	}
+	defer session.Close()
+
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		return fmt.Errorf("failed to open stdin: %v", err)
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("failed to open stdout: %v", err)
+	}
+	var stderr bytes.Buffer
+	session.Stderr = &stderr
+
+	if err := session.Start(command); err != nil {
+		return fmt.Errorf("failed to start scp: %v", err)
+	}
+
+	if err := run(stdout, stdin); err != nil {
+		// The remote may still be waiting on us, so close the channel before
+		// collecting its stderr
+		session.Close()
+		session.Wait()
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return fmt.Errorf("%v (%s)", err, message)
+		}
+		return err
+	}
+	stdin.Close()
+
+	result, err := exitResult(session.Wait())
+	if err != nil {
+		return err
+	}
+	if result.ExitCode != 0 {
+		return fmt.Errorf("scp exited with code %d: %s", result.ExitCode, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
@@ -0,0 +1,508 @@
+package sshclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// SessionOptions configures a shell or exec session
+type SessionOptions struct {
+	// PTY requests a pseudo terminal; nil runs the session without one
+	PTY *PTYOptions
+	// ForwardAgent serves the client's agent to this session
+	ForwardAgent bool
+	// OnData receives the session output, tagged with its stream in the metadata
+	OnData PacketCallback
+	// OnStderr receives stderr instead of OnData when set
+	OnStderr PacketCallback
+	// OnExit is called once the session has ended
+	OnExit func(result ExecResult, err error)
+	// StdinLimit is how many bytes of stdin may wait for the channel before
+	// sends wait for room, DefaultStdinLimit by default
+	StdinLimit int
+}
+
+// DefaultStdinLimit is how much stdin a session buffers before sends wait
+const DefaultStdinLimit = 1 << 20
+
+// Session is one session channel multiplexed over a Client connection
+type Session struct {
+	id      string
+	client  *Client
+	session *ssh.Session
+	pty     *PTYOptions
+	options SessionOptions
+	done    chan struct{}
+	result  ExecResult
+	err     error
+	closed  bool
+	mu      sync.Mutex
+	// stdin holds writes in the order they were queued, with buffered bytes
+	// not yet accepted by the channel
+	stdin      []*stdinWrite
+	buffered   int
+	stdinReady *sync.Cond
+	// flushed is closed once buffered drops to zero
+	flushed chan struct{}
+	// room is closed once buffered drops, for sends waiting on the limit
+	room chan struct{}
+}
+
+// stdinWrite is data queued for a session's stdin
+type stdinWrite struct {
+	data    []byte
+	started bool
+	done    chan error
+	// settled is closed once the write finished or was withdrawn
+	settled chan struct{}
+}
+
+// OpenShell starts an interactive shell on a new channel
+func (c *Client) OpenShell(options SessionOptions) (*Session, error) {
+	return c.openSession(options, func(session *ssh.Session) error {
+		if err := session.Shell(); err != nil {
+			return fmt.Errorf("failed to start shell: %v", err)
+		}
+		return nil
+	})
+}
+
+// OpenExec starts command on a new channel and keeps its stdin open for Send
+func (c *Client) OpenExec(command string, options SessionOptions) (*Session, error) {
+	return c.openSession(options, func(session *ssh.Session) error {
+		if err := session.Start(command); err != nil {
+			return fmt.Errorf("failed to start command: %v", err)
+		}
+		return nil
+	})
+}
+
+// Session returns an open session by ID
+func (c *Client) Session(id string) (*Session, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	s, ok := c.channels[id]
+	return s, ok
+}
+
+// Sessions returns all open sessions
+func (c *Client) Sessions() []*Session {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	result := make([]*Session, 0, len(c.channels))
+	for _, s := range c.channels {
+		result = append(result, s)
+	}
+	return result
+}
+
+func (c *Client) openSession(options SessionOptions, start func(*ssh.Session) error) (*Session, error) {
+	c.mu.RLock()
+	conn := c.conn
+	c.mu.RUnlock()
+
+	if conn == nil {
+		return nil, fmt.Errorf("not connected")
+	}
+
+	session, err := conn.NewSession()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create session: %v", err)
+	}
+
+	s := &Session{
+		id:      c.newChannelID(),
+		client:  c,
+		session: session,
+		options: options,
+		done:    make(chan struct{}),
+	}
+	s.stdinReady = sync.NewCond(&s.mu)
+	if options.PTY != nil {
+		pty := options.PTY.withDefaults()
+		s.pty = &pty
+	}
+
+	if err := s.start(start); err != nil {
+		session.Close()
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.channels[s.id] = s
+	c.mu.Unlock()
+
+	go s.wait()
+
+	return s, nil
+}
+
+// newChannelID returns an identifier for a new channel on this connection
+func (c *Client) newChannelID() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.nextChannel++
+	return fmt.Sprintf("%s-%d", c.sessionID, c.nextChannel)
+}
+
+func (s *Session) start(start func(*ssh.Session) error) error {
+	stdin, err := s.session.StdinPipe()
+	if err != nil {
+		return fmt.Errorf("failed to create stdin pipe: %v", err)
+	}
+
+	// Output is copied by the ssh package, so Wait only returns once it has been delivered
+	s.session.Stdout = s.output("stdout", s.options.OnData)
+	onStderr := s.options.OnStderr
+	if onStderr == nil {
+		onStderr = s.options.OnData
+	}
+	s.session.Stderr = s.output("stderr", onStderr)
+
+	if s.options.ForwardAgent {
+		if err := s.client.enableAgentForwarding(); err != nil {
+			return fmt.Errorf("failed to set up agent forwarding: %v", err)
+		}
+		if err := agent.RequestAgentForwarding(s.session); err != nil {
+			return fmt.Errorf("agent forwarding request failed: %v", err)
+		}
+	}
+
+	if s.pty != nil {
+		if err := requestPty(s.session, *s.pty); err != nil {
+			return fmt.Errorf("request for pseudo terminal failed: %v", err)
+		}
+	}
+
+	if err := start(s.session); err != nil {
+		return err
+	}
+
+	go s.writeStdin(stdin)
+
+	return nil
+}
+
+// writeStdin writes queued data in order, blocking while the channel window
+// is full, and closes stdin once the session is closed and the queue drained
+func (s *Session) writeStdin(stdin io.WriteCloser) {
+	s.mu.Lock()
+	for {
+		for len(s.stdin) == 0 && !s.closed {
+			s.stdinReady.Wait()
+		}
+		if len(s.stdin) == 0 {
+			s.mu.Unlock()
+			stdin.Close()
+			return
+		}
+		w := s.stdin[0]
+		s.stdin = s.stdin[1:]
+		w.started = true
+		s.mu.Unlock()
+
+		_, err := stdin.Write(w.data)
+		if err != nil {
+			err = fmt.Errorf("failed to write to stdin: %v", err)
+		}
+
+		s.mu.Lock()
+		s.drained(len(w.data))
+		w.done <- err
+		close(w.settled)
+	}
+}
+
+// drained accounts for n bytes leaving the stdin queue. It must be called
+// with mu held.
+func (s *Session) drained(n int) {
+	s.buffered -= n
+	if s.buffered == 0 && s.flushed != nil {
+		close(s.flushed)
+		s.flushed = nil
+	}
+	if s.room != nil {
+		close(s.room)
+		s.room = nil
+	}
+}
+
+func (s *Session) wait() {
+	result, err := exitResult(s.session.Wait())
+
+	s.mu.Lock()
+	s.result = result
+	s.err = err
+	s.closed = true
+	s.stdinReady.Broadcast()
+	close(s.done)
+	if s.room != nil {
+		close(s.room)
+		s.room = nil
+	}
+	s.mu.Unlock()
+
+	s.client.mu.Lock()
+	delete(s.client.channels, s.id)
+	s.client.mu.Unlock()
+
+	if s.options.OnExit != nil {
+		s.options.OnExit(result, err)
+	}
+}
+
+// output delivers one output stream to callback
+func (s *Session) output(stream string, callback PacketCallback) callbackWriter {
+	return func(data []byte) {
+		if callback != nil {
+			metadata := s.metadata("receive", len(data))
+			metadata["stream"] = stream
+			callback(data, metadata)
+		}
+	}
+}
+
+func (s *Session) metadata(direction string, size int) map[string]interface{} {
+	return channelMetadata(s.id, direction, size)
+}
+
+// channelMetadata describes data sent or received on one channel
+func channelMetadata(id, direction string, size int) map[string]interface{} {
+	return map[string]interface{}{
+		"timestamp": time.Now().Unix(),
+		"type":      "data",
+		"direction": direction,
+		"size":      size,
+		"channelId": id,
+	}
+}
+
+// ID returns the session identifier
+func (s *Session) ID() string {
+	return s.id
+}
+
+// Send writes data to the session's stdin, blocking until the channel has
+// accepted all of it
+func (s *Session) Send(data []byte) error {
+	return s.SendContext(context.Background(), data)
+}
+
+// SendContext is Send bounded by ctx. Data that was not yet being written
+// when ctx ends is dropped; data already being written is still delivered.
+func (s *Session) SendContext(ctx context.Context, data []byte) error {
+	w, err := s.queueContext(ctx, data)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case err := <-w.done:
+		return err
+	case <-ctx.Done():
+		s.withdraw(w, ctx.Err())
+		return ctx.Err()
+	}
+}
+
+// Queue adds data to the session's stdin without waiting for the channel to
+// accept it. Data is written in the order it was queued, and the returned
+// channel receives the result once the channel has accepted it. Queue only
+// blocks while more than the stdin limit is already waiting.
+func (s *Session) Queue(data []byte) (<-chan error, error) {
+	return s.QueueContext(context.Background(), data)
+}
+
+// QueueContext is Queue bounded by ctx, both while it waits for room and
+// while the data waits its turn. Data not yet being written when ctx ends is
+// withdrawn and its channel receives ctx's error.
+func (s *Session) QueueContext(ctx context.Context, data []byte) (<-chan error, error) {
+	w, err := s.queueContext(ctx, data)
+	if err != nil {
+		return nil, err
+	}
+	return w.done, nil
+}
+
+func (s *Session) queueContext(ctx context.Context, data []byte) (*stdinWrite, error) {
+	w, err := s.queue(ctx, data)
+	if err != nil {
+		return nil, err
+	}
+
+	if ctx.Done() != nil {
+		go func() {
+			select {
+			case <-ctx.Done():
+				s.withdraw(w, ctx.Err())
+			case <-w.settled:
+			}
+		}()
+	}
+	return w, nil
+}
+
+func (s *Session) queue(ctx context.Context, data []byte) (*stdinWrite, error) {
+	limit := s.options.StdinLimit
+	if limit <= 0 {
+		limit = DefaultStdinLimit
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// A write larger than the limit still goes through on an empty queue
+	for !s.closed && s.buffered > 0 && s.buffered+len(data) > limit {
+		if s.room == nil {
+			s.room = make(chan struct{})
+		}
+		room := s.room
+		s.mu.Unlock()
+		select {
+		case <-room:
+		case <-ctx.Done():
+			s.mu.Lock()
+			return nil, ctx.Err()
+		}
+		s.mu.Lock()
+	}
+
+	if s.closed {
+		return nil, fmt.Errorf("session closed")
+	}
+
+	w := &stdinWrite{data: data, done: make(chan error, 1), settled: make(chan struct{})}
+	s.stdin = append(s.stdin, w)
+	s.buffered += len(data)
+	s.stdinReady.Signal()
+
+	if onPacketSend := s.client.onPacketSend; onPacketSend != nil {
+		onPacketSend(data, s.metadata("send", len(data)))
+	}
+	return w, nil
+}
+
+// withdraw removes w from the queue unless it is already being written
+func (s *Session) withdraw(w *stdinWrite, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if w.started {
+		return
+	}
+	for i, queued := range s.stdin {
+		if queued == w {
+			s.stdin = append(s.stdin[:i], s.stdin[i+1:]...)
+			s.drained(len(w.data))
+			w.done <- err
+			close(w.settled)
+			return
+		}
+	}
+}
+
+// Buffered returns how many bytes of stdin are queued or in flight and not
+// yet accepted by the channel
+func (s *Session) Buffered() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.buffered
+}
+
+// Flush blocks until everything queued for stdin has been accepted by the
+// channel, the session ends or ctx is done
+func (s *Session) Flush(ctx context.Context) error {
+	s.mu.Lock()
+	if s.buffered == 0 {
+		s.mu.Unlock()
+		return nil
+	}
+	if s.flushed == nil {
+		s.flushed = make(chan struct{})
+	}
+	flushed := s.flushed
+	s.mu.Unlock()
+
+	select {
+	case <-flushed:
+		return nil
+	case <-s.done:
+		return fmt.Errorf("session closed")
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Resize changes the terminal size of a session that has a PTY
+func (s *Session) Resize(cols, rows, width, height int) error {
+	s.mu.Lock()
+	if s.pty == nil {
+		s.mu.Unlock()
+		return fmt.Errorf("session has no pseudo terminal")
+	}
+	s.pty.Cols = cols
+	s.pty.Rows = rows
+	s.pty.Width = width
+	s.pty.Height = height
+	session := s.session
+	s.mu.Unlock()
+
+	// Sending the request while holding s.mu would stall stdin and Close on a slow link
+	return windowChange(session, cols, rows, width, height)
+}
+
+// Close closes the session channel
+func (s *Session) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	s.stdinReady.Broadcast()
+	if s.room != nil {
+		close(s.room)
+		s.room = nil
+	}
+	s.mu.Unlock()
+
+	err := s.session.Close()
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+	return err
+}
+
+// Done is closed once the session has ended
+func (s *Session) Done() <-chan struct{} {
+	return s.done
+}
+
+// Wait blocks until the session ends and returns its exit status
+func (s *Session) Wait() (ExecResult, error) {
+	<-s.done
+	return s.result, s.err
+}
+
+// enableAgentForwarding registers the handler for agent channels opened by the
+// server. The ssh package only allows this once per connection, and a
+// reconnect brings a new connection that needs its own handler.
+func (c *Client) enableAgentForwarding() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn == nil {
+		return fmt.Errorf("not connected")
+	}
+	if c.agentForwarding == c.conn {
+		return nil
+	}
+	if err := agent.ForwardToAgent(c.conn, c.agent); err != nil {
+		return err
+	}
+	c.agentForwarding = c.conn
+	return nil
+}
@@ -0,0 +1,42 @@
+package sshclient
+
+import (
+	"fmt"
+
+	"github.com/andrew/sshclient-wasm/pkg/sftp"
+)
+
+// SFTP returns the SFTP session of this connection, starting the sftp
+// subsystem on first use
+func (c *Client) SFTP() (*sftp.Client, error) {
+	c.sftpMu.Lock()
+	defer c.sftpMu.Unlock()
+
+	c.mu.RLock()
+	conn := c.conn
+	client := c.sftp
+	c.mu.RUnlock()
+
+	if client != nil {
+		select {
+		case <-client.Done():
+		default:
+			return client, nil
+		}
+	}
+
+	if conn == nil {
+		return nil, fmt.Errorf("not connected")
+	}
+
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.sftp = client
+	c.mu.Unlock()
+
+	return client, nil
+}
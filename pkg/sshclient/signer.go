@@ -0,0 +1,163 @@
+package sshclient
+
+import (
+	"crypto/ed25519"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// SignFunc signs data with a key held outside the WASM module. It returns the
+// raw signature the platform produces for algorithm: 64 bytes for Ed25519,
+// r||s (IEEE P1363) or DER for ECDSA P-256, and PKCS #1 v1.5 for RSA.
+type SignFunc func(data []byte, algorithm string) ([]byte, error)
+
+// ExternalKey is a key whose private half is only reachable through Sign,
+// such as a non-extractable WebCrypto key or a hardware token
+type ExternalKey struct {
+	// Label identifies the key when reporting which one authenticated
+	Label string
+	// PublicKey is the public key in authorized_keys format
+	PublicKey string
+	// Certificate is an optional OpenSSH user certificate for the key
+	Certificate string
+	// Sign produces signatures with the private key
+	Sign SignFunc
+}
+
+// ExternalSigner is an ssh.Signer that delegates signing to a SignFunc
+type ExternalSigner struct {
+	pub        ssh.PublicKey
+	sign       SignFunc
+	algorithms []string
+}
+
+// NewExternalSigner creates a signer for pub, which must be an Ed25519,
+// ECDSA P-256 or RSA key
+func NewExternalSigner(pub ssh.PublicKey, sign SignFunc) (*ExternalSigner, error) {
+	if sign == nil {
+		return nil, errors.New("external signer requires a sign function")
+	}
+
+	var algorithms []string
+	switch pub.Type() {
+	case ssh.KeyAlgoED25519:
+		algorithms = []string{ssh.KeyAlgoED25519}
+	case ssh.KeyAlgoECDSA256:
+		algorithms = []string{ssh.KeyAlgoECDSA256}
+	case ssh.KeyAlgoRSA:
+		// SHA-1 signatures are deliberately not offered
+		algorithms = []string{ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256}
+	default:
+		return nil, fmt.Errorf("unsupported external key type: %s", pub.Type())
+	}
+
+	return &ExternalSigner{
+		pub:        pub,
+		sign:       sign,
+		algorithms: algorithms,
+	}, nil
+}
+
+// PublicKey returns the public key of the external signer
+func (s *ExternalSigner) PublicKey() ssh.PublicKey {
+	return s.pub
+}
+
+// Algorithms returns the signature algorithms the signer supports
+func (s *ExternalSigner) Algorithms() []string {
+	return s.algorithms
+}
+
+// Sign signs data with the preferred algorithm for the key type
+func (s *ExternalSigner) Sign(rand io.Reader, data []byte) (*ssh.Signature, error) {
+	return s.SignWithAlgorithm(rand, data, s.algorithms[0])
+}
+
+// SignWithAlgorithm asks the sign function for a signature and encodes it in SSH wire format
+func (s *ExternalSigner) SignWithAlgorithm(_ io.Reader, data []byte, algorithm string) (*ssh.Signature, error) {
+	if algorithm == "" {
+		algorithm = s.algorithms[0]
+	}
+	supported := false
+	for _, algo := range s.algorithms {
+		if algo == algorithm {
+			supported = true
+			break
+		}
+	}
+	if !supported {
+		return nil, fmt.Errorf("external signer does not support %s signatures", algorithm)
+	}
+
+	raw, err := s.sign(data, algorithm)
+	if err != nil {
+		return nil, fmt.Errorf("external signer failed: %v", err)
+	}
+
+	blob, err := encodeSignatureBlob(algorithm, raw)
+	if err != nil {
+		return nil, err
+	}
+	signature := &ssh.Signature{
+		Format: algorithm,
+		Blob:   blob,
+	}
+
+	// Catch wrong hashes or encodings here rather than as an opaque auth failure
+	if err := s.pub.Verify(data, signature); err != nil {
+		return nil, fmt.Errorf("external signer returned an invalid %s signature: %v", algorithm, err)
+	}
+	return signature, nil
+}
+
+// encodeSignatureBlob converts a raw platform signature into the SSH signature blob for algorithm
+func encodeSignatureBlob(algorithm string, raw []byte) ([]byte, error) {
+	switch algorithm {
+	case ssh.KeyAlgoED25519:
+		if len(raw) != ed25519.SignatureSize {
+			return nil, fmt.Errorf("ed25519 signature must be %d bytes, got %d", ed25519.SignatureSize, len(raw))
+		}
+		return raw, nil
+
+	case ssh.KeyAlgoECDSA256:
+		r, s, err := parseECDSASignature(raw, 32)
+		if err != nil {
+			return nil, err
+		}
+		return ssh.Marshal(struct {
+			R *big.Int
+			S *big.Int
+		}{r, s}), nil
+
+	case ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSASHA512:
+		if len(raw) == 0 {
+			return nil, errors.New("empty rsa signature")
+		}
+		return raw, nil
+	}
+
+	return nil, fmt.Errorf("unsupported signature algorithm: %s", algorithm)
+}
+
+// parseECDSASignature accepts both the fixed-size r||s form used by WebCrypto
+// and the ASN.1 DER form used by most tokens
+func parseECDSASignature(raw []byte, size int) (*big.Int, *big.Int, error) {
+	if len(raw) == 2*size {
+		return new(big.Int).SetBytes(raw[:size]), new(big.Int).SetBytes(raw[size:]), nil
+	}
+
+	var sig struct {
+		R *big.Int
+		S *big.Int
+	}
+	rest, err := asn1.Unmarshal(raw, &sig)
+	if err != nil || len(rest) > 0 {
+		return nil, nil, fmt.Errorf("ecdsa signature is neither %d-byte r||s nor DER encoded", 2*size)
+	}
+	return sig.R, sig.S, nil
+}
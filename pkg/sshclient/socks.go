@@ -0,0 +1,256 @@
+package sshclient
+
+import (
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// SOCKS5 protocol constants (RFC 1928, RFC 1929)
+const (
+	socksVersion               = 0x05
+	socksAuthNone              = 0x00
+	socksAuthPassword          = 0x02
+	socksAuthNoAcceptable      = 0xff
+	socksPasswordVersion       = 0x01
+	socksCommandConnect        = 0x01
+	socksAddrIPv4              = 0x01
+	socksAddrDomain            = 0x03
+	socksAddrIPv6              = 0x04
+	socksReplySucceeded        = 0x00
+	socksReplyFailure          = 0x01
+	socksReplyNotAllowed       = 0x02
+	socksReplyRefused          = 0x05
+	socksReplyNotSupported     = 0x07
+	socksReplyAddrNotSupported = 0x08
+)
+
+// SOCKSOptions configures the SOCKS5 endpoint
+type SOCKSOptions struct {
+	// Username and Password, when Username is set, are required from clients
+	// with username/password authentication
+	Username string
+	Password string
+}
+
+// OpenSOCKS starts a SOCKS5 server on an in-memory stream and returns its
+// client end, giving ssh -D semantics for one SOCKS connection
+func (c *Client) OpenSOCKS(options SOCKSOptions) (*ForwardedChannel, error) {
+	c.mu.RLock()
+	connected := c.conn != nil
+	c.mu.RUnlock()
+
+	if !connected {
+		return nil, fmt.Errorf("not connected")
+	}
+
+	local, remote := net.Pipe()
+	go c.ServeSOCKS(remote, options)
+
+	return c.newForwardedChannel(local), nil
+}
+
+// ServeSOCKS handles one SOCKS5 connection on stream, dialing the requested
+// target through the SSH connection. Only CONNECT is supported. Stream is
+// closed once either side has closed.
+func (c *Client) ServeSOCKS(stream io.ReadWriteCloser, options SOCKSOptions) error {
+	defer stream.Close()
+
+	if err := socksAuthenticate(stream, options); err != nil {
+		return err
+	}
+
+	target, err := socksReadRequest(stream)
+	if err != nil {
+		return err
+	}
+
+	c.mu.RLock()
+	conn := c.conn
+	c.mu.RUnlock()
+
+	if conn == nil {
+		socksReply(stream, socksReplyFailure)
+		return fmt.Errorf("not connected")
+	}
+
+	remote, err := conn.Dial("tcp", target)
+	if err != nil {
+		socksReply(stream, socksDialReply(err))
+		return fmt.Errorf("failed to connect to %s: %v", target, err)
+	}
+	defer remote.Close()
+
+	if err := socksReply(stream, socksReplySucceeded); err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+	go func() {
+		io.Copy(remote, stream)
+		// Pass the client's EOF on so the target sees the half-close
+		if cw, ok := remote.(interface{ CloseWrite() error }); ok {
+			cw.CloseWrite()
+		}
+		close(done)
+	}()
+	io.Copy(stream, remote)
+
+	// Unblock the copy from a client that is still sending
+	stream.Close()
+	<-done
+	return nil
+}
+
+// socksAuthenticate negotiates the authentication method and checks the
+// username and password when they are required
+func socksAuthenticate(stream io.ReadWriter, options SOCKSOptions) error {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(stream, header); err != nil {
+		return fmt.Errorf("failed to read SOCKS greeting: %v", err)
+	}
+	if header[0] != socksVersion {
+		return fmt.Errorf("unsupported SOCKS version %d", header[0])
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(stream, methods); err != nil {
+		return fmt.Errorf("failed to read SOCKS methods: %v", err)
+	}
+
+	method := byte(socksAuthNone)
+	if options.Username != "" {
+		method = socksAuthPassword
+	}
+
+	offered := false
+	for _, m := range methods {
+		if m == method {
+			offered = true
+			break
+		}
+	}
+	if !offered {
+		stream.Write([]byte{socksVersion, socksAuthNoAcceptable})
+		return errors.New("no acceptable SOCKS authentication method")
+	}
+	if _, err := stream.Write([]byte{socksVersion, method}); err != nil {
+		return err
+	}
+
+	if method == socksAuthNone {
+		return nil
+	}
+
+	username, password, err := socksReadPassword(stream)
+	if err != nil {
+		return err
+	}
+	userOK := subtle.ConstantTimeCompare([]byte(username), []byte(options.Username)) == 1
+	passwordOK := subtle.ConstantTimeCompare([]byte(password), []byte(options.Password)) == 1
+	if !userOK || !passwordOK {
+		stream.Write([]byte{socksPasswordVersion, 0x01})
+		return errors.New("SOCKS authentication failed")
+	}
+	_, err = stream.Write([]byte{socksPasswordVersion, 0x00})
+	return err
+}
+
+func socksReadPassword(stream io.Reader) (string, string, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(stream, header); err != nil {
+		return "", "", fmt.Errorf("failed to read SOCKS credentials: %v", err)
+	}
+	if header[0] != socksPasswordVersion {
+		return "", "", fmt.Errorf("unsupported SOCKS authentication version %d", header[0])
+	}
+	username := make([]byte, header[1])
+	if _, err := io.ReadFull(stream, username); err != nil {
+		return "", "", fmt.Errorf("failed to read SOCKS credentials: %v", err)
+	}
+
+	length := make([]byte, 1)
+	if _, err := io.ReadFull(stream, length); err != nil {
+		return "", "", fmt.Errorf("failed to read SOCKS credentials: %v", err)
+	}
+	password := make([]byte, length[0])
+	if _, err := io.ReadFull(stream, password); err != nil {
+		return "", "", fmt.Errorf("failed to read SOCKS credentials: %v", err)
+	}
+	return string(username), string(password), nil
+}
+
+// socksReadRequest reads a CONNECT request and returns its target as host:port
+func socksReadRequest(stream io.ReadWriter) (string, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(stream, header); err != nil {
+		return "", fmt.Errorf("failed to read SOCKS request: %v", err)
+	}
+	if header[0] != socksVersion {
+		return "", fmt.Errorf("unsupported SOCKS version %d", header[0])
+	}
+	if header[1] != socksCommandConnect {
+		socksReply(stream, socksReplyNotSupported)
+		return "", fmt.Errorf("unsupported SOCKS command %d", header[1])
+	}
+
+	var host string
+	switch header[3] {
+	case socksAddrIPv4, socksAddrIPv6:
+		size := net.IPv4len
+		if header[3] == socksAddrIPv6 {
+			size = net.IPv6len
+		}
+		ip := make([]byte, size)
+		if _, err := io.ReadFull(stream, ip); err != nil {
+			return "", fmt.Errorf("failed to read SOCKS address: %v", err)
+		}
+		host = net.IP(ip).String()
+	case socksAddrDomain:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(stream, length); err != nil {
+			return "", fmt.Errorf("failed to read SOCKS address: %v", err)
+		}
+		domain := make([]byte, length[0])
+		if _, err := io.ReadFull(stream, domain); err != nil {
+			return "", fmt.Errorf("failed to read SOCKS address: %v", err)
+		}
+		host = string(domain)
+	default:
+		socksReply(stream, socksReplyAddrNotSupported)
+		return "", fmt.Errorf("unsupported SOCKS address type %d", header[3])
+	}
+
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(stream, port); err != nil {
+		return "", fmt.Errorf("failed to read SOCKS port: %v", err)
+	}
+
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))), nil
+}
+
+// socksReply sends a reply with an unspecified bound address, since the
+// channel has no meaningful local address
+func socksReply(stream io.Writer, code byte) error {
+	_, err := stream.Write([]byte{socksVersion, code, 0x00, socksAddrIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
+
+// socksDialReply maps a failed direct-tcpip open to a SOCKS reply code
+func socksDialReply(err error) byte {
+	var openErr *ssh.OpenChannelError
+	if errors.As(err, &openErr) {
+		switch openErr.Reason {
+		case ssh.Prohibited:
+			return socksReplyNotAllowed
+		case ssh.ConnectionFailed:
+			return socksReplyRefused
+		}
+	}
+	return socksReplyFailure
+}
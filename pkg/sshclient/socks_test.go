@@ -0,0 +1,271 @@
+package sshclient
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// socksStream feeds a scripted client message and records the server's replies
+type socksStream struct {
+	io.Reader
+	written bytes.Buffer
+}
+
+func newSOCKSStream(input []byte) *socksStream {
+	return &socksStream{Reader: bytes.NewReader(input)}
+}
+
+func (s *socksStream) Write(p []byte) (int, error) {
+	return s.written.Write(p)
+}
+
+func concatBytes(parts ...[]byte) []byte {
+	var b []byte
+	for _, part := range parts {
+		b = append(b, part...)
+	}
+	return b
+}
+
+func TestSOCKSAuthenticate(t *testing.T) {
+	password := SOCKSOptions{Username: "user", Password: "secret"}
+	credentials := func(username, password string) []byte {
+		b := []byte{socksPasswordVersion, byte(len(username))}
+		b = append(b, username...)
+		b = append(b, byte(len(password)))
+		return append(b, password...)
+	}
+
+	tests := []struct {
+		name    string
+		options SOCKSOptions
+		input   []byte
+		reply   []byte
+		wantErr bool
+	}{
+		{
+			name:  "no authentication",
+			input: []byte{socksVersion, 1, socksAuthNone},
+			reply: []byte{socksVersion, socksAuthNone},
+		},
+		{
+			name:  "no authentication among several",
+			input: []byte{socksVersion, 2, socksAuthPassword, socksAuthNone},
+			reply: []byte{socksVersion, socksAuthNone},
+		},
+		{
+			name:    "no authentication not offered",
+			input:   []byte{socksVersion, 1, socksAuthPassword},
+			reply:   []byte{socksVersion, socksAuthNoAcceptable},
+			wantErr: true,
+		},
+		{
+			name:    "no methods",
+			input:   []byte{socksVersion, 0},
+			reply:   []byte{socksVersion, socksAuthNoAcceptable},
+			wantErr: true,
+		},
+		{
+			name:    "SOCKS4",
+			input:   []byte{0x04, 1, socksAuthNone},
+			wantErr: true,
+		},
+		{
+			name:    "truncated greeting",
+			input:   []byte{socksVersion},
+			wantErr: true,
+		},
+		{
+			name:    "truncated methods",
+			input:   []byte{socksVersion, 3, socksAuthNone},
+			wantErr: true,
+		},
+		{
+			name:    "password",
+			options: password,
+			input:   concatBytes([]byte{socksVersion, 1, socksAuthPassword}, credentials("user", "secret")),
+			reply:   []byte{socksVersion, socksAuthPassword, socksPasswordVersion, 0x00},
+		},
+		{
+			name:    "password required",
+			options: password,
+			input:   []byte{socksVersion, 1, socksAuthNone},
+			reply:   []byte{socksVersion, socksAuthNoAcceptable},
+			wantErr: true,
+		},
+		{
+			name:    "wrong password",
+			options: password,
+			input:   concatBytes([]byte{socksVersion, 1, socksAuthPassword}, credentials("user", "guess")),
+			reply:   []byte{socksVersion, socksAuthPassword, socksPasswordVersion, 0x01},
+			wantErr: true,
+		},
+		{
+			name:    "wrong username",
+			options: password,
+			input:   concatBytes([]byte{socksVersion, 1, socksAuthPassword}, credentials("admin", "secret")),
+			reply:   []byte{socksVersion, socksAuthPassword, socksPasswordVersion, 0x01},
+			wantErr: true,
+		},
+		{
+			name:    "password prefix",
+			options: password,
+			input:   concatBytes([]byte{socksVersion, 1, socksAuthPassword}, credentials("user", "secre")),
+			reply:   []byte{socksVersion, socksAuthPassword, socksPasswordVersion, 0x01},
+			wantErr: true,
+		},
+		{
+			name:    "wrong credentials version",
+			options: password,
+			input:   []byte{socksVersion, 1, socksAuthPassword, 0x05, 4, 'u', 's', 'e', 'r'},
+			reply:   []byte{socksVersion, socksAuthPassword},
+			wantErr: true,
+		},
+		{
+			name:    "truncated password",
+			options: password,
+			input:   []byte{socksVersion, 1, socksAuthPassword, socksPasswordVersion, 4, 'u', 's', 'e', 'r', 6, 's'},
+			reply:   []byte{socksVersion, socksAuthPassword},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stream := newSOCKSStream(tt.input)
+			err := socksAuthenticate(stream, tt.options)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("socksAuthenticate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := stream.written.Bytes(); !bytes.Equal(got, tt.reply) {
+				t.Errorf("replied %v, want %v", got, tt.reply)
+			}
+		})
+	}
+}
+
+func TestSOCKSReadRequest(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   []byte
+		target  string
+		reply   byte
+		wantErr bool
+	}{
+		{
+			name:   "IPv4",
+			input:  []byte{socksVersion, socksCommandConnect, 0, socksAddrIPv4, 10, 0, 0, 1, 0, 80},
+			target: "10.0.0.1:80",
+		},
+		{
+			name: "IPv6",
+			input: concatBytes(
+				[]byte{socksVersion, socksCommandConnect, 0, socksAddrIPv6},
+				[]byte{0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1},
+				[]byte{0x01, 0xbb},
+			),
+			target: "[2001:db8::1]:443",
+		},
+		{
+			name:   "domain",
+			input:  concatBytes([]byte{socksVersion, socksCommandConnect, 0, socksAddrDomain, 11}, []byte("example.com"), []byte{0x1f, 0x90}),
+			target: "example.com:8080",
+		},
+		{
+			name:   "empty domain",
+			input:  []byte{socksVersion, socksCommandConnect, 0, socksAddrDomain, 0, 0, 22},
+			target: ":22",
+		},
+		{
+			name:   "highest port",
+			input:  []byte{socksVersion, socksCommandConnect, 0, socksAddrIPv4, 127, 0, 0, 1, 0xff, 0xff},
+			target: "127.0.0.1:65535",
+		},
+		{
+			name:    "wrong version",
+			input:   []byte{0x04, socksCommandConnect, 0, socksAddrIPv4, 10, 0, 0, 1, 0, 80},
+			wantErr: true,
+		},
+		{
+			name:    "BIND",
+			input:   []byte{socksVersion, 0x02, 0, socksAddrIPv4, 10, 0, 0, 1, 0, 80},
+			reply:   socksReplyNotSupported,
+			wantErr: true,
+		},
+		{
+			name:    "UDP ASSOCIATE",
+			input:   []byte{socksVersion, 0x03, 0, socksAddrIPv4, 10, 0, 0, 1, 0, 80},
+			reply:   socksReplyNotSupported,
+			wantErr: true,
+		},
+		{
+			name:    "unknown address type",
+			input:   []byte{socksVersion, socksCommandConnect, 0, 0x02, 10, 0, 0, 1, 0, 80},
+			reply:   socksReplyAddrNotSupported,
+			wantErr: true,
+		},
+		{
+			name:    "truncated header",
+			input:   []byte{socksVersion, socksCommandConnect},
+			wantErr: true,
+		},
+		{
+			name:    "truncated address",
+			input:   []byte{socksVersion, socksCommandConnect, 0, socksAddrIPv4, 10, 0},
+			wantErr: true,
+		},
+		{
+			name:    "truncated domain",
+			input:   []byte{socksVersion, socksCommandConnect, 0, socksAddrDomain, 11, 'e', 'x'},
+			wantErr: true,
+		},
+		{
+			name:    "missing port",
+			input:   []byte{socksVersion, socksCommandConnect, 0, socksAddrIPv4, 10, 0, 0, 1, 0},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stream := newSOCKSStream(tt.input)
+			target, err := socksReadRequest(stream)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("socksReadRequest() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if target != tt.target {
+				t.Errorf("target = %q, want %q", target, tt.target)
+			}
+
+			var reply []byte
+			if tt.reply != 0 {
+				reply = []byte{socksVersion, tt.reply, 0x00, socksAddrIPv4, 0, 0, 0, 0, 0, 0}
+			}
+			if got := stream.written.Bytes(); !bytes.Equal(got, reply) {
+				t.Errorf("replied %v, want %v", got, reply)
+			}
+		})
+	}
+}
+
+func TestSOCKSDialReply(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want byte
+	}{
+		{"prohibited", &ssh.OpenChannelError{Reason: ssh.Prohibited}, socksReplyNotAllowed},
+		{"connection failed", &ssh.OpenChannelError{Reason: ssh.ConnectionFailed}, socksReplyRefused},
+		{"unknown channel type", &ssh.OpenChannelError{Reason: ssh.UnknownChannelType}, socksReplyFailure},
+		{"other error", errors.New("connection lost"), socksReplyFailure},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := socksDialReply(tt.err); got != tt.want {
+				t.Errorf("socksDialReply() = %#x, want %#x", got, tt.want)
+			}
+		})
+	}
+}
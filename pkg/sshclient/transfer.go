@@ -0,0 +1,395 @@
+package sshclient
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/andrew/sshclient-wasm/pkg/sftp"
+)
+
+// Ways to compute the remote SHA-256 after a transfer
+const (
+	VerifyNone      = ""
+	VerifyAuto      = "auto"
+	VerifyCheckFile = "check-file"
+	VerifyExec      = "exec"
+)
+
+// ErrChecksumMismatch is wrapped by the error of a transfer whose remote
+// SHA-256 differs from the local one
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// TransferCheckpoint is the progress of a transfer. Everything before
+// Offset has been acknowledged, so it can be stored and resumed from.
+type TransferCheckpoint struct {
+	Path   string
+	Offset int64
+	// Size is -1 while the size of a download is unknown
+	Size int64
+}
+
+// TransferOptions configures a resumable transfer job
+type TransferOptions struct {
+	// ChunkSize is the size of each request, 32 KiB by default
+	ChunkSize int
+	// MaxOutstanding is the number of requests in flight, 16 by default
+	MaxOutstanding int
+	// Offset resumes from a stored checkpoint
+	Offset int64
+	// Resume continues an upload from the size of the remote file
+	Resume bool
+	// OnCheckpoint is called after every acknowledged chunk
+	OnCheckpoint func(checkpoint TransferCheckpoint)
+	// Verify selects how the remote SHA-256 is computed, VerifyNone to skip it
+	Verify string
+	// SHA256 is the expected digest; without it the local data is hashed
+	SHA256 []byte
+}
+
+// TransferResult describes a completed transfer
+type TransferResult struct {
+	Size int64
+	// ResumedFrom is the offset the last run started at
+	ResumedFrom int64
+	// SHA256 is the remote digest when verification ran
+	SHA256 []byte
+	// VerifiedBy is VerifyCheckFile or VerifyExec when verification ran
+	VerifiedBy string
+	// VerifiedFrom is the offset verification started at. It is 0 unless a
+	// download resumed by a new job had no way to reread what was already
+	// written, in which case only the data transferred by this run is checked.
+	VerifiedFrom int64
+}
+
+// TransferJob is an SFTP upload or download that can be resumed after the
+// connection drops, on this client or on a new one given its checkpoint
+type TransferJob struct {
+	client  *Client
+	path    string
+	src     io.ReaderAt
+	dst     io.WriterAt
+	size    int64
+	options TransferOptions
+	offset  int64
+	restart bool
+	// hashing carries the hash of a download across runs of this job
+	hashing *hashingWriterAt
+	// cancel is closed by Cancel and replaced once the run it stopped ends
+	cancel chan struct{}
+	mu     sync.Mutex
+}
+
+// NewUploadJob prepares an upload of size bytes of src to path
+func (c *Client) NewUploadJob(path string, src io.ReaderAt, size int64, options TransferOptions) *TransferJob {
+	return &TransferJob{
+		client:  c,
+		path:    path,
+		src:     src,
+		size:    size,
+		options: options,
+		offset:  options.Offset,
+		cancel:  make(chan struct{}),
+	}
+}
+
+// NewDownloadJob prepares a download of path into dst
+func (c *Client) NewDownloadJob(path string, dst io.WriterAt, options TransferOptions) *TransferJob {
+	return &TransferJob{
+		client:  c,
+		path:    path,
+		dst:     dst,
+		size:    -1,
+		options: options,
+		offset:  options.Offset,
+		cancel:  make(chan struct{}),
+	}
+}
+
+// Checkpoint returns the current progress
+func (j *TransferJob) Checkpoint() TransferCheckpoint {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return TransferCheckpoint{Path: j.path, Offset: j.offset, Size: j.size}
+}
+
+// Cancel stops the transfer after the requests in flight complete. Run can
+// be called again afterwards to resume.
+func (j *TransferJob) Cancel() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if !isClosedChan(j.cancel) {
+		close(j.cancel)
+	}
+}
+
+// rearm lets the next run go ahead after a cancelled one
+func (j *TransferJob) rearm() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if isClosedChan(j.cancel) {
+		j.cancel = make(chan struct{})
+	}
+}
+
+func (j *TransferJob) commit(offset int64) {
+	j.mu.Lock()
+	j.offset = offset
+	checkpoint := TransferCheckpoint{Path: j.path, Offset: j.offset, Size: j.size}
+	j.mu.Unlock()
+
+	if j.options.OnCheckpoint != nil {
+		j.options.OnCheckpoint(checkpoint)
+	}
+}
+
+func (j *TransferJob) pipelineOptions() sftp.PipelineOptions {
+	j.mu.Lock()
+	cancel := j.cancel
+	j.mu.Unlock()
+
+	return sftp.PipelineOptions{
+		ChunkSize:      j.options.ChunkSize,
+		MaxOutstanding: j.options.MaxOutstanding,
+		OnCommit:       j.commit,
+		Cancel:         cancel,
+	}
+}
+
+// Run transfers the remaining data and verifies it. After a failure or
+// Cancel it can be called again to resume from the checkpoint.
+func (j *TransferJob) Run() (TransferResult, error) {
+	defer j.rearm()
+
+	client, err := j.client.SFTP()
+	if err != nil {
+		return TransferResult{}, err
+	}
+	if j.src != nil {
+		return j.upload(client)
+	}
+	return j.download(client)
+}
+
+func (j *TransferJob) upload(client *sftp.Client) (TransferResult, error) {
+	j.mu.Lock()
+	start, restart := j.offset, j.restart
+	j.restart = false
+	j.mu.Unlock()
+	if !restart && (j.options.Resume || start > 0) {
+		info, err := client.Stat(j.path)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			start = 0
+		case err != nil:
+			return TransferResult{}, err
+		case info.Size() > j.size:
+			// Not a prefix of this upload, so start over
+			start = 0
+		case start == 0 || start > info.Size():
+			start = info.Size()
+		}
+	}
+
+	flag := os.O_WRONLY | os.O_CREATE
+	if start == 0 {
+		flag |= os.O_TRUNC
+	}
+	f, err := client.OpenFile(j.path, flag)
+	if err != nil {
+		return TransferResult{}, err
+	}
+
+	j.commit(start)
+	committed, err := f.PipelinedWrite(j.src, start, j.size, j.pipelineOptions())
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	result := TransferResult{Size: committed, ResumedFrom: start}
+	if err != nil {
+		return result, fmt.Errorf("upload interrupted at offset %d: %w", committed, err)
+	}
+
+	if j.options.Verify == VerifyNone {
+		return result, nil
+	}
+
+	expected := j.options.SHA256
+	if expected == nil {
+		h := sha256.New()
+		if _, err := io.Copy(h, io.NewSectionReader(j.src, 0, j.size)); err != nil {
+			return result, fmt.Errorf("failed to hash source: %v", err)
+		}
+		expected = h.Sum(nil)
+	}
+	return j.verify(client, result, expected)
+}
+
+func (j *TransferJob) download(client *sftp.Client) (TransferResult, error) {
+	f, err := client.Open(j.path)
+	if err != nil {
+		return TransferResult{}, err
+	}
+	defer f.Close()
+
+	size := int64(-1)
+	if info, err := f.Stat(); err == nil && info.Sys().(*sftp.Attributes).HasSize() {
+		size = info.Size()
+	}
+	j.mu.Lock()
+	j.size = size
+	j.mu.Unlock()
+
+	start := j.Checkpoint().Offset
+	if size >= 0 && start > size {
+		// The remote file shrank since the checkpoint, so start over
+		start = 0
+	}
+
+	// Data arrives in order, so it can be hashed on the way
+	dst := j.dst
+	var hashing *hashingWriterAt
+	if j.options.Verify != VerifyNone && j.options.SHA256 == nil {
+		hashing, err = j.resumeHash(start)
+		if err != nil {
+			return TransferResult{}, err
+		}
+		dst = hashing
+	}
+
+	j.commit(start)
+	committed, err := f.PipelinedRead(dst, start, size, j.pipelineOptions())
+	result := TransferResult{Size: committed, ResumedFrom: start}
+	if err != nil {
+		return result, fmt.Errorf("download interrupted at offset %d: %w", committed, err)
+	}
+
+	if j.options.Verify == VerifyNone {
+		return result, nil
+	}
+
+	expected := j.options.SHA256
+	if hashing != nil {
+		expected = hashing.hash.Sum(nil)
+		result.VerifiedFrom = hashing.from
+	}
+	return j.verify(client, result, expected)
+}
+
+// resumeHash returns the hashing writer for a download starting at start. A
+// resumed run continues the hash of the previous one, or hashes what dst
+// already holds when it can be read back. Failing both, only the data from
+// start on is hashed and verified.
+func (j *TransferJob) resumeHash(start int64) (*hashingWriterAt, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if start > 0 && j.hashing != nil && j.hashing.offset == start {
+		return j.hashing, nil
+	}
+
+	hashing := &hashingWriterAt{dst: j.dst, hash: sha256.New(), offset: start}
+	if start > 0 {
+		if r, ok := j.dst.(io.ReaderAt); ok {
+			if _, err := io.Copy(hashing.hash, io.NewSectionReader(r, 0, start)); err != nil {
+				return nil, fmt.Errorf("failed to hash the downloaded data: %v", err)
+			}
+		} else {
+			hashing.from = start
+		}
+	}
+	j.hashing = hashing
+	return hashing, nil
+}
+
+// verify compares the remote SHA-256 with expected. On a mismatch the
+// checkpoint is reset so the next run starts over, even with Resume set.
+func (j *TransferJob) verify(client *sftp.Client, result TransferResult, expected []byte) (TransferResult, error) {
+	sum, method, err := j.client.remoteSHA256(client, j.path, j.options.Verify, result.VerifiedFrom)
+	if err != nil {
+		return result, fmt.Errorf("failed to verify %s: %v", j.path, err)
+	}
+	result.SHA256 = sum
+	result.VerifiedBy = method
+
+	if !bytes.Equal(sum, expected) {
+		j.mu.Lock()
+		j.offset = 0
+		j.restart = true
+		j.mu.Unlock()
+		return result, fmt.Errorf("%w for %s: expected %x, remote %x", ErrChecksumMismatch, j.path, expected, sum)
+	}
+	return result, nil
+}
+
+// remoteSHA256 hashes a remote file from offset on with the check-file
+// extension or sha256sum, as selected by mode. VerifyAuto prefers check-file
+// and falls back to exec when the server refuses it.
+func (c *Client) remoteSHA256(client *sftp.Client, path, mode string, offset int64) ([]byte, string, error) {
+	switch mode {
+	case VerifyAuto, VerifyCheckFile:
+		sum, err := client.CheckFileRange(path, "sha256", uint64(offset), 0)
+		if err == nil {
+			return sum, VerifyCheckFile, nil
+		}
+		var statusErr *sftp.StatusError
+		if mode == VerifyCheckFile || !errors.As(err, &statusErr) {
+			return nil, "", err
+		}
+	case VerifyExec:
+	default:
+		return nil, "", fmt.Errorf("unknown verify mode: %s", mode)
+	}
+
+	command := "sha256sum -- " + shellQuote(path)
+	if offset > 0 {
+		// tail counts bytes from 1
+		command = fmt.Sprintf("tail -c +%d -- %s | sha256sum", offset+1, shellQuote(path))
+	}
+	result, err := c.Exec(command, ExecOptions{})
+	if err != nil {
+		return nil, "", err
+	}
+	if result.ExitCode != 0 {
+		return nil, "", fmt.Errorf("sha256sum exited with code %d: %s", result.ExitCode, strings.TrimSpace(string(result.Stderr)))
+	}
+
+	fields := strings.Fields(string(result.Stdout))
+	if len(fields) == 0 {
+		return nil, "", errors.New("sha256sum printed no digest")
+	}
+	sum, err := hex.DecodeString(strings.TrimPrefix(fields[0], "\\"))
+	if err != nil || len(sum) != sha256.Size {
+		return nil, "", fmt.Errorf("unexpected sha256sum output: %q", fields[0])
+	}
+	return sum, VerifyExec, nil
+}
+
+// shellQuote quotes s for a POSIX shell
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// hashingWriterAt hashes data written in order while passing it on. The
+// hash covers everything from from up to offset.
+type hashingWriterAt struct {
+	dst    io.WriterAt
+	hash   hash.Hash
+	from   int64
+	offset int64
+}
+
+func (w *hashingWriterAt) WriteAt(p []byte, off int64) (int, error) {
+	n, err := w.dst.WriteAt(p, off)
+	w.hash.Write(p[:n])
+	w.offset = off + int64(n)
+	return n, err
+}
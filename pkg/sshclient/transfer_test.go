@@ -0,0 +1,390 @@
+package sshclient
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/andrew/sshclient-wasm/internal/sftptest"
+	"github.com/andrew/sshclient-wasm/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+var (
+	sha256sumCommand = regexp.MustCompile(`^sha256sum -- '(.*)'$`)
+	tailCommand      = regexp.MustCompile(`^tail -c \+(\d+) -- '(.*)' \| sha256sum$`)
+)
+
+// transferServer is an SSH server whose sftp subsystem is an in-memory
+// sftptest.Server and whose exec only knows the verify commands
+type transferServer struct {
+	files *sftptest.Server
+	mu    sync.Mutex
+	// sessions holds the options of the next sftp sessions; the last one
+	// is used for every session after it
+	sessions []sftptest.Options
+	execs    []string
+}
+
+func newTransferServer(sessions ...sftptest.Options) *transferServer {
+	if len(sessions) == 0 {
+		sessions = []sftptest.Options{{}}
+	}
+	return &transferServer{files: sftptest.NewServer(), sessions: sessions}
+}
+
+func (s *transferServer) nextOptions() sftptest.Options {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	options := s.sessions[0]
+	if len(s.sessions) > 1 {
+		s.sessions = s.sessions[1:]
+	}
+	return options
+}
+
+func (s *transferServer) commands() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.execs...)
+}
+
+// connect returns a client connected to the server over loopback TCP
+func (s *transferServer) connect(t *testing.T) *Client {
+	t.Helper()
+	config := &ssh.ServerConfig{NoClientAuth: true}
+	config.AddHostKey(newTestSigner(t))
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		_, chans, reqs, err := ssh.NewServerConn(conn, config)
+		if err != nil {
+			return
+		}
+		go ssh.DiscardRequests(reqs)
+		for newChannel := range chans {
+			ch, requests, err := newChannel.Accept()
+			if err != nil {
+				continue
+			}
+			go s.serveChannel(ch, requests)
+		}
+	}()
+
+	transport, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := New(ConnectionOptions{Host: "example.com", Port: 22, User: "user", HostKey: HostKeyPolicy{InsecureIgnoreHostKey: true}})
+	c.SetTransport(transport)
+	if _, err := c.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Disconnect() })
+	return c
+}
+
+func (s *transferServer) serveChannel(ch ssh.Channel, requests <-chan *ssh.Request) {
+	for req := range requests {
+		var payload struct{ Value string }
+		if err := ssh.Unmarshal(req.Payload, &payload); err != nil {
+			req.Reply(false, nil)
+			continue
+		}
+		switch {
+		case req.Type == "subsystem" && payload.Value == "sftp":
+			req.Reply(true, nil)
+			go func() {
+				s.files.Serve(ch, ch, s.nextOptions())
+				ch.Close()
+			}()
+		case req.Type == "exec":
+			req.Reply(true, nil)
+			go s.exec(ch, payload.Value)
+		default:
+			req.Reply(false, nil)
+		}
+	}
+}
+
+// exec answers the sha256sum and tail -c commands verification runs
+func (s *transferServer) exec(ch ssh.Channel, command string) {
+	s.mu.Lock()
+	s.execs = append(s.execs, command)
+	s.mu.Unlock()
+
+	var name string
+	var offset int64
+	if m := sha256sumCommand.FindStringSubmatch(command); m != nil {
+		name = m[1]
+	} else if m := tailCommand.FindStringSubmatch(command); m != nil {
+		start, _ := strconv.ParseInt(m[1], 10, 64)
+		name, offset = m[2], start-1
+	}
+	name = strings.ReplaceAll(name, `'\''`, `'`)
+
+	status := uint32(0)
+	if sum, ok := s.files.SHA256(name, offset); name != "" && ok {
+		fmt.Fprintf(ch, "%x  -\n", sum)
+	} else {
+		fmt.Fprintf(ch.Stderr(), "%s: cannot hash\n", command)
+		status = 1
+	}
+	ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+	ch.Close()
+}
+
+// memFile is an in-memory io.ReaderAt and io.WriterAt
+type memFile struct {
+	data []byte
+}
+
+func (m *memFile) WriteAt(p []byte, off int64) (int, error) {
+	if end := off + int64(len(p)); end > int64(len(m.data)) {
+		m.data = append(m.data, make([]byte, end-int64(len(m.data)))...)
+	}
+	return copy(m.data[off:], p), nil
+}
+
+func (m *memFile) ReadAt(p []byte, off int64) (int, error) {
+	return bytes.NewReader(m.data).ReadAt(p, off)
+}
+
+// writeOnly hides ReadAt, like a destination that cannot be read back
+type writeOnly struct {
+	io.WriterAt
+}
+
+func transferData(n int) []byte {
+	data := make([]byte, n)
+	rand.New(rand.NewSource(int64(n))).Read(data)
+	return data
+}
+
+func TestUploadResumesAfterCut(t *testing.T) {
+	data := transferData(1 << 20)
+	server := newTransferServer(sftptest.Options{CloseAfter: 300000, Reorder: true}, sftptest.Options{})
+	c := server.connect(t)
+
+	var checkpoints []TransferCheckpoint
+	job := c.NewUploadJob("/upload.bin", bytes.NewReader(data), int64(len(data)), TransferOptions{
+		ChunkSize:      16384,
+		MaxOutstanding: 8,
+		Verify:         VerifyAuto,
+		OnCheckpoint: func(checkpoint TransferCheckpoint) {
+			checkpoints = append(checkpoints, checkpoint)
+		},
+	})
+
+	if _, err := job.Run(); err == nil {
+		t.Fatal("upload survived the cut")
+	}
+	checkpoint := job.Checkpoint()
+	if checkpoint.Offset <= 0 || checkpoint.Offset > 300000 || checkpoint.Size != int64(len(data)) {
+		t.Fatalf("checkpoint after the cut = %+v", checkpoint)
+	}
+	if last := checkpoints[len(checkpoints)-1]; last != checkpoint {
+		t.Errorf("last reported checkpoint %+v, job has %+v", last, checkpoint)
+	}
+
+	// The same job resumes over a new sftp session on the same connection
+	result, err := job.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.ResumedFrom != checkpoint.Offset || result.Size != int64(len(data)) {
+		t.Errorf("result = %+v, want resumed from %d", result, checkpoint.Offset)
+	}
+	if got, _ := server.files.ReadFile("/upload.bin"); !bytes.Equal(got, data) {
+		t.Fatal("resumed upload differs from the source")
+	}
+	// Without check-file the digest comes from sha256sum
+	if result.VerifiedBy != VerifyExec {
+		t.Errorf("verified by %q, want exec", result.VerifiedBy)
+	}
+	if commands := server.commands(); len(commands) != 1 || commands[0] != "sha256sum -- '/upload.bin'" {
+		t.Errorf("ran %q", commands)
+	}
+}
+
+func TestUploadResumesOnNewConnection(t *testing.T) {
+	data := transferData(600000)
+	server := newTransferServer(sftptest.Options{CloseAfter: 200000}, sftptest.Options{CheckFile: true})
+	options := TransferOptions{ChunkSize: 8192, Verify: VerifyAuto}
+
+	job := server.connect(t).NewUploadJob("/upload.bin", bytes.NewReader(data), int64(len(data)), options)
+	if _, err := job.Run(); err == nil {
+		t.Fatal("upload survived the cut")
+	}
+	checkpoint := job.Checkpoint()
+
+	// A stored checkpoint resumes on another client
+	options.Offset = checkpoint.Offset
+	job = server.connect(t).NewUploadJob("/upload.bin", bytes.NewReader(data), int64(len(data)), options)
+	result, err := job.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.ResumedFrom != checkpoint.Offset || result.VerifiedBy != VerifyCheckFile {
+		t.Errorf("result = %+v, want resumed from %d and verified by check-file", result, checkpoint.Offset)
+	}
+	if got, _ := server.files.ReadFile("/upload.bin"); !bytes.Equal(got, data) {
+		t.Fatal("resumed upload differs from the source")
+	}
+}
+
+func TestDownloadResumesAfterCut(t *testing.T) {
+	data := transferData(1<<20 + 17)
+	tests := []struct {
+		name string
+		// newJob is true to resume with a new job from the checkpoint
+		newJob       bool
+		dst          func(*memFile) io.WriterAt
+		verifiedFrom bool
+		command      string
+	}{
+		{"same job", false, func(m *memFile) io.WriterAt { return writeOnly{m} }, false, "sha256sum -- '/download.bin'"},
+		{"new job rereading the destination", true, func(m *memFile) io.WriterAt { return m }, false, "sha256sum -- '/download.bin'"},
+		{"new job without reading back", true, func(m *memFile) io.WriterAt { return writeOnly{m} }, true, "tail -c +%d -- '/download.bin' | sha256sum"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newTransferServer(sftptest.Options{CloseAfter: 400000, MaxRead: 5000}, sftptest.Options{})
+			server.files.WriteFile("/download.bin", data)
+			c := server.connect(t)
+
+			dst := &memFile{}
+			options := TransferOptions{ChunkSize: 16384, MaxOutstanding: 8, Verify: VerifyAuto}
+			job := c.NewDownloadJob("/download.bin", tt.dst(dst), options)
+			if _, err := job.Run(); err == nil {
+				t.Fatal("download survived the cut")
+			}
+			checkpoint := job.Checkpoint()
+			if checkpoint.Offset <= 0 || checkpoint.Offset > 400000 || checkpoint.Size != int64(len(data)) {
+				t.Fatalf("checkpoint after the cut = %+v", checkpoint)
+			}
+			if !bytes.Equal(dst.data[:checkpoint.Offset], data[:checkpoint.Offset]) {
+				t.Fatal("data before the checkpoint differs from the source")
+			}
+
+			if tt.newJob {
+				options.Offset = checkpoint.Offset
+				job = c.NewDownloadJob("/download.bin", tt.dst(dst), options)
+			}
+			result, err := job.Run()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(dst.data, data) {
+				t.Fatal("resumed download differs from the source")
+			}
+			if result.ResumedFrom != checkpoint.Offset || result.VerifiedBy != VerifyExec {
+				t.Errorf("result = %+v, want resumed from %d and verified by exec", result, checkpoint.Offset)
+			}
+			command := tt.command
+			if tt.verifiedFrom {
+				if result.VerifiedFrom != checkpoint.Offset {
+					t.Errorf("verified from %d, want %d", result.VerifiedFrom, checkpoint.Offset)
+				}
+				command = fmt.Sprintf(command, checkpoint.Offset+1)
+			} else if result.VerifiedFrom != 0 {
+				t.Errorf("verified from %d, want the whole file", result.VerifiedFrom)
+			}
+			if commands := server.commands(); len(commands) != 1 || commands[0] != command {
+				t.Errorf("ran %q, want %q", commands, command)
+			}
+		})
+	}
+}
+
+func TestTransferCancelAndRerun(t *testing.T) {
+	data := transferData(500000)
+	server := newTransferServer(sftptest.Options{CheckFile: true})
+	c := server.connect(t)
+
+	var job *TransferJob
+	canceled := false
+	job = c.NewUploadJob("/upload.bin", bytes.NewReader(data), int64(len(data)), TransferOptions{
+		ChunkSize: 8192,
+		Verify:    VerifyCheckFile,
+		OnCheckpoint: func(checkpoint TransferCheckpoint) {
+			if checkpoint.Offset >= 100000 && !canceled {
+				canceled = true
+				job.Cancel()
+			}
+		},
+	})
+
+	if _, err := job.Run(); !errors.Is(err, sftp.ErrCanceled) {
+		t.Fatalf("err = %v, want sftp.ErrCanceled", err)
+	}
+	offset := job.Checkpoint().Offset
+	if offset < 100000 || offset >= int64(len(data)) {
+		t.Fatalf("canceled at offset %d", offset)
+	}
+
+	// Cancel only stops the run in progress
+	result, err := job.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.ResumedFrom != offset || result.VerifiedBy != VerifyCheckFile {
+		t.Errorf("result = %+v, want resumed from %d", result, offset)
+	}
+	if got, _ := server.files.ReadFile("/upload.bin"); !bytes.Equal(got, data) {
+		t.Fatal("upload differs from the source")
+	}
+}
+
+func TestUploadRestartsAfterChecksumMismatch(t *testing.T) {
+	data := transferData(300000)
+	server := newTransferServer(sftptest.Options{CloseAfter: 100000}, sftptest.Options{CheckFile: true})
+	c := server.connect(t)
+
+	job := c.NewUploadJob("/upload.bin", bytes.NewReader(data), int64(len(data)), TransferOptions{ChunkSize: 8192, Verify: VerifyAuto, Resume: true})
+	if _, err := job.Run(); err == nil {
+		t.Fatal("upload survived the cut")
+	}
+
+	// Corrupt the part that was already uploaded, so resuming keeps a bad prefix
+	partial, _ := server.files.ReadFile("/upload.bin")
+	partial[0] ^= 0xff
+	server.files.WriteFile("/upload.bin", partial)
+
+	result, err := job.Run()
+	if !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("err = %v, want ErrChecksumMismatch", err)
+	}
+	if result.ResumedFrom == 0 || job.Checkpoint().Offset != 0 {
+		t.Fatalf("resumed from %d, checkpoint %d after the mismatch", result.ResumedFrom, job.Checkpoint().Offset)
+	}
+
+	// Resume would pick up the remote size again; the mismatch forces a restart
+	result, err = job.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.ResumedFrom != 0 {
+		t.Errorf("resumed from %d after a mismatch, want a restart", result.ResumedFrom)
+	}
+	if got, _ := server.files.ReadFile("/upload.bin"); !bytes.Equal(got, data) {
+		t.Fatal("restarted upload differs from the source")
+	}
+}
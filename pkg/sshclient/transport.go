@@ -2,8 +2,10 @@ package sshclient
 
 import (
 	"errors"
+	"fmt"
 	"io"
 	"net"
+	"os"
 	"sync"
 	"time"
 )
@@ -26,18 +28,50 @@ type Transport interface {
 
 // JSTransport wraps JavaScript transport callbacks to implement Transport interface
 type JSTransport struct {
-	id          string
-	onWrite     func([]byte) error
-	onClose     func() error
-	readBuffer  []byte
-	readChan    chan []byte
-	closeChan   chan struct{}
-	closed      bool
-	mu          sync.Mutex
-	localAddr   net.Addr
-	remoteAddr  net.Addr
+	id         string
+	onWrite    func([]byte) error
+	onClose    func() error
+	closeChan  chan struct{}
+	closed     bool
+	mu         sync.Mutex
+	localAddr  net.Addr
+	remoteAddr net.Addr
+	// writing holds the single write in flight, so writes stay in order even
+	// after one gives up at its deadline
+	writing       chan struct{}
+	readDeadline  *deadline
+	writeDeadline *deadline
+	// readQueue holds injected data not yet read, buffered bytes in total
+	readQueue     [][]byte
+	buffered      int
+	highWaterMark int
+	// readable signals Read that data was queued
+	readable chan struct{}
+	// room is closed once buffered drops below the high-water mark
+	room chan struct{}
+	// readErr is returned by Read once the queue is drained, after the
+	// remote end stopped sending
+	readErr error
+	// broken is set once a write timed out after onWrite took its data
+	broken bool
 }
 
+// ErrTransportBroken is returned by every Read and Write on a JSTransport
+// after a write timed out while onWrite still held its data. Those bytes
+// may yet be sent, so the stream can no longer be trusted.
+var ErrTransportBroken = errors.New("transport broken by a timed-out write")
+
+// DefaultHighWaterMark is how many received bytes a JSTransport buffers
+// before InjectData callers are asked to wait
+const DefaultHighWaterMark = 1 << 20
+
+// closedChan is a channel that is always ready
+var closedChan = func() chan struct{} {
+	c := make(chan struct{})
+	close(c)
+	return c
+}()
+
 // TransportAddr implements net.Addr for JS transports
 type TransportAddr struct {
 	network string
@@ -55,11 +89,15 @@ func (a *TransportAddr) String() string {
 // NewJSTransport creates a new JavaScript-backed transport
 func NewJSTransport(id string, onWrite func([]byte) error, onClose func() error) *JSTransport {
 	return &JSTransport{
-		id:       id,
-		onWrite:  onWrite,
-		onClose:  onClose,
-		readChan: make(chan []byte, 100),
-		closeChan: make(chan struct{}),
+		id:            id,
+		onWrite:       onWrite,
+		onClose:       onClose,
+		closeChan:     make(chan struct{}),
+		highWaterMark: DefaultHighWaterMark,
+		readable:      make(chan struct{}, 1),
+		writing:       make(chan struct{}, 1),
+		readDeadline:  newDeadline(),
+		writeDeadline: newDeadline(),
 		localAddr: &TransportAddr{
 			network: "js",
 			address: "browser",
@@ -73,50 +111,114 @@ func NewJSTransport(id string, onWrite func([]byte) error, onClose func() error)
 
 // Read reads data from the transport
 func (t *JSTransport) Read(p []byte) (n int, err error) {
-	t.mu.Lock()
-	if t.closed {
-		t.mu.Unlock()
-		return 0, io.EOF
-	}
-	t.mu.Unlock()
+	for {
+		t.mu.Lock()
+		if t.closed {
+			t.mu.Unlock()
+			return 0, t.closedErr(io.EOF)
+		}
+		if isClosedChan(t.readDeadline.wait()) {
+			t.mu.Unlock()
+			return 0, os.ErrDeadlineExceeded
+		}
 
-	// If we have buffered data, return it first
-	if len(t.readBuffer) > 0 {
-		n = copy(p, t.readBuffer)
-		t.readBuffer = t.readBuffer[n:]
-		return n, nil
-	}
+		if len(t.readQueue) > 0 {
+			n = copy(p, t.readQueue[0])
+			if n < len(t.readQueue[0]) {
+				t.readQueue[0] = t.readQueue[0][n:]
+			} else {
+				t.readQueue[0] = nil
+				t.readQueue = t.readQueue[1:]
+			}
+			t.buffered -= n
+			if t.room != nil && t.buffered < t.highWaterMark {
+				close(t.room)
+				t.room = nil
+			}
+			t.mu.Unlock()
+			return n, nil
+		}
+		if t.readErr != nil {
+			t.mu.Unlock()
+			return 0, t.readErr
+		}
+		t.mu.Unlock()
 
-	// Wait for new data or close
-	select {
-	case data := <-t.readChan:
-		n = copy(p, data)
-		if n < len(data) {
-			// Buffer remaining data
-			t.readBuffer = data[n:]
+		// Wait for new data or close
+		select {
+		case <-t.readable:
+		case <-t.closeChan:
+			return 0, t.closedErr(io.EOF)
+		case <-t.readDeadline.wait():
+			return 0, os.ErrDeadlineExceeded
 		}
-		return n, nil
-	case <-t.closeChan:
-		return 0, io.EOF
 	}
 }
 
-// Write writes data to the transport
+// Write writes data to the transport. onWrite blocking is how the JS side
+// applies backpressure; the write deadline bounds how long Write waits for it.
+// A write that times out while onWrite holds its data may still be delivered
+// later, so it closes the transport and later calls fail with
+// ErrTransportBroken.
 func (t *JSTransport) Write(p []byte) (n int, err error) {
 	t.mu.Lock()
 	if t.closed {
 		t.mu.Unlock()
-		return 0, errors.New("transport closed")
+		return 0, t.closedErr(errors.New("transport closed"))
 	}
 	t.mu.Unlock()
 
-	if t.onWrite != nil {
-		err = t.onWrite(p)
+	if t.onWrite == nil {
+		return len(p), nil
+	}
+
+	deadline := t.writeDeadline.wait()
+	if isClosedChan(deadline) {
+		return 0, os.ErrDeadlineExceeded
+	}
+
+	select {
+	case t.writing <- struct{}{}:
+	case <-t.closeChan:
+		return 0, t.closedErr(errors.New("transport closed"))
+	case <-deadline:
+		return 0, os.ErrDeadlineExceeded
+	}
+
+	// The write may outlive this call, so it gets its own copy
+	data := append([]byte(nil), p...)
+	done := make(chan error, 1)
+	go func() {
+		done <- t.onWrite(data)
+		<-t.writing
+	}()
+
+	select {
+	case err := <-done:
 		if err != nil {
 			return 0, err
 		}
+		return len(p), nil
+	case <-t.closeChan:
+		return 0, t.closedErr(errors.New("transport closed"))
+	case <-deadline:
+		t.mu.Lock()
+		t.broken = true
+		t.mu.Unlock()
+		t.Close()
+		return 0, fmt.Errorf("%w: %w", os.ErrDeadlineExceeded, ErrTransportBroken)
+	}
+}
+
+// closedErr is what Read and Write return on a closed transport, err unless
+// a timed-out write broke it
+func (t *JSTransport) closedErr(err error) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.broken {
+		return ErrTransportBroken
 	}
-	return len(p), nil
+	return err
 }
 
 // Close closes the transport
@@ -127,6 +229,12 @@ func (t *JSTransport) Close() error {
 		return nil
 	}
 	t.closed = true
+	t.readQueue = nil
+	t.buffered = 0
+	if t.room != nil {
+		close(t.room)
+		t.room = nil
+	}
 	t.mu.Unlock()
 
 	close(t.closeChan)
@@ -136,20 +244,68 @@ func (t *JSTransport) Close() error {
 	return nil
 }
 
-// InjectData injects data received from JavaScript into the transport
+// InjectData queues data received from JavaScript. Data is never dropped;
+// callers should wait on Ready before injecting more.
 func (t *JSTransport) InjectData(data []byte) error {
 	t.mu.Lock()
+	defer t.mu.Unlock()
 	if t.closed {
-		t.mu.Unlock()
 		return errors.New("transport closed")
 	}
-	t.mu.Unlock()
+	if len(data) == 0 {
+		return nil
+	}
+
+	t.readQueue = append(t.readQueue, data)
+	t.buffered += len(data)
 
 	select {
-	case t.readChan <- data:
-		return nil
+	case t.readable <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// endRead makes Read return err once the data already queued has been read
+func (t *JSTransport) endRead(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.readErr == nil {
+		t.readErr = err
+	}
+
+	select {
+	case t.readable <- struct{}{}:
 	default:
-		return errors.New("read buffer full")
+	}
+}
+
+// Ready returns a channel that is closed once fewer bytes than the high-water
+// mark are waiting to be read, or the transport is closed
+func (t *JSTransport) Ready() <-chan struct{} {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed || t.buffered < t.highWaterMark {
+		return closedChan
+	}
+	if t.room == nil {
+		t.room = make(chan struct{})
+	}
+	return t.room
+}
+
+// SetHighWaterMark sets how many received bytes may be buffered before Ready
+// holds back further data
+func (t *JSTransport) SetHighWaterMark(bytes int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if bytes <= 0 {
+		bytes = DefaultHighWaterMark
+	}
+	t.highWaterMark = bytes
+	if t.room != nil && t.buffered < t.highWaterMark {
+		close(t.room)
+		t.room = nil
 	}
 }
 
@@ -164,23 +320,85 @@ func (t *JSTransport) RemoteAddr() net.Addr {
 }
 
 // SetDeadline sets the read and write deadlines
-func (t *JSTransport) SetDeadline(time time.Time) error {
-	// Not implemented for JS transport
+func (t *JSTransport) SetDeadline(deadline time.Time) error {
+	t.readDeadline.set(deadline)
+	t.writeDeadline.set(deadline)
 	return nil
 }
 
-// SetReadDeadline sets the read deadline
-func (t *JSTransport) SetReadDeadline(time time.Time) error {
-	// Not implemented for JS transport
+// SetReadDeadline sets the deadline for blocked and future Read calls
+func (t *JSTransport) SetReadDeadline(deadline time.Time) error {
+	t.readDeadline.set(deadline)
 	return nil
 }
 
-// SetWriteDeadline sets the write deadline
-func (t *JSTransport) SetWriteDeadline(time time.Time) error {
-	// Not implemented for JS transport
+// SetWriteDeadline sets the deadline for blocked and future Write calls
+func (t *JSTransport) SetWriteDeadline(deadline time.Time) error {
+	t.writeDeadline.set(deadline)
 	return nil
 }
 
+// deadline is a channel that closes when a deadline passes, as in net.Pipe
+type deadline struct {
+	mu     sync.Mutex
+	timer  *time.Timer
+	cancel chan struct{}
+}
+
+func newDeadline() *deadline {
+	return &deadline{cancel: make(chan struct{})}
+}
+
+// set moves the deadline; the zero time clears it
+func (d *deadline) set(t time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.timer != nil && !d.timer.Stop() {
+		<-d.cancel // Wait for the timer callback to finish closing it
+	}
+	d.timer = nil
+
+	closed := isClosedChan(d.cancel)
+	if t.IsZero() {
+		if closed {
+			d.cancel = make(chan struct{})
+		}
+		return
+	}
+
+	if wait := time.Until(t); wait > 0 {
+		if closed {
+			d.cancel = make(chan struct{})
+		}
+		cancel := d.cancel
+		d.timer = time.AfterFunc(wait, func() {
+			close(cancel)
+		})
+		return
+	}
+
+	if !closed {
+		close(d.cancel)
+	}
+}
+
+// wait returns a channel that is closed once the deadline passes
+func (d *deadline) wait() chan struct{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.cancel
+}
+
+func isClosedChan(c <-chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
+
 // TransportManager manages active transports
 type TransportManager struct {
 	transports map[string]*JSTransport
@@ -211,4 +429,4 @@ func RemoveTransport(id string) {
 	transportManager.mu.Lock()
 	defer transportManager.mu.Unlock()
 	delete(transportManager.transports, id)
-}
\ No newline at end of file
+}
@@ -0,0 +1,64 @@
+package sshclient
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestJSTransportBreaksAfterTimedOutWrite(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	closed := 0
+	transport := NewJSTransport("test", func(data []byte) error {
+		<-release
+		return nil
+	}, func() error {
+		closed++
+		return nil
+	})
+
+	transport.SetWriteDeadline(time.Now().Add(20 * time.Millisecond))
+	_, err := transport.Write([]byte("stuck"))
+	if !errors.Is(err, os.ErrDeadlineExceeded) || !errors.Is(err, ErrTransportBroken) {
+		t.Fatalf("timed-out write: err = %v, want a deadline error breaking the transport", err)
+	}
+	if closed != 1 {
+		t.Errorf("onClose called %d times, want 1", closed)
+	}
+
+	// Clearing the deadline must not revive the stream
+	transport.SetWriteDeadline(time.Time{})
+	if _, err := transport.Write([]byte("more")); !errors.Is(err, ErrTransportBroken) {
+		t.Errorf("write after the timeout: err = %v, want ErrTransportBroken", err)
+	}
+	if _, err := transport.Read(make([]byte, 8)); !errors.Is(err, ErrTransportBroken) {
+		t.Errorf("read after the timeout: err = %v, want ErrTransportBroken", err)
+	}
+	if err := transport.InjectData([]byte("late")); err == nil {
+		t.Error("data injected into a broken transport")
+	}
+}
+
+func TestJSTransportExpiredDeadlineSendsNothing(t *testing.T) {
+	var written [][]byte
+	transport := NewJSTransport("test", func(data []byte) error {
+		written = append(written, data)
+		return nil
+	}, nil)
+
+	// A deadline that passed before the write hands nothing to onWrite,
+	// so the stream stays usable
+	transport.SetWriteDeadline(time.Now().Add(-time.Second))
+	if _, err := transport.Write([]byte("late")); !errors.Is(err, os.ErrDeadlineExceeded) || errors.Is(err, ErrTransportBroken) {
+		t.Fatalf("write past the deadline: err = %v", err)
+	}
+	transport.SetWriteDeadline(time.Time{})
+	if n, err := transport.Write([]byte("ok")); err != nil || n != 2 {
+		t.Fatalf("write after clearing the deadline = %d, %v", n, err)
+	}
+	if len(written) != 1 || string(written[0]) != "ok" {
+		t.Errorf("onWrite got %q, want only the second write", written)
+	}
+}
@@ -0,0 +1,302 @@
+//go:build js && wasm
+
+package sshclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"syscall/js"
+	"time"
+)
+
+// DefaultMaxBufferedAmount is how many bytes a WebSocketTransport lets the
+// browser hold in its send buffer before Write waits for them to go out
+const DefaultMaxBufferedAmount = 1 << 20
+
+// WebSocket readyState values
+const (
+	wsConnecting = 0
+	wsOpen       = 1
+)
+
+// WebSocketOptions configures DialWebSocket
+type WebSocketOptions struct {
+	// Protocols are the subprotocols offered to the server
+	Protocols []string
+	// MaxBufferedAmount is how many bytes may wait in the browser's send
+	// buffer before Write blocks, DefaultMaxBufferedAmount by default
+	MaxBufferedAmount int
+}
+
+// WebSocketCloseError reports the close frame that ended a WebSocket
+type WebSocketCloseError struct {
+	Code     int
+	Reason   string
+	WasClean bool
+}
+
+func (e *WebSocketCloseError) Error() string {
+	if e.Reason != "" {
+		return fmt.Sprintf("websocket closed with code %d: %s", e.Code, e.Reason)
+	}
+	return fmt.Sprintf("websocket closed with code %d", e.Code)
+}
+
+// WebSocketTransport is a Transport over a browser WebSocket driven directly
+// from Go. Received frames are queued like injected data on a JSTransport.
+type WebSocketTransport struct {
+	transport         *JSTransport
+	ws                js.Value
+	url               string
+	maxBufferedAmount int
+	handlers          []js.Func
+	// opened and done are closed by the open and close events
+	opened    chan struct{}
+	done      chan struct{}
+	closeErr  *WebSocketCloseError
+	closeCode int
+	reason    string
+	mu        sync.Mutex
+}
+
+// DialWebSocket opens a WebSocket to url and blocks until it is open, fails or
+// ctx ends. It must not be called on the JavaScript event loop.
+func DialWebSocket(ctx context.Context, url string, options WebSocketOptions) (*WebSocketTransport, error) {
+	ws, err := newWebSocket(url, options.Protocols)
+	if err != nil {
+		return nil, err
+	}
+	ws.Set("binaryType", "arraybuffer")
+
+	t := &WebSocketTransport{
+		ws:                ws,
+		url:               url,
+		maxBufferedAmount: options.MaxBufferedAmount,
+		opened:            make(chan struct{}),
+		done:              make(chan struct{}),
+		closeCode:         1000,
+	}
+	if t.maxBufferedAmount <= 0 {
+		t.maxBufferedAmount = DefaultMaxBufferedAmount
+	}
+	t.transport = NewJSTransport(url, t.send, t.closeSocket)
+	t.transport.remoteAddr = &TransportAddr{network: "websocket", address: url}
+
+	t.handle("onopen", func(event js.Value) {
+		close(t.opened)
+	})
+	t.handle("onmessage", t.onMessage)
+	t.handle("onclose", t.onClose)
+
+	select {
+	case <-t.opened:
+		return t, nil
+	case <-t.done:
+		return nil, fmt.Errorf("failed to connect to %s: %v", url, t.closeErr)
+	case <-ctx.Done():
+		t.Close()
+		return nil, fmt.Errorf("failed to connect to %s: %w", url, ctx.Err())
+	}
+}
+
+// newWebSocket constructs the browser WebSocket, turning the exceptions it
+// throws for bad URLs and protocols into errors
+func newWebSocket(url string, protocols []string) (ws js.Value, err error) {
+	constructor := js.Global().Get("WebSocket")
+	if constructor.Type() != js.TypeFunction {
+		return js.Value{}, errors.New("WebSocket is not available in this environment")
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			jsErr, ok := r.(js.Error)
+			if !ok {
+				panic(r)
+			}
+			err = fmt.Errorf("failed to open WebSocket: %v", jsErr)
+		}
+	}()
+
+	jsProtocols := make([]interface{}, len(protocols))
+	for i, protocol := range protocols {
+		jsProtocols[i] = protocol
+	}
+	return constructor.New(url, jsProtocols), nil
+}
+
+// handle sets an event handler on the socket, released once it closes
+func (t *WebSocketTransport) handle(name string, handler func(event js.Value)) {
+	fn := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		event := js.Undefined()
+		if len(args) > 0 {
+			event = args[0]
+		}
+		handler(event)
+		return nil
+	})
+	t.handlers = append(t.handlers, fn)
+	t.ws.Set(name, fn)
+}
+
+func (t *WebSocketTransport) onMessage(event js.Value) {
+	data := event.Get("data")
+	if data.Type() == js.TypeString {
+		// SSH only travels in binary frames. Browsers cannot send 1003
+		// (unsupported data), so the reason carries it.
+		t.transport.endRead(errors.New("websocket received a text frame"))
+		t.ws.Call("close", 1000, "text frames are not supported")
+		return
+	}
+
+	buf := js.Global().Get("Uint8Array").New(data)
+	received := make([]byte, buf.Length())
+	js.CopyBytesToGo(received, buf)
+	t.transport.InjectData(received)
+}
+
+func (t *WebSocketTransport) onClose(event js.Value) {
+	closeErr := &WebSocketCloseError{
+		Code:     event.Get("code").Int(),
+		Reason:   event.Get("reason").String(),
+		WasClean: event.Get("wasClean").Bool(),
+	}
+
+	t.mu.Lock()
+	t.closeErr = closeErr
+	t.mu.Unlock()
+
+	t.transport.endRead(closeErr)
+	close(t.done)
+
+	for _, name := range []string{"onopen", "onmessage", "onclose"} {
+		t.ws.Set(name, js.Null())
+	}
+	for _, fn := range t.handlers {
+		fn.Release()
+	}
+	t.handlers = nil
+}
+
+// send is the JSTransport write callback. The browser never blocks in send,
+// so backpressure comes from waiting for bufferedAmount to drain.
+func (t *WebSocketTransport) send(data []byte) error {
+	if t.ws.Get("readyState").Int() != wsOpen {
+		if err := t.CloseError(); err != nil {
+			return err
+		}
+		return errors.New("websocket is not open")
+	}
+
+	frame := js.Global().Get("Uint8Array").New(len(data))
+	js.CopyBytesToJS(frame, data)
+	t.ws.Call("send", frame)
+
+	for t.ws.Get("bufferedAmount").Int() > t.maxBufferedAmount {
+		select {
+		case <-t.done:
+			return t.CloseError()
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	return nil
+}
+
+// closeSocket is the JSTransport close callback
+func (t *WebSocketTransport) closeSocket() error {
+	t.mu.Lock()
+	code, reason := t.closeCode, t.reason
+	t.mu.Unlock()
+
+	state := t.ws.Get("readyState").Int()
+	if state == wsConnecting || state == wsOpen {
+		t.ws.Call("close", code, reason)
+	}
+	return nil
+}
+
+// Read reads received data. Once the socket has closed and everything
+// received was read, it returns a *WebSocketCloseError.
+func (t *WebSocketTransport) Read(p []byte) (int, error) {
+	return t.transport.Read(p)
+}
+
+// Write sends p as one binary frame
+func (t *WebSocketTransport) Write(p []byte) (int, error) {
+	return t.transport.Write(p)
+}
+
+// Close closes the socket with the normal closure code 1000
+func (t *WebSocketTransport) Close() error {
+	return t.transport.Close()
+}
+
+// CloseWithCode closes the socket with code, which must be 1000 or in the
+// 3000-4999 range applications may use, and a reason of up to 123 bytes
+func (t *WebSocketTransport) CloseWithCode(code int, reason string) error {
+	if code != 1000 && (code < 3000 || code > 4999) {
+		return fmt.Errorf("invalid websocket close code %d", code)
+	}
+	if len(reason) > 123 {
+		return errors.New("websocket close reason is longer than 123 bytes")
+	}
+
+	t.mu.Lock()
+	t.closeCode = code
+	t.reason = reason
+	t.mu.Unlock()
+	return t.transport.Close()
+}
+
+// CloseError returns how the socket was closed, or nil while it is open
+func (t *WebSocketTransport) CloseError() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closeErr == nil {
+		return nil
+	}
+	return t.closeErr
+}
+
+// Done is closed once the socket has closed
+func (t *WebSocketTransport) Done() <-chan struct{} {
+	return t.done
+}
+
+// Protocol returns the subprotocol the server selected
+func (t *WebSocketTransport) Protocol() string {
+	return t.ws.Get("protocol").String()
+}
+
+// URL returns the URL the socket was opened to
+func (t *WebSocketTransport) URL() string {
+	return t.url
+}
+
+// LocalAddr returns the local network address
+func (t *WebSocketTransport) LocalAddr() net.Addr {
+	return t.transport.LocalAddr()
+}
+
+// RemoteAddr returns the socket URL as the remote address
+func (t *WebSocketTransport) RemoteAddr() net.Addr {
+	return t.transport.RemoteAddr()
+}
+
+// SetDeadline sets the read and write deadlines
+func (t *WebSocketTransport) SetDeadline(deadline time.Time) error {
+	return t.transport.SetDeadline(deadline)
+}
+
+// SetReadDeadline sets the deadline for blocked and future Read calls
+func (t *WebSocketTransport) SetReadDeadline(deadline time.Time) error {
+	return t.transport.SetReadDeadline(deadline)
+}
+
+// SetWriteDeadline sets the deadline for blocked and future Write calls,
+// including waiting for the send buffer to drain
+func (t *WebSocketTransport) SetWriteDeadline(deadline time.Time) error {
+	return t.transport.SetWriteDeadline(deadline)
+}